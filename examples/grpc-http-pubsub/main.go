@@ -4,7 +4,6 @@ import (
 	"context"
 	"github.com/blendle/zapdriver"
 	"github.com/grpc-ecosystem/go-grpc-middleware/util/metautils"
-	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/petomalina/xrpc/v2/examples/api"
 	"github.com/petomalina/xrpc/v2/pkg/multiplexer"
 	"github.com/petomalina/xrpc/v2/pkg/server"
@@ -45,22 +44,21 @@ func main() {
 	api.RegisterEchoServiceServer(grpcServer, echoSvc)
 	reflection.Register(grpcServer)
 
-	// create the grpc-gateway server and register to grpc server
-	gwmux := runtime.NewServeMux()
-	err = api.RegisterEchoServiceHandlerFromEndpoint(ctx, gwmux, ":"+os.Getenv("PORT"), []grpc.DialOption{grpc.WithInsecure()})
+	// create the transcoder calling the annotated grpc methods in-process
+	transcoder, err := multiplexer.NewTranscoder(grpcServer)
 	if err != nil {
-		logger.Fatal("gw: failed to register: %v", zap.Error(err))
+		logger.Fatal("transcoder: failed to create", zap.Error(err))
 	}
 
 	// make multiplexer
 	handler := multiplexer.Make(nil,
 		// filters all application/grpc messages into the grpc server
 		multiplexer.GRPCHandler(grpcServer),
-		// filters all messages with Google Agent into the gwmux and
+		// filters all messages with Google Agent into the transcoder and
 		// unpacks the PubSub message
-		multiplexer.PubSubHandler(gwmux),
-		// defaults all other messages into the http multiplexer
-		multiplexer.HTTPHandler(gwmux),
+		multiplexer.PubSubHandler(transcoder),
+		// transcodes all JSON messages matching the google.api.http annotations
+		multiplexer.TranscodingHandler(transcoder),
 	)
 
 	err = server.Start(ctx, os.Getenv("PORT"), time.Second*30, handler)
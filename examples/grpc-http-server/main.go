@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"github.com/grpc-ecosystem/go-grpc-middleware/util/metautils"
-	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/petomalina/xrpc/v2/examples/api"
 	"github.com/petomalina/xrpc/v2/pkg/multiplexer"
 	"github.com/petomalina/xrpc/v2/pkg/server"
@@ -38,11 +37,10 @@ func main() {
 	api.RegisterEchoServiceServer(grpcServer, echoSvc)
 	reflection.Register(grpcServer)
 
-	// create the grpc-gateway server and register to grpc server
-	gwmux := runtime.NewServeMux()
-	err = api.RegisterEchoServiceHandlerFromEndpoint(ctx, gwmux, ":"+os.Getenv("PORT"), []grpc.DialOption{grpc.WithInsecure()})
+	// create the transcoder calling the annotated grpc methods in-process
+	transcoder, err := multiplexer.NewTranscoder(grpcServer)
 	if err != nil {
-		logger.Fatal("gw: failed to register", zap.Error(err))
+		logger.Fatal("transcoder: failed to create", zap.Error(err))
 	}
 
 	srv, err := server.New(os.Getenv("PORT"), time.Second*5)
@@ -55,8 +53,8 @@ func main() {
 		multiplexer.Make(nil,
 			// filters all application/grpc messages into the grpc server
 			multiplexer.GRPCHandler(grpcServer),
-			// defaults all other messages into the http multiplexer
-			multiplexer.HTTPHandler(gwmux),
+			// transcodes all JSON messages matching the google.api.http annotations
+			multiplexer.TranscodingHandler(transcoder),
 		))
 	done()
 
@@ -4,7 +4,6 @@ import (
 	"context"
 	"github.com/blendle/zapdriver"
 	"github.com/grpc-ecosystem/go-grpc-middleware/util/metautils"
-	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/petomalina/xrpc/v2/examples/api"
 	"github.com/petomalina/xrpc/v2/pkg/multiplexer"
 	"github.com/petomalina/xrpc/v2/pkg/server"
@@ -45,11 +44,10 @@ func main() {
 	api.RegisterEchoServiceServer(grpcServer, echoSvc)
 	reflection.Register(grpcServer)
 
-	// create the grpc-gateway server and register to grpc server
-	gwmux := runtime.NewServeMux()
-	err = api.RegisterEchoServiceHandlerFromEndpoint(ctx, gwmux, ":"+os.Getenv("PORT"), []grpc.DialOption{grpc.WithInsecure()})
+	// create the transcoder calling the annotated grpc methods in-process
+	transcoder, err := multiplexer.NewTranscoder(grpcServer)
 	if err != nil {
-		logger.Fatal("gw: failed to register: %v", zap.Error(err))
+		logger.Fatal("transcoder: failed to create", zap.Error(err))
 	}
 
 	srv, err := server.New(
@@ -64,8 +62,8 @@ func main() {
 	err = srv.ServeHTTPHandler(ctx, multiplexer.Make(nil,
 		// filters all application/grpc messages into the grpc server
 		multiplexer.GRPCHandler(grpcServer),
-		// defaults all other messages into the http multiplexer
-		multiplexer.HTTPHandler(gwmux),
+		// transcodes all JSON messages matching the google.api.http annotations
+		multiplexer.TranscodingHandler(transcoder),
 	))
 	if err != nil {
 		logger.Fatal("error serving the server", zap.Error(err))
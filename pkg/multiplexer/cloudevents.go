@@ -0,0 +1,188 @@
+package multiplexer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrAmbiguousCloudEventsData is returned if a structured CloudEvent declares
+	// both data and data_base64 members, which is forbidden by the specification
+	ErrAmbiguousCloudEventsData = errors.New("cloudevents: both data and data_base64 are set")
+)
+
+const (
+	// CloudEventsStructuredContentType is the content type of CloudEvents sent in
+	// the structured content mode
+	CloudEventsStructuredContentType = "application/cloudevents+json"
+
+	// cloudEventsBinaryPrefix is the prefix of headers carrying the event attributes
+	// in the binary content mode
+	cloudEventsBinaryPrefix = "Ce-"
+)
+
+// CloudEventsAttribute are context attributes known to be sent with every CloudEvent.
+// Extension attributes are exposed the same way as the known ones
+type CloudEventsAttribute string
+
+const (
+	// CloudEventsID is the 'id' context attribute
+	CloudEventsID CloudEventsAttribute = "id"
+	// CloudEventsSource is the 'source' context attribute
+	CloudEventsSource CloudEventsAttribute = "source"
+	// CloudEventsType is the 'type' context attribute
+	CloudEventsType CloudEventsAttribute = "type"
+	// CloudEventsSubject is the 'subject' context attribute
+	CloudEventsSubject CloudEventsAttribute = "subject"
+	// CloudEventsTime is the 'time' context attribute
+	CloudEventsTime CloudEventsAttribute = "time"
+	// CloudEventsSpecVersion is the 'specversion' context attribute
+	CloudEventsSpecVersion CloudEventsAttribute = "specversion"
+	// CloudEventsDataContentType is the 'datacontenttype' context attribute
+	CloudEventsDataContentType CloudEventsAttribute = "datacontenttype"
+)
+
+// CloudEventsAttributeHeader returns a key for header access to the CloudEvent
+// context attributes and extensions (see CloudEventsAttribute)
+func CloudEventsAttributeHeader(attr CloudEventsAttribute) string {
+	return "x-cloudevents-" + string(attr)
+}
+
+// IsCloudEventsRequest returns true if the given request carries a CloudEvent
+// in either the binary (ce-* headers) or the structured content mode
+func IsCloudEventsRequest(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+
+	return r.Header.Get(cloudEventsBinaryPrefix+string(CloudEventsSpecVersion)) != "" ||
+		strings.HasPrefix(r.Header.Get("Content-Type"), CloudEventsStructuredContentType)
+}
+
+// CloudEventsHandler fulfills requests that are considered to be CloudEvents requests
+// (e.g. Eventarc triggers), unwrapping the event data into the body and appending
+// the event attributes as headers
+func CloudEventsHandler(handler http.Handler, selectors ...Selector) Handler {
+	filter := append([]Selector{IsCloudEventsRequest}, selectors...)
+
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for _, f := range filter {
+			if !f(r) {
+				return false
+			}
+		}
+
+		req, err := InterceptCloudEventsRequest(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return true
+		}
+
+		handler.ServeHTTP(w, req)
+		return true
+	}
+}
+
+// InterceptCloudEventsRequest mutates the given http.Request so its body contains only
+// the event data and its Content-Type matches the event datacontenttype. All context
+// attributes and extensions are added into headers
+func InterceptCloudEventsRequest(r *http.Request) (*http.Request, error) {
+	attributes := map[string]string{}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), CloudEventsStructuredContentType) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := decodeStructuredCloudEvent(body, attributes)
+		if err != nil {
+			return nil, err
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(data))
+		r.ContentLength = int64(len(data))
+
+		contentType := attributes[string(CloudEventsDataContentType)]
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		r.Header.Set("Content-Type", contentType)
+	} else {
+		// the binary mode already carries the data in the body, only the
+		// attributes need to be collected
+		for k, vv := range r.Header {
+			if !strings.HasPrefix(k, cloudEventsBinaryPrefix) || len(vv) == 0 {
+				continue
+			}
+			attributes[strings.ToLower(strings.TrimPrefix(k, cloudEventsBinaryPrefix))] = vv[0]
+		}
+	}
+
+	// the Grpc-Metadata- prefix is stripped by the grpc-gateway, so these headers
+	// are accessible by their original names
+	for k, v := range attributes {
+		r.Header.Add(gatewayMetadataPrefix+CloudEventsAttributeHeader(CloudEventsAttribute(k)), v)
+	}
+
+	return r, nil
+}
+
+// decodeStructuredCloudEvent collects the context attributes of the structured event
+// into the given map and returns the event data
+func decodeStructuredCloudEvent(body []byte, attributes map[string]string) ([]byte, error) {
+	event := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+
+	for k, v := range event {
+		if k == "data" || k == "data_base64" {
+			continue
+		}
+
+		// attributes are mostly strings, other types (e.g. integer extensions)
+		// are exposed in their JSON form
+		var str string
+		if err := json.Unmarshal(v, &str); err == nil {
+			attributes[k] = str
+		} else {
+			attributes[k] = string(v)
+		}
+	}
+
+	data, hasData := event["data"]
+	encoded, hasBase64 := event["data_base64"]
+
+	switch {
+	case hasData && hasBase64:
+		return nil, ErrAmbiguousCloudEventsData
+
+	case hasBase64:
+		var str string
+		if err := json.Unmarshal(encoded, &str); err != nil {
+			return nil, fmt.Errorf("cloudevents: data_base64 is not a string: %w", err)
+		}
+		return base64.StdEncoding.DecodeString(str)
+
+	case hasData:
+		// non-JSON data (e.g. text/plain) is carried as a JSON string
+		contentType := attributes[string(CloudEventsDataContentType)]
+		if contentType != "" && !strings.Contains(contentType, "json") {
+			var str string
+			if err := json.Unmarshal(data, &str); err == nil {
+				return []byte(str), nil
+			}
+		}
+		return data, nil
+	}
+
+	return []byte{}, nil
+}
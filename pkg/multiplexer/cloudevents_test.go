@@ -0,0 +1,169 @@
+package multiplexer
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type CloudEventsSuite struct {
+	suite.Suite
+}
+
+func (s *CloudEventsSuite) TestIsCloudEventsRequest() {
+	candidates := map[*http.Request]bool{
+		{
+			Method: http.MethodPost,
+			Header: map[string][]string{
+				"Ce-Specversion": {"1.0"},
+				"Content-Type":   {"application/json"},
+			},
+		}: true,
+		{
+			Method: http.MethodPost,
+			Header: map[string][]string{
+				"Content-Type": {"application/cloudevents+json; charset=UTF-8"},
+			},
+		}: true,
+		// CloudEvents are always delivered by POST
+		{
+			Method: http.MethodGet,
+			Header: map[string][]string{
+				"Ce-Specversion": {"1.0"},
+			},
+		}: false,
+		{
+			Method: http.MethodPost,
+			Header: map[string][]string{
+				"Content-Type": {"application/json"},
+			},
+		}: false,
+	}
+
+	for req, result := range candidates {
+		s.Equal(result, IsCloudEventsRequest(req), "Request is badly considered a cloudevents request", req.Header)
+	}
+}
+
+func (s *CloudEventsSuite) TestIsPubSubRequestExcludesEventarc() {
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/echo", nil)
+	req.Header.Set("User-Agent", "APIs-Google; (+https://developers.google.com/webmasters/APIs-Google.html)")
+	s.True(IsPubSubRequest(req))
+
+	req.Header.Set("Ce-Specversion", "1.0")
+	s.False(IsPubSubRequest(req))
+}
+
+func (s *CloudEventsSuite) TestInterceptCloudEventsRequest() {
+	structured := `{
+		"specversion": "1.0",
+		"id": "abc12345",
+		"source": "//pubsub.googleapis.com/projects/my-project/topics/my-topic",
+		"type": "google.cloud.pubsub.topic.v1.messagePublished",
+		"subject": "my-subject",
+		"time": "2020-09-29T11:32:00.000Z",
+		"myextension": 42,
+		"datacontenttype": "application/json",
+		"data": {"message": "Hello World"}
+	}`
+
+	binary := httptest.NewRequest(http.MethodPost, "http://localhost/echo", strings.NewReader(`{"message":"Hello World"}`))
+	binary.Header.Set("Content-Type", "application/json")
+	binary.Header.Set("Ce-Specversion", "1.0")
+	binary.Header.Set("Ce-Id", "abc12345")
+	binary.Header.Set("Ce-Source", "//pubsub.googleapis.com/projects/my-project/topics/my-topic")
+	binary.Header.Set("Ce-Type", "google.cloud.pubsub.topic.v1.messagePublished")
+	binary.Header.Set("Ce-Subject", "my-subject")
+	binary.Header.Set("Ce-Time", "2020-09-29T11:32:00.000Z")
+	binary.Header.Set("Ce-Myextension", "42")
+
+	structuredReq := httptest.NewRequest(http.MethodPost, "http://localhost/echo", strings.NewReader(structured))
+	structuredReq.Header.Set("Content-Type", CloudEventsStructuredContentType)
+
+	expectedHeaders := map[string]string{
+		"Grpc-Metadata-x-cloudevents-id":          "abc12345",
+		"Grpc-Metadata-x-cloudevents-source":      "//pubsub.googleapis.com/projects/my-project/topics/my-topic",
+		"Grpc-Metadata-x-cloudevents-type":        "google.cloud.pubsub.topic.v1.messagePublished",
+		"Grpc-Metadata-x-cloudevents-subject":     "my-subject",
+		"Grpc-Metadata-x-cloudevents-time":        "2020-09-29T11:32:00.000Z",
+		"Grpc-Metadata-x-cloudevents-myextension": "42",
+		"Content-Type": "application/json",
+	}
+
+	candidates := map[*http.Request]InterceptedResult{
+		binary: {
+			Body:    `{"message":"Hello World"}`,
+			Headers: expectedHeaders,
+		},
+		structuredReq: {
+			Body:    `{"message": "Hello World"}`,
+			Headers: expectedHeaders,
+		},
+	}
+
+	for req, result := range candidates {
+		intercepted, err := InterceptCloudEventsRequest(req)
+		s.Equal(result.Error, err)
+
+		interceptedBody, err := ioutil.ReadAll(intercepted.Body)
+		s.NoError(err)
+		s.Equal(result.Body, string(interceptedBody))
+		s.Equal(int64(len(result.Body)), intercepted.ContentLength)
+
+		for k, v := range result.Headers {
+			s.Equal(v, intercepted.Header.Get(k), k)
+		}
+	}
+}
+
+func (s *CloudEventsSuite) TestInterceptStructuredData() {
+	candidates := map[string]string{
+		`{"specversion":"1.0","id":"1","data_base64":"SGVsbG8gV29ybGQ="}`:                    "Hello World",
+		`{"specversion":"1.0","id":"1","datacontenttype":"text/plain","data":"Hello World"}`: "Hello World",
+		`{"specversion":"1.0","id":"1"}`:                                                     "",
+	}
+
+	for event, data := range candidates {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/echo", bytes.NewBufferString(event))
+		req.Header.Set("Content-Type", CloudEventsStructuredContentType)
+
+		intercepted, err := InterceptCloudEventsRequest(req)
+		s.NoError(err)
+
+		body, err := ioutil.ReadAll(intercepted.Body)
+		s.NoError(err)
+		s.Equal(data, string(body))
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/echo",
+		strings.NewReader(`{"specversion":"1.0","id":"1","data":{},"data_base64":""}`))
+	req.Header.Set("Content-Type", CloudEventsStructuredContentType)
+	_, err := InterceptCloudEventsRequest(req)
+	s.ErrorIs(err, ErrAmbiguousCloudEventsData)
+}
+
+func (s *CloudEventsSuite) TestCloudEventsHandler() {
+	var received *http.Request
+	handler := CloudEventsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/echo", strings.NewReader("{"))
+	req.Header.Set("Content-Type", CloudEventsStructuredContentType)
+	rec := httptest.NewRecorder()
+	s.True(handler(rec, req))
+	s.Equal(http.StatusBadRequest, rec.Code)
+	s.Nil(received)
+
+	req = httptest.NewRequest(http.MethodPost, "http://localhost/echo", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	s.False(handler(httptest.NewRecorder(), req))
+}
+
+func TestCloudEventsSuite(t *testing.T) {
+	suite.Run(t, &CloudEventsSuite{})
+}
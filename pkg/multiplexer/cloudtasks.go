@@ -0,0 +1,89 @@
+package multiplexer
+
+import (
+	"net/http"
+)
+
+// cloudTasksHeaderPrefix is the prefix of headers carrying the task metadata
+const cloudTasksHeaderPrefix = "X-CloudTasks-"
+
+// CloudTasksAttribute are task attributes known to be sent by Cloud Tasks in the
+// X-CloudTasks-* headers of HTTP target requests
+type CloudTasksAttribute string
+
+const (
+	// CloudTasksQueueName is the name of the queue (X-CloudTasks-QueueName)
+	CloudTasksQueueName CloudTasksAttribute = "queue-name"
+	// CloudTasksTaskName is the short name of the task, or a unique system-generated
+	// ID if no name was specified (X-CloudTasks-TaskName)
+	CloudTasksTaskName CloudTasksAttribute = "task-name"
+	// CloudTasksTaskRetryCount is the number of times the task has been retried
+	// (X-CloudTasks-TaskRetryCount)
+	CloudTasksTaskRetryCount CloudTasksAttribute = "task-retry-count"
+	// CloudTasksTaskExecutionCount is the number of times the task received a response
+	// from the handler (X-CloudTasks-TaskExecutionCount)
+	CloudTasksTaskExecutionCount CloudTasksAttribute = "task-execution-count"
+	// CloudTasksTaskETA is the schedule time of the task in seconds since the epoch
+	// (X-CloudTasks-TaskETA)
+	CloudTasksTaskETA CloudTasksAttribute = "task-eta"
+	// CloudTasksTaskPreviousResponse is the http status of the previous attempt
+	// (X-CloudTasks-TaskPreviousResponse)
+	CloudTasksTaskPreviousResponse CloudTasksAttribute = "task-previous-response"
+	// CloudTasksTaskRetryReason is the reason of retrying the task (X-CloudTasks-TaskRetryReason)
+	CloudTasksTaskRetryReason CloudTasksAttribute = "task-retry-reason"
+)
+
+// cloudTasksHeaders maps the task attributes to the headers sent by Cloud Tasks
+var cloudTasksHeaders = map[CloudTasksAttribute]string{
+	CloudTasksQueueName:            cloudTasksHeaderPrefix + "QueueName",
+	CloudTasksTaskName:             cloudTasksHeaderPrefix + "TaskName",
+	CloudTasksTaskRetryCount:       cloudTasksHeaderPrefix + "TaskRetryCount",
+	CloudTasksTaskExecutionCount:   cloudTasksHeaderPrefix + "TaskExecutionCount",
+	CloudTasksTaskETA:              cloudTasksHeaderPrefix + "TaskETA",
+	CloudTasksTaskPreviousResponse: cloudTasksHeaderPrefix + "TaskPreviousResponse",
+	CloudTasksTaskRetryReason:      cloudTasksHeaderPrefix + "TaskRetryReason",
+}
+
+// CloudTasksAttributeHeader returns a key for header access to the task
+// attributes (see CloudTasksAttribute)
+func CloudTasksAttributeHeader(attr CloudTasksAttribute) string {
+	return "x-cloudtasks-" + string(attr)
+}
+
+// IsCloudTasksRequest returns true if the given request is dispatched by Cloud Tasks
+// to an HTTP target. Such requests always carry the name of their queue
+func IsCloudTasksRequest(r *http.Request) bool {
+	return r.Header.Get(cloudTasksHeaders[CloudTasksQueueName]) != ""
+}
+
+// CloudTasksHandler fulfills requests that are considered to be Cloud Tasks requests,
+// appending the task metadata as headers. The body of the task is passed through
+// untouched
+func CloudTasksHandler(handler http.Handler, selectors ...Selector) Handler {
+	filter := append([]Selector{IsCloudTasksRequest}, selectors...)
+
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for _, f := range filter {
+			if !f(r) {
+				return false
+			}
+		}
+
+		handler.ServeHTTP(w, InterceptCloudTasksRequest(r))
+		return true
+	}
+}
+
+// InterceptCloudTasksRequest mutates the given http.Request, adding all task metadata
+// into headers
+func InterceptCloudTasksRequest(r *http.Request) *http.Request {
+	// the Grpc-Metadata- prefix is stripped by the grpc-gateway, so these headers
+	// are accessible by their original names
+	for attr, header := range cloudTasksHeaders {
+		if v := r.Header.Get(header); v != "" {
+			r.Header.Set(gatewayMetadataPrefix+CloudTasksAttributeHeader(attr), v)
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,91 @@
+package multiplexer
+
+import (
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type CloudTasksSuite struct {
+	suite.Suite
+}
+
+func (s *CloudTasksSuite) TestIsCloudTasksRequest() {
+	candidates := map[*http.Request]bool{
+		{
+			Method: http.MethodPost,
+			Header: map[string][]string{
+				"User-Agent":             {"Google-Cloud-Tasks"},
+				"X-Cloudtasks-Queuename": {"echo-queue"},
+			},
+		}: true,
+		// HTTP targets can be called with any method
+		{
+			Method: http.MethodPut,
+			Header: map[string][]string{
+				"X-Cloudtasks-Queuename": {"echo-queue"},
+			},
+		}: true,
+		{
+			Method: http.MethodPost,
+			Header: map[string][]string{
+				"User-Agent": {"Google-Cloud-Tasks"},
+			},
+		}: false,
+	}
+
+	for req, result := range candidates {
+		s.Equal(result, IsCloudTasksRequest(req), "Request is badly considered a cloudtasks request", req.Header)
+	}
+}
+
+func (s *CloudTasksSuite) TestIsPubSubRequestExcludesCloudTasks() {
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/echo", nil)
+	req.Header.Set("User-Agent", "APIs-Google; (+https://developers.google.com/webmasters/APIs-Google.html)")
+	s.True(IsPubSubRequest(req))
+
+	req.Header.Set("X-CloudTasks-QueueName", "echo-queue")
+	s.False(IsPubSubRequest(req))
+}
+
+func (s *CloudTasksSuite) TestCloudTasksHandler() {
+	var body string
+	var header http.Header
+
+	handler := CloudTasksHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body, header = string(data), r.Header
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/echo", strings.NewReader(`{"message":"Hello World"}`))
+	req.Header.Set("X-CloudTasks-QueueName", "echo-queue")
+	req.Header.Set("X-CloudTasks-TaskName", "task-1")
+	req.Header.Set("X-CloudTasks-TaskRetryCount", "2")
+	req.Header.Set("X-CloudTasks-TaskExecutionCount", "1")
+	req.Header.Set("X-CloudTasks-TaskETA", "1600000000.123")
+
+	s.True(handler(httptest.NewRecorder(), req))
+	s.Equal(`{"message":"Hello World"}`, body)
+
+	expected := map[CloudTasksAttribute]string{
+		CloudTasksQueueName:          "echo-queue",
+		CloudTasksTaskName:           "task-1",
+		CloudTasksTaskRetryCount:     "2",
+		CloudTasksTaskExecutionCount: "1",
+		CloudTasksTaskETA:            "1600000000.123",
+	}
+	for attr, v := range expected {
+		s.Equal(v, header.Get(gatewayMetadataPrefix+CloudTasksAttributeHeader(attr)), attr)
+	}
+	s.Empty(header.Get(gatewayMetadataPrefix + CloudTasksAttributeHeader(CloudTasksTaskRetryReason)))
+
+	// other requests are not fulfilled
+	s.False(handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "http://localhost/echo", nil)))
+}
+
+func TestCloudTasksSuite(t *testing.T) {
+	suite.Run(t, &CloudTasksSuite{})
+}
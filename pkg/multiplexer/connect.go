@@ -0,0 +1,455 @@
+package multiplexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ConnectProtocolVersionHeader is the header sent by Connect clients with the
+	// version of the protocol
+	ConnectProtocolVersionHeader = "Connect-Protocol-Version"
+	// ConnectTimeoutHeader is the header with the timeout of the call in milliseconds
+	ConnectTimeoutHeader = "Connect-Timeout-Ms"
+
+	// ContentTypeConnectProto is the content type of Connect unary binary requests
+	ContentTypeConnectProto = "application/proto"
+	// ContentTypeConnectJSON is the content type of Connect unary JSON requests
+	ContentTypeConnectJSON = "application/json"
+	// ContentTypeConnectStreamProto is the content type of Connect streaming binary requests
+	ContentTypeConnectStreamProto = "application/connect+proto"
+	// ContentTypeConnectStreamJSON is the content type of Connect streaming JSON requests
+	ContentTypeConnectStreamJSON = "application/connect+json"
+
+	// connectEndStreamFlag marks the last envelope of a Connect stream, carrying
+	// the error and the trailers of the call
+	connectEndStreamFlag = 0x02
+)
+
+var (
+	// ErrConnectCompression is returned if the Connect request is compressed, which
+	// is not supported by the ConnectHandler
+	ErrConnectCompression = errors.New("connect: compressed messages are not supported")
+)
+
+// connectCodes are the names of the grpc codes in the Connect protocol
+var connectCodes = map[codes.Code]string{
+	codes.Canceled:           "canceled",
+	codes.Unknown:            "unknown",
+	codes.InvalidArgument:    "invalid_argument",
+	codes.DeadlineExceeded:   "deadline_exceeded",
+	codes.NotFound:           "not_found",
+	codes.AlreadyExists:      "already_exists",
+	codes.PermissionDenied:   "permission_denied",
+	codes.ResourceExhausted:  "resource_exhausted",
+	codes.FailedPrecondition: "failed_precondition",
+	codes.Aborted:            "aborted",
+	codes.OutOfRange:         "out_of_range",
+	codes.Unimplemented:      "unimplemented",
+	codes.Internal:           "internal",
+	codes.Unavailable:        "unavailable",
+	codes.DataLoss:           "data_loss",
+	codes.Unauthenticated:    "unauthenticated",
+}
+
+// connectHTTPStatus are the http statuses of the unary Connect errors
+var connectHTTPStatus = map[codes.Code]int{
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// IsConnectRequest returns true if the request is a Connect unary or streaming request
+// of a /package.Service/Method path. Unary JSON requests must carry the
+// Connect-Protocol-Version header, so they are not mistaken for plain JSON requests
+func IsConnectRequest(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+	if !isFullMethodPath(r.URL.Path) {
+		return false
+	}
+
+	switch requestContentType(r) {
+	case ContentTypeConnectProto, ContentTypeConnectStreamProto, ContentTypeConnectStreamJSON:
+		return true
+	case ContentTypeConnectJSON:
+		return r.Header.Get(ConnectProtocolVersionHeader) != ""
+	}
+
+	return false
+}
+
+// ConnectHandler fulfills Connect requests by calling the methods of the given server,
+// which is usually a *grpc.Server, in-process. The messages are resolved from the
+// global protobuf registry, so the generated packages of the services must be imported
+func ConnectHandler(server http.Handler, selectors ...Selector) Handler {
+	filter := append([]Selector{IsConnectRequest}, selectors...)
+	conn := NewLocalConn(server)
+
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for _, f := range filter {
+			if !f(r) {
+				return false
+			}
+		}
+
+		switch requestContentType(r) {
+		case ContentTypeConnectStreamProto, ContentTypeConnectStreamJSON:
+			serveConnectStream(conn, w, r)
+		default:
+			serveConnectUnary(conn, w, r)
+		}
+
+		return true
+	}
+}
+
+// serveConnectUnary calls the unary method, responding with the message or
+// the Connect error
+func serveConnectUnary(conn *LocalConn, w http.ResponseWriter, r *http.Request) {
+	codec := newConnectCodec(requestContentType(r))
+
+	desc, err := findMethodDescriptor(r.URL.Path)
+	if err != nil {
+		writeConnectError(w, status.New(codes.Unimplemented, err.Error()))
+		return
+	}
+	if desc.IsStreamingClient() || desc.IsStreamingServer() {
+		writeConnectError(w, status.Newf(codes.Unimplemented, "connect: %s is a streaming method", r.URL.Path))
+		return
+	}
+	if r.Header.Get("Content-Encoding") != "" && r.Header.Get("Content-Encoding") != "identity" {
+		writeConnectError(w, status.New(codes.Unimplemented, ErrConnectCompression.Error()))
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeConnectError(w, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+
+	in := newMessage(desc.Input())
+	if err := codec.unmarshal(body, in); err != nil {
+		writeConnectError(w, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+
+	ctx, cancel, err := connectContext(r)
+	if err != nil {
+		writeConnectError(w, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+	defer cancel()
+
+	var header, trailer metadata.MD
+	out := newMessage(desc.Output())
+	err = conn.Invoke(ctx, r.URL.Path, in, out, grpc.Header(&header), grpc.Trailer(&trailer))
+
+	setHeaderMetadata(w.Header(), "", header)
+	setHeaderMetadata(w.Header(), "Trailer-", trailer)
+	if err != nil {
+		writeConnectError(w, status.Convert(err))
+		return
+	}
+
+	data, err := codec.marshal(out)
+	if err != nil {
+		writeConnectError(w, status.New(codes.Internal, err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", codec.contentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// serveConnectStream calls the streaming method, responding with the enveloped
+// messages followed by the end of stream envelope
+func serveConnectStream(conn *LocalConn, w http.ResponseWriter, r *http.Request) {
+	codec := newConnectCodec(requestContentType(r))
+	w.Header().Set("Content-Type", codec.contentType)
+
+	desc, err := findMethodDescriptor(r.URL.Path)
+	if err != nil {
+		writeConnectEndStream(w, status.New(codes.Unimplemented, err.Error()), nil)
+		return
+	}
+	if r.Header.Get("Connect-Content-Encoding") != "" && r.Header.Get("Connect-Content-Encoding") != "identity" {
+		writeConnectEndStream(w, status.New(codes.Unimplemented, ErrConnectCompression.Error()), nil)
+		return
+	}
+
+	ctx, cancel, err := connectContext(r)
+	if err != nil {
+		writeConnectEndStream(w, status.New(codes.InvalidArgument, err.Error()), nil)
+		return
+	}
+	defer cancel()
+
+	cs, err := conn.NewStream(ctx, &grpc.StreamDesc{
+		ClientStreams: desc.IsStreamingClient(),
+		ServerStreams: desc.IsStreamingServer(),
+	}, r.URL.Path)
+	if err != nil {
+		writeConnectEndStream(w, status.Convert(err), nil)
+		return
+	}
+
+	sendErr := make(chan error, 1)
+	go func() {
+		sendErr <- sendConnectMessages(cs, codec, desc.Input(), r.Body)
+	}()
+
+	header, err := cs.Header()
+	if err != nil {
+		writeConnectEndStream(w, status.FromContextError(err), nil)
+		return
+	}
+	setHeaderMetadata(w.Header(), "", header)
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		out := newMessage(desc.Output())
+		err := cs.RecvMsg(out)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			st := status.Convert(err)
+			select {
+			case err := <-sendErr:
+				if err != nil {
+					// a malformed request is the cause of the failed call
+					st = status.Convert(err)
+				}
+			default:
+			}
+			writeConnectEndStream(w, st, cs.Trailer())
+			return
+		}
+
+		data, err := codec.marshal(out)
+		if err != nil {
+			writeConnectEndStream(w, status.New(codes.Internal, err.Error()), cs.Trailer())
+			return
+		}
+
+		_, _ = w.Write(frameMessage(0, data))
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	writeConnectEndStream(w, status.New(codes.OK, ""), cs.Trailer())
+}
+
+// sendConnectMessages reads the enveloped request messages and sends them to the stream
+func sendConnectMessages(cs grpc.ClientStream, codec *connectCodec, desc protoreflect.MessageDescriptor, body io.Reader) error {
+	for {
+		flags, data, err := readFrame(body)
+		if err == io.EOF {
+			return cs.CloseSend()
+		}
+		if err != nil {
+			_ = cs.CloseSend()
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if flags&1 != 0 {
+			_ = cs.CloseSend()
+			return status.Error(codes.Unimplemented, ErrConnectCompression.Error())
+		}
+
+		in := newMessage(desc)
+		if err := codec.unmarshal(data, in); err != nil {
+			_ = cs.CloseSend()
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		if err := cs.SendMsg(in); err != nil {
+			// the server stopped reading the stream, status is returned by RecvMsg
+			return nil
+		}
+	}
+}
+
+// requestContentType returns the content type of the request without parameters
+func requestContentType(r *http.Request) string {
+	contentType := r.Header.Get("Content-Type")
+	if pos := strings.Index(contentType, ";"); pos >= 0 {
+		contentType = contentType[:pos]
+	}
+
+	return strings.TrimSpace(contentType)
+}
+
+// connectContext creates the outgoing context of the call with the request headers
+// as the grpc metadata and the timeout of the request
+func connectContext(r *http.Request) (context.Context, context.CancelFunc, error) {
+	md, err := requestMetadata(r.Header, "Connect-")
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx := metadata.NewOutgoingContext(r.Context(), md)
+
+	timeout := r.Header.Get(ConnectTimeoutHeader)
+	if timeout == "" {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+
+	ms, err := strconv.ParseInt(timeout, 10, 64)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+	return ctx, cancel, nil
+}
+
+// setHeaderMetadata adds the grpc metadata into headers, encoding the binary values
+func setHeaderMetadata(h http.Header, prefix string, md metadata.MD) {
+	for k, vv := range md {
+		for _, v := range vv {
+			if strings.HasSuffix(k, "-bin") {
+				v = base64.RawStdEncoding.EncodeToString([]byte(v))
+			}
+			h.Add(prefix+k, v)
+		}
+	}
+}
+
+// connectError is the JSON representation of the grpc status in the Connect protocol
+type connectError struct {
+	Code    string               `json:"code"`
+	Message string               `json:"message,omitempty"`
+	Details []connectErrorDetail `json:"details,omitempty"`
+}
+
+// connectErrorDetail is a status detail, the value is the unpadded base64 encoded
+// binary protobuf of the type
+type connectErrorDetail struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+func newConnectError(st *status.Status) *connectError {
+	cerr := &connectError{
+		Code:    connectCodes[st.Code()],
+		Message: st.Message(),
+	}
+	if cerr.Code == "" {
+		cerr.Code = connectCodes[codes.Unknown]
+	}
+
+	for _, detail := range st.Proto().GetDetails() {
+		cerr.Details = append(cerr.Details, connectErrorDetail{
+			Type:  detail.GetTypeUrl()[strings.LastIndex(detail.GetTypeUrl(), "/")+1:],
+			Value: base64.RawStdEncoding.EncodeToString(detail.GetValue()),
+		})
+	}
+
+	return cerr
+}
+
+// writeConnectError writes the Connect error of the unary call
+func writeConnectError(w http.ResponseWriter, st *status.Status) {
+	data, err := json.Marshal(newConnectError(st))
+	if err != nil {
+		data = []byte(`{"code":"internal","message":"failed to marshal the error"}`)
+	}
+
+	code, ok := connectHTTPStatus[st.Code()]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(data)
+}
+
+// writeConnectEndStream writes the last envelope of the stream with the error
+// and the trailers of the call
+func writeConnectEndStream(w http.ResponseWriter, st *status.Status, trailer metadata.MD) {
+	end := struct {
+		Error    *connectError       `json:"error,omitempty"`
+		Metadata map[string][]string `json:"metadata,omitempty"`
+	}{}
+	if st.Code() != codes.OK {
+		end.Error = newConnectError(st)
+	}
+	if len(trailer) > 0 {
+		end.Metadata = http.Header{}
+		setHeaderMetadata(end.Metadata, "", trailer)
+	}
+
+	data, err := json.Marshal(end)
+	if err != nil {
+		data = []byte(`{"error":{"code":"internal","message":"failed to marshal the end of stream"}}`)
+	}
+
+	_, _ = w.Write(frameMessage(connectEndStreamFlag, data))
+}
+
+// connectCodec marshals the messages of the Connect call
+type connectCodec struct {
+	contentType string
+	json        bool
+}
+
+func newConnectCodec(contentType string) *connectCodec {
+	return &connectCodec{
+		contentType: contentType,
+		json:        strings.HasSuffix(contentType, "json"),
+	}
+}
+
+func (c *connectCodec) marshal(m proto.Message) ([]byte, error) {
+	if c.json {
+		return protojson.Marshal(m)
+	}
+
+	return proto.Marshal(m)
+}
+
+func (c *connectCodec) unmarshal(data []byte, m proto.Message) error {
+	if c.json {
+		if len(bytes.TrimSpace(data)) == 0 {
+			return nil
+		}
+		return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, m)
+	}
+
+	return proto.Unmarshal(data, m)
+}
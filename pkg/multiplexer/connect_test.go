@@ -0,0 +1,236 @@
+package multiplexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/petomalina/xrpc/v2/examples/api"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	tpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type ConnectSuite struct {
+	suite.Suite
+
+	echoService *EchoService
+	handler     http.Handler
+}
+
+func (s *ConnectSuite) SetupTest() {
+	s.echoService = &EchoService{Logger: createLogger()}
+	grpcServer := createGrpcServer(s.echoService)
+	tpb.RegisterTestServiceServer(grpcServer, &StreamingService{})
+	s.handler = Make(nil,
+		GRPCHandler(grpcServer),
+		ConnectHandler(grpcServer),
+	)
+}
+
+func (s *ConnectSuite) serve(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func (s *ConnectSuite) TestIsConnectRequest() {
+	request := func(method, path, contentType string, header map[string]string) *http.Request {
+		req := httptest.NewRequest(method, "http://localhost"+path, nil)
+		req.Header.Set("Content-Type", contentType)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		return req
+	}
+
+	candidates := map[*http.Request]bool{
+		request(http.MethodPost, "/api.EchoService/Call", "application/proto", nil):                                                                true,
+		request(http.MethodPost, "/api.EchoService/Call", "application/json", map[string]string{ConnectProtocolVersionHeader: "1"}):                true,
+		request(http.MethodPost, "/api.EchoService/Call", "application/json; charset=utf-8", map[string]string{ConnectProtocolVersionHeader: "1"}): true,
+		request(http.MethodPost, "/api.EchoService/Call", "application/connect+proto", nil):                                                        true,
+		request(http.MethodPost, "/api.EchoService/Call", "application/json", nil):                                                                 false,
+		request(http.MethodPost, "/v1/echo/call", "application/proto", nil):                                                                        false,
+		request(http.MethodGet, "/api.EchoService/Call", "application/proto", nil):                                                                 false,
+		request(http.MethodPost, "/api.EchoService/Call", "application/grpc", nil):                                                                 false,
+	}
+
+	for req, result := range candidates {
+		s.Equal(result, IsConnectRequest(req), "Request is badly considered a connect request", req.URL.Path, req.Header)
+	}
+}
+
+func (s *ConnectSuite) TestUnary() {
+	var incoming metadata.MD
+	s.echoService.onCall = func(ctx context.Context, m *api.EchoMessage) {
+		incoming, _ = metadata.FromIncomingContext(ctx)
+		_ = grpc.SetHeader(ctx, metadata.Pairs("x-echo-header", "header"))
+		_ = grpc.SetTrailer(ctx, metadata.Pairs("x-echo-trailer", "trailer"))
+	}
+
+	// binary
+	data, err := proto.Marshal(&api.EchoMessage{Message: "Hello World"})
+	s.NoError(err)
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/api.EchoService/Call", bytes.NewReader(data))
+	req.Header.Set("Content-Type", ContentTypeConnectProto)
+	req.Header.Set("X-Caller", "connect")
+
+	rec := s.serve(req)
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal(ContentTypeConnectProto, rec.Header().Get("Content-Type"))
+	s.Equal("header", rec.Header().Get("X-Echo-Header"))
+	s.Equal("trailer", rec.Header().Get("Trailer-X-Echo-Trailer"))
+	s.Equal([]string{"connect"}, incoming.Get("x-caller"))
+
+	res := &api.EchoMessage{}
+	s.NoError(proto.Unmarshal(rec.Body.Bytes(), res))
+	s.Equal("Hello World", res.Message)
+
+	// json
+	req = httptest.NewRequest(http.MethodPost, "http://localhost/api.EchoService/Call", strings.NewReader(`{"message":"Hello World"}`))
+	req.Header.Set("Content-Type", ContentTypeConnectJSON)
+	req.Header.Set(ConnectProtocolVersionHeader, "1")
+
+	rec = s.serve(req)
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal(ContentTypeConnectJSON, rec.Header().Get("Content-Type"))
+	s.JSONEq(`{"message":"Hello World"}`, rec.Body.String())
+}
+
+func (s *ConnectSuite) TestUnaryError() {
+	candidates := map[string]struct {
+		body   string
+		status int
+		code   string
+	}{
+		"/api.EchoService/Unknown": {body: `{}`, status: http.StatusNotImplemented, code: "unimplemented"},
+		"/api.EchoService/Call":    {body: `{"message":`, status: http.StatusBadRequest, code: "invalid_argument"},
+		"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo": {
+			body: `{}`, status: http.StatusNotImplemented, code: "unimplemented",
+		},
+	}
+
+	for path, c := range candidates {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost"+path, strings.NewReader(c.body))
+		req.Header.Set("Content-Type", ContentTypeConnectJSON)
+		req.Header.Set(ConnectProtocolVersionHeader, "1")
+
+		rec := s.serve(req)
+		s.Equal(c.status, rec.Code, path)
+
+		cerr := &connectError{}
+		s.NoError(json.Unmarshal(rec.Body.Bytes(), cerr))
+		s.Equal(c.code, cerr.Code, path)
+		s.NotEmpty(cerr.Message)
+	}
+}
+
+func (s *ConnectSuite) TestStream() {
+	// the reflection service is a bidirectional stream registered on the testing server
+	listServices := &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	}
+
+	for _, contentType := range []string{ContentTypeConnectStreamProto, ContentTypeConnectStreamJSON} {
+		codec := newConnectCodec(contentType)
+
+		body := []byte{}
+		for i := 0; i < 2; i++ {
+			data, err := codec.marshal(listServices)
+			s.NoError(err)
+			body = append(body, frameMessage(0, data)...)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+
+		rec := s.serve(req)
+		s.Equal(http.StatusOK, rec.Code)
+		s.Equal(contentType, rec.Header().Get("Content-Type"))
+
+		r := bytes.NewReader(rec.Body.Bytes())
+		for i := 0; i < 2; i++ {
+			flags, data, err := readFrame(r)
+			s.NoError(err)
+			s.Zero(flags)
+
+			res := &rpb.ServerReflectionResponse{}
+			s.NoError(codec.unmarshal(data, res))
+			s.NotEmpty(res.GetListServicesResponse().GetService())
+		}
+
+		flags, data, err := readFrame(r)
+		s.NoError(err)
+		s.Equal(byte(connectEndStreamFlag), flags)
+		s.JSONEq(`{}`, string(data))
+		s.Zero(r.Len())
+	}
+}
+
+func (s *ConnectSuite) TestClientStream() {
+	for _, contentType := range []string{ContentTypeConnectStreamProto, ContentTypeConnectStreamJSON} {
+		codec := newConnectCodec(contentType)
+
+		body := []byte{}
+		for _, payload := range []string{"Hello", "World!"} {
+			data, err := codec.marshal(&tpb.StreamingInputCallRequest{Payload: &tpb.Payload{Body: []byte(payload)}})
+			s.NoError(err)
+			body = append(body, frameMessage(0, data)...)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/grpc.testing.TestService/StreamingInputCall", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+
+		rec := s.serve(req)
+		s.Equal(http.StatusOK, rec.Code)
+
+		r := bytes.NewReader(rec.Body.Bytes())
+		flags, data, err := readFrame(r)
+		s.NoError(err)
+		s.Zero(flags)
+
+		res := &tpb.StreamingInputCallResponse{}
+		s.NoError(codec.unmarshal(data, res))
+		s.Equal(int32(11), res.AggregatedPayloadSize)
+
+		// the single response is followed by a successful end of the stream
+		flags, data, err = readFrame(r)
+		s.NoError(err)
+		s.Equal(byte(connectEndStreamFlag), flags)
+		s.JSONEq(`{}`, string(data), contentType)
+		s.Zero(r.Len())
+	}
+}
+
+func (s *ConnectSuite) TestStreamError() {
+	data, err := protojson.Marshal(&api.EchoMessage{})
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/api.EchoService/Unknown", bytes.NewReader(frameMessage(0, data)))
+	req.Header.Set("Content-Type", ContentTypeConnectStreamJSON)
+
+	rec := s.serve(req)
+	s.Equal(http.StatusOK, rec.Code)
+
+	flags, data, err := readFrame(bytes.NewReader(rec.Body.Bytes()))
+	s.NoError(err)
+	s.Equal(byte(connectEndStreamFlag), flags)
+
+	end := struct {
+		Error *connectError `json:"error"`
+	}{}
+	s.NoError(json.Unmarshal(data, &end))
+	s.Equal("unimplemented", end.Error.Code)
+}
+
+func TestConnectSuite(t *testing.T) {
+	suite.Run(t, &ConnectSuite{})
+}
@@ -0,0 +1,110 @@
+package multiplexer
+
+import (
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ServiceRegistry is a grpc server exposing the services registered on it. It is
+// implemented by the *grpc.Server
+type ServiceRegistry interface {
+	http.Handler
+	GetServiceInfo() map[string]grpc.ServiceInfo
+}
+
+// registeredMethods returns the descriptors of all methods registered on the server,
+// sorted by their full names. Services missing in the global protobuf registry are skipped
+func registeredMethods(server ServiceRegistry) []protoreflect.MethodDescriptor {
+	names := []string{}
+	for name := range server.GetServiceInfo() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	methods := []protoreflect.MethodDescriptor{}
+	for _, name := range names {
+		desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			continue
+		}
+
+		sd, ok := desc.(protoreflect.ServiceDescriptor)
+		if !ok {
+			continue
+		}
+
+		for i := 0; i < sd.Methods().Len(); i++ {
+			methods = append(methods, sd.Methods().Get(i))
+		}
+	}
+
+	return methods
+}
+
+// splitFullMethod splits the full method name (/package.Service/Method) into
+// the service and method names
+func splitFullMethod(fullMethod string) (string, string, error) {
+	name := strings.TrimPrefix(fullMethod, "/")
+	pos := strings.LastIndex(name, "/")
+	if pos <= 0 || pos == len(name)-1 {
+		return "", "", fmt.Errorf("malformed method name %q", fullMethod)
+	}
+
+	return name[:pos], name[pos+1:], nil
+}
+
+// isFullMethodPath returns true if the path is a full method name of a grpc method
+// (/package.Service/Method)
+func isFullMethodPath(path string) bool {
+	service, _, err := splitFullMethod(path)
+	return err == nil && strings.HasPrefix(path, "/") && !strings.Contains(service, "/")
+}
+
+// findMethodDescriptor looks up the descriptor of the given full method name
+// (/package.Service/Method) in the global protobuf registry
+func findMethodDescriptor(fullMethod string) (protoreflect.MethodDescriptor, error) {
+	service, method, err := splitFullMethod(fullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("unknown service %s: %w", service, err)
+	}
+
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("unknown method %s in service %s", method, service)
+	}
+
+	return md, nil
+}
+
+// fullMethodName returns the full method name (/package.Service/Method) of the descriptor
+func fullMethodName(md protoreflect.MethodDescriptor) string {
+	return "/" + string(md.Parent().FullName()) + "/" + string(md.Name())
+}
+
+// newMessage creates a new message of the given type, preferring the generated
+// Go type if it is registered and falling back to a dynamic message otherwise
+func newMessage(desc protoreflect.MessageDescriptor) proto.Message {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(desc.FullName())
+	if err == nil {
+		return mt.New().Interface()
+	}
+
+	return dynamicpb.NewMessage(desc)
+}
@@ -0,0 +1,781 @@
+package multiplexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	// ContentTypeGraphQL is the content type of requests with the GraphQL document as the body
+	ContentTypeGraphQL = "application/graphql"
+
+	// gqlMaxBodySize is the maximum size of the POST bodies in bytes
+	gqlMaxBodySize = 4 << 20
+)
+
+var (
+	// ErrGraphQLRequest is returned if the GraphQL request is malformed
+	ErrGraphQLRequest = errors.New("graphql: malformed request")
+)
+
+// gqlMarshaler emits the unpopulated fields, so every field of the schema has a value
+var gqlMarshaler = protojson.MarshalOptions{EmitUnpopulated: true}
+
+// IsGraphQLRequest returns true if the request is a GET or a POST of a JSON or GraphQL body.
+// The GraphQLHandler additionally selects the requests by its path
+func IsGraphQLRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet:
+		return true
+	case http.MethodPost:
+		ct := requestContentType(r)
+		return ct == "application/json" || ct == ContentTypeGraphQL
+	}
+
+	return false
+}
+
+// GraphQL is a http.Handler serving a GraphQL schema built from the unary methods registered on
+// the grpc server. Every method is a field of the Query or the Mutation type (see newGraphQLSchema)
+// named by the method (e.g. getOperation), with the fields of the input message as its arguments
+// and the output message as its type. Messages are mapped onto object and input types named by
+// the messages, enums onto enum types and the values without a GraphQL type onto the JSON scalar.
+// The fields are resolved by calling the methods in-process through the LocalConn.
+//
+// Queries are sent as GET or POST requests, mutations only as POST requests, and a GET request
+// without a query returns the schema in the GraphQL schema definition language. Fragments,
+// variables, aliases and the @skip and @include directives are supported, introspection and
+// subscriptions are not. Bodies are limited to 4MB and documents to 64 levels of nesting
+type GraphQL struct {
+	conn   *LocalConn
+	schema *gqlSchema
+}
+
+// NewGraphQL creates a GraphQL for the methods registered on the server. All services must
+// be registered on the server before the GraphQL is created, and their generated packages
+// must be imported so their descriptors are available
+func NewGraphQL(server ServiceRegistry) (*GraphQL, error) {
+	schema, err := newGraphQLSchema(registeredMethods(server))
+	if err != nil {
+		return nil, err
+	}
+
+	return &GraphQL{
+		conn:   NewLocalConn(server),
+		schema: schema,
+	}, nil
+}
+
+// Schema returns the schema in the GraphQL schema definition language
+func (g *GraphQL) Schema() string {
+	return g.schema.sdl()
+}
+
+// GraphQLHandler fulfills GraphQL requests sent to /graphql (see NewGraphQLHandler)
+func GraphQLHandler(g *GraphQL, selectors ...Selector) Handler {
+	return NewGraphQLHandler(g, WithGraphQLSelectors(selectors...))
+}
+
+// GraphQLOption is an extendable builder for the GraphQLHandler options
+type GraphQLOption func(o *graphQLOptions)
+
+type graphQLOptions struct {
+	selectors []Selector
+	path      string
+}
+
+// WithGraphQLSelectors adds selectors that must be fulfilled on top of
+// the IsGraphQLRequest selector and the path
+func WithGraphQLSelectors(selectors ...Selector) GraphQLOption {
+	return func(o *graphQLOptions) {
+		o.selectors = append(o.selectors, selectors...)
+	}
+}
+
+// WithGraphQLPath sets the path of the GraphQL endpoint, /graphql by default
+func WithGraphQLPath(path string) GraphQLOption {
+	return func(o *graphQLOptions) {
+		o.path = path
+	}
+}
+
+// NewGraphQLHandler creates a GraphQLHandler configured by the given options
+func NewGraphQLHandler(g *GraphQL, opts ...GraphQLOption) Handler {
+	options := &graphQLOptions{
+		path: "/graphql",
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	filter := append([]Selector{IsGraphQLRequest, func(r *http.Request) bool {
+		return r.URL.Path == options.path
+	}}, options.selectors...)
+
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for _, f := range filter {
+			if !f(r) {
+				return false
+			}
+		}
+
+		g.ServeHTTP(w, r)
+		return true
+	}
+}
+
+// gqlRequest is the GraphQL request of a GET query or a POST body
+type gqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// gqlResult is the response of a GraphQL request. The data is missing if the
+// request failed before its execution
+type gqlResult struct {
+	Data   *gqlObject  `json:"data,omitempty"`
+	Errors []*gqlError `json:"errors,omitempty"`
+}
+
+// gqlError is a GraphQL error with the path of the failed field. Errors of the grpc
+// methods carry the name of their code in the extensions
+type gqlError struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// gqlObject is a JSON object keeping the order of the fields, as GraphQL
+// responses are ordered by the selections of the request
+type gqlObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newGQLObject() *gqlObject {
+	return &gqlObject{values: map[string]interface{}{}}
+}
+
+func (o *gqlObject) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+// MarshalJSON marshals the fields in the order they were set
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+func (g *GraphQL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := &gqlRequest{}
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		if q.Get("query") == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(g.Schema()))
+			return
+		}
+
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if variables := q.Get("variables"); variables != "" {
+			if err := decodeJSONNumbers([]byte(variables), &req.Variables); err != nil {
+				writeGraphQL(w, http.StatusBadRequest, &gqlResult{Errors: []*gqlError{{Message: fmt.Sprintf("%s: %s", ErrGraphQLRequest, err)}}})
+				return
+			}
+		}
+
+	default:
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, gqlMaxBodySize))
+		if err == nil {
+			if requestContentType(r) == ContentTypeGraphQL {
+				req.Query = string(body)
+			} else {
+				err = decodeJSONNumbers(body, req)
+			}
+		}
+		if err != nil {
+			writeGraphQL(w, http.StatusBadRequest, &gqlResult{Errors: []*gqlError{{Message: fmt.Sprintf("%s: %s", ErrGraphQLRequest, err)}}})
+			return
+		}
+	}
+
+	if req.Query == "" {
+		writeGraphQL(w, http.StatusBadRequest, &gqlResult{Errors: []*gqlError{{Message: ErrGraphQLRequest.Error() + ": missing query"}}})
+		return
+	}
+
+	doc, err := parseGraphQL(req.Query)
+	if err != nil {
+		writeGraphQL(w, http.StatusOK, &gqlResult{Errors: []*gqlError{{Message: err.Error()}}})
+		return
+	}
+
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		writeGraphQL(w, http.StatusOK, &gqlResult{Errors: []*gqlError{{Message: err.Error()}}})
+		return
+	}
+	if op.kind == "mutation" && r.Method == http.MethodGet {
+		w.Header().Set("Allow", http.MethodPost)
+		writeGraphQL(w, http.StatusMethodNotAllowed, &gqlResult{Errors: []*gqlError{{Message: "graphql: mutations must be sent as POST requests"}}})
+		return
+	}
+
+	md, err := requestMetadata(r.Header)
+	if err != nil {
+		writeGraphQL(w, http.StatusBadRequest, &gqlResult{Errors: []*gqlError{{Message: err.Error()}}})
+		return
+	}
+
+	e := &gqlExecution{
+		graphQL: g,
+		doc:     doc,
+		op:      op,
+	}
+	writeGraphQL(w, http.StatusOK, e.execute(metadata.NewOutgoingContext(r.Context(), md), req.Variables))
+}
+
+// decodeJSONNumbers decodes the JSON keeping the numbers as json.Number, so the
+// integers are passed to the methods without a loss of precision
+func decodeJSONNumbers(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	return dec.Decode(v)
+}
+
+func writeGraphQL(w http.ResponseWriter, httpStatus int, res *gqlResult) {
+	data, err := json.Marshal(res)
+	if err != nil {
+		httpStatus = http.StatusInternalServerError
+		data = []byte(`{"errors":[{"message":"failed to marshal the response"}]}`)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	_, _ = w.Write(data)
+}
+
+// operation returns the operation of the given name, which may be omitted
+// if the document contains a single operation
+func (d *gqlDocument) operation(name string) (*gqlOperation, error) {
+	if name == "" {
+		if len(d.operations) > 1 {
+			return nil, errors.New("graphql: the operation name is required for documents with multiple operations")
+		}
+		return d.operations[0], nil
+	}
+
+	for _, op := range d.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+
+	return nil, fmt.Errorf("graphql: unknown operation %s", name)
+}
+
+// gqlExecution is the execution of a single operation
+type gqlExecution struct {
+	graphQL   *GraphQL
+	doc       *gqlDocument
+	op        *gqlOperation
+	variables map[string]interface{}
+
+	mu     sync.Mutex
+	errors []*gqlError
+}
+
+func (e *gqlExecution) fail(path []interface{}, err error) {
+	gerr := &gqlError{Message: err.Error(), Path: path}
+	if st, ok := status.FromError(err); ok {
+		gerr.Message = st.Message()
+		if code, ok := connectCodes[st.Code()]; ok {
+			gerr.Extensions = map[string]interface{}{"code": strings.ToUpper(code)}
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.errors = append(e.errors, gerr)
+}
+
+// execute validates the operation against the schema and resolves its root fields. The fields
+// of queries are resolved concurrently, the fields of mutations serially
+func (e *gqlExecution) execute(ctx context.Context, variables map[string]interface{}) *gqlResult {
+	root := e.graphQL.schema.query
+	switch e.op.kind {
+	case "mutation":
+		root = e.graphQL.schema.mutation
+	case "subscription":
+		return &gqlResult{Errors: []*gqlError{{Message: "graphql: subscriptions are not supported"}}}
+	}
+
+	if err := e.coerceVariables(variables); err != nil {
+		return &gqlResult{Errors: []*gqlError{{Message: err.Error()}}}
+	}
+	if err := e.validate(root, e.op.selections, map[string]bool{}, 1); err != nil {
+		return &gqlResult{Errors: []*gqlError{{Message: err.Error()}}}
+	}
+
+	keys, fields, err := e.collectFields(root, e.op.selections)
+	if err != nil {
+		return &gqlResult{Errors: []*gqlError{{Message: err.Error()}}}
+	}
+
+	values := make([]interface{}, len(keys))
+	wg := sync.WaitGroup{}
+	for i, key := range keys {
+		resolve := func(i int, key string) {
+			values[i] = e.resolveRoot(ctx, root, key, fields[key])
+		}
+
+		if e.op.kind == "mutation" {
+			resolve(i, key)
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			resolve(i, key)
+		}(i, key)
+	}
+	wg.Wait()
+
+	data := newGQLObject()
+	for i, key := range keys {
+		data.set(key, values[i])
+	}
+
+	return &gqlResult{Data: data, Errors: e.errors}
+}
+
+// coerceVariables applies the default values of the variables and checks the required ones
+func (e *gqlExecution) coerceVariables(provided map[string]interface{}) error {
+	e.variables = map[string]interface{}{}
+	for _, v := range e.op.variables {
+		if value, ok := provided[v.name]; ok {
+			e.variables[v.name] = value
+			continue
+		}
+
+		if v.defaults != nil {
+			value, err := e.value(v.defaults)
+			if err != nil {
+				return err
+			}
+			e.variables[v.name] = value
+			continue
+		}
+
+		if strings.HasSuffix(v.typ, "!") {
+			return fmt.Errorf("graphql: variable $%s of the required type %s was not provided", v.name, v.typ)
+		}
+	}
+
+	return nil
+}
+
+// validate checks that the selections exist on the type and that the scalars and
+// the objects are selected correctly. The depth counts the nested selection sets along
+// with the spread fragments, which are not limited by the parser
+func (e *gqlExecution) validate(t *gqlType, selections []*gqlSelection, fragments map[string]bool, depth int) error {
+	if depth > gqlMaxDepth {
+		return fmt.Errorf("%w: the selections are nested deeper than %d levels", ErrGraphQLDepth, gqlMaxDepth)
+	}
+
+	for _, s := range selections {
+		for _, d := range s.directives {
+			if d.name != "skip" && d.name != "include" {
+				return fmt.Errorf("graphql: unknown directive @%s", d.name)
+			}
+			if err := e.validateArguments(d.arguments); err != nil {
+				return err
+			}
+		}
+		if err := e.validateArguments(s.arguments); err != nil {
+			return err
+		}
+
+		switch {
+		case s.fragment != "":
+			f, ok := e.doc.fragments[s.fragment]
+			if !ok {
+				return fmt.Errorf("graphql: unknown fragment %s", s.fragment)
+			}
+			if f.typeCondition != t.name {
+				return fmt.Errorf("graphql: fragment %s on %s cannot be spread on %s", f.name, f.typeCondition, t.name)
+			}
+			if fragments[f.name] {
+				return fmt.Errorf("graphql: fragment %s spreads itself", f.name)
+			}
+
+			fragments[f.name] = true
+			err := e.validate(t, f.selections, fragments, depth+1)
+			delete(fragments, f.name)
+			if err != nil {
+				return err
+			}
+
+		case s.inline:
+			if s.typeCondition != "" && s.typeCondition != t.name {
+				return fmt.Errorf("graphql: inline fragment on %s cannot be spread on %s", s.typeCondition, t.name)
+			}
+			if err := e.validate(t, s.selections, fragments, depth+1); err != nil {
+				return err
+			}
+
+		case s.name == "__typename":
+			if len(s.arguments) > 0 || len(s.selections) > 0 {
+				return errors.New("graphql: __typename has no arguments and no selections")
+			}
+
+		default:
+			f := t.field(s.name)
+			if f == nil {
+				return fmt.Errorf("graphql: cannot query field %s on type %s", s.name, t.name)
+			}
+
+			for _, a := range s.arguments {
+				if !containsGQLField(f.args, a.name) {
+					return fmt.Errorf("graphql: unknown argument %s of field %s on type %s", a.name, s.name, t.name)
+				}
+			}
+
+			if f.typ.kind != gqlObjectType {
+				if len(s.selections) > 0 {
+					return fmt.Errorf("graphql: field %s of type %s must not have a selection", s.name, f.typeRef())
+				}
+				continue
+			}
+			if len(s.selections) == 0 {
+				return fmt.Errorf("graphql: field %s of type %s must have a selection of subfields", s.name, f.typeRef())
+			}
+			if err := e.validate(f.typ, s.selections, fragments, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateArguments checks that the variables used by the arguments are defined
+func (e *gqlExecution) validateArguments(arguments []*gqlArgument) error {
+	values := []*gqlValue{}
+	for _, a := range arguments {
+		values = append(values, a.value)
+	}
+
+	for len(values) > 0 {
+		v := values[0]
+		values = values[1:]
+
+		if v.kind == gqlVariableValue && !e.op.defines(v.raw) {
+			return fmt.Errorf("graphql: variable $%s is not defined", v.raw)
+		}
+		values = append(values, v.list...)
+		for _, f := range v.fields {
+			values = append(values, f.value)
+		}
+	}
+
+	return nil
+}
+
+func containsGQLField(fields []*gqlField, name string) bool {
+	for _, f := range fields {
+		if f.name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// collectFields groups the selected fields by their response keys, expanding the fragments
+// and skipping the selections excluded by the @skip and @include directives
+func (e *gqlExecution) collectFields(t *gqlType, selections []*gqlSelection) ([]string, map[string][]*gqlSelection, error) {
+	keys := []string{}
+	fields := map[string][]*gqlSelection{}
+
+	var collect func(selections []*gqlSelection) error
+	collect = func(selections []*gqlSelection) error {
+		for _, s := range selections {
+			included, err := e.included(s.directives)
+			if err != nil {
+				return err
+			}
+			if !included {
+				continue
+			}
+
+			switch {
+			case s.fragment != "":
+				f := e.doc.fragments[s.fragment]
+				included, err := e.included(f.directives)
+				if err != nil {
+					return err
+				}
+				if !included {
+					continue
+				}
+				if err := collect(f.selections); err != nil {
+					return err
+				}
+
+			case s.inline:
+				if err := collect(s.selections); err != nil {
+					return err
+				}
+
+			default:
+				key := s.responseKey()
+				if _, ok := fields[key]; !ok {
+					keys = append(keys, key)
+				}
+				fields[key] = append(fields[key], s)
+			}
+		}
+
+		return nil
+	}
+
+	return keys, fields, collect(selections)
+}
+
+// included evaluates the @skip and @include directives
+func (e *gqlExecution) included(directives []*gqlDirective) (bool, error) {
+	for _, d := range directives {
+		for _, a := range d.arguments {
+			if a.name != "if" {
+				continue
+			}
+
+			value, err := e.value(a.value)
+			if err != nil {
+				return false, err
+			}
+			condition, ok := value.(bool)
+			if !ok {
+				return false, fmt.Errorf("graphql: the if argument of @%s must be a Boolean", d.name)
+			}
+
+			if (d.name == "skip" && condition) || (d.name == "include" && !condition) {
+				return false, nil
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// resolveRoot calls the method of the root field and completes its output
+func (e *gqlExecution) resolveRoot(ctx context.Context, root *gqlType, key string, selections []*gqlSelection) interface{} {
+	s := selections[0]
+	path := []interface{}{key}
+	if s.name == "__typename" {
+		return root.name
+	}
+
+	f := root.field(s.name)
+	args := map[string]interface{}{}
+	for _, a := range s.arguments {
+		value, err := e.value(a.value)
+		if err != nil {
+			e.fail(path, err)
+			return nil
+		}
+		args[a.name] = value
+	}
+
+	var input interface{} = args
+	if f.input {
+		input = args[gqlInputArgument]
+	}
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		e.fail(path, err)
+		return nil
+	}
+
+	in := newMessage(f.method.Input())
+	if err := protojson.Unmarshal(data, in); err != nil {
+		e.fail(path, status.Error(codes.InvalidArgument, err.Error()))
+		return nil
+	}
+
+	out := newMessage(f.method.Output())
+	if err := e.graphQL.conn.Invoke(ctx, fullMethodName(f.method), in, out); err != nil {
+		e.fail(path, err)
+		return nil
+	}
+
+	data, err = gqlMarshaler.Marshal(out)
+	if err != nil {
+		e.fail(path, err)
+		return nil
+	}
+
+	var value interface{}
+	if err := decodeJSONNumbers(data, &value); err != nil {
+		e.fail(path, err)
+		return nil
+	}
+
+	value, err = e.complete(f, value, selections, 1)
+	if err != nil {
+		e.fail(path, err)
+		return nil
+	}
+
+	return value
+}
+
+// complete selects the fields of the output value. The depth counts the nested objects,
+// which are already limited by the validation
+func (e *gqlExecution) complete(f *gqlField, value interface{}, selections []*gqlSelection, depth int) (interface{}, error) {
+	if value == nil || f.typ.kind != gqlObjectType {
+		return value, nil
+	}
+	if depth > gqlMaxDepth {
+		return nil, fmt.Errorf("%w: the value of %s is nested deeper than %d levels", ErrGraphQLDepth, f.name, gqlMaxDepth)
+	}
+
+	if f.list {
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("graphql: the value of %s is not a list", f.name)
+		}
+
+		list := make([]interface{}, len(items))
+		item := &gqlField{name: f.name, typ: f.typ}
+		for i := range items {
+			v, err := e.complete(item, items[i], selections, depth)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = v
+		}
+
+		return list, nil
+	}
+
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("graphql: the value of %s is not an object", f.name)
+	}
+
+	subselections := []*gqlSelection{}
+	for _, s := range selections {
+		subselections = append(subselections, s.selections...)
+	}
+
+	keys, subfields, err := e.collectFields(f.typ, subselections)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := newGQLObject()
+	for _, key := range keys {
+		s := subfields[key][0]
+		if s.name == "__typename" {
+			obj.set(key, f.typ.name)
+			continue
+		}
+
+		sf := f.typ.field(s.name)
+		v, err := e.complete(sf, fields[sf.name], subfields[key], depth+1)
+		if err != nil {
+			return nil, err
+		}
+		obj.set(key, v)
+	}
+
+	return obj, nil
+}
+
+// value converts the GraphQL value into its JSON representation
+func (e *gqlExecution) value(v *gqlValue) (interface{}, error) {
+	switch v.kind {
+	case gqlVariableValue:
+		if !e.op.defines(v.raw) {
+			return nil, fmt.Errorf("graphql: variable $%s is not defined", v.raw)
+		}
+		return e.variables[v.raw], nil
+
+	case gqlIntValue, gqlFloatValue:
+		return json.Number(v.raw), nil
+
+	case gqlStringValue, gqlEnumValue:
+		return v.raw, nil
+
+	case gqlBooleanValue:
+		return v.raw == "true", nil
+
+	case gqlListValue:
+		list := make([]interface{}, len(v.list))
+		for i, item := range v.list {
+			value, err := e.value(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = value
+		}
+		return list, nil
+
+	case gqlObjectValue:
+		obj := map[string]interface{}{}
+		for _, f := range v.fields {
+			value, err := e.value(f.value)
+			if err != nil {
+				return nil, err
+			}
+			obj[f.name] = value
+		}
+		return obj, nil
+	}
+
+	return nil, nil
+}
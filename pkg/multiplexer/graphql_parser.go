@@ -0,0 +1,857 @@
+package multiplexer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// gqlMaxDepth is the maximum nesting of the selection sets, values and types of
+	// the documents, so the recursion of the parser and the execution stays bounded
+	gqlMaxDepth = 64
+)
+
+var (
+	// ErrGraphQLSyntax is returned if the GraphQL document is malformed
+	ErrGraphQLSyntax = errors.New("graphql: syntax error")
+
+	// ErrGraphQLDepth is returned if the GraphQL document is nested too deep
+	ErrGraphQLDepth = errors.New("graphql: maximum depth exceeded")
+)
+
+// gqlDocument is a parsed executable GraphQL document
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+// gqlOperation is a query, mutation or subscription of the document
+type gqlOperation struct {
+	kind       string
+	name       string
+	variables  []*gqlVariable
+	directives []*gqlDirective
+	selections []*gqlSelection
+}
+
+// defines returns true if the operation defines the variable
+func (o *gqlOperation) defines(name string) bool {
+	for _, v := range o.variables {
+		if v.name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// gqlVariable is a variable definition of an operation
+type gqlVariable struct {
+	name     string
+	typ      string
+	defaults *gqlValue
+}
+
+// gqlFragment is a named fragment of the document
+type gqlFragment struct {
+	name          string
+	typeCondition string
+	directives    []*gqlDirective
+	selections    []*gqlSelection
+}
+
+// gqlSelection is a field, a fragment spread or an inline fragment of a selection set
+type gqlSelection struct {
+	// field
+	alias      string
+	name       string
+	arguments  []*gqlArgument
+	directives []*gqlDirective
+	selections []*gqlSelection
+
+	// fragment spread
+	fragment string
+
+	// inline fragment
+	inline        bool
+	typeCondition string
+}
+
+// responseKey returns the key of the field in the response
+func (s *gqlSelection) responseKey() string {
+	if s.alias != "" {
+		return s.alias
+	}
+
+	return s.name
+}
+
+// gqlArgument is a named value of a field or a directive
+type gqlArgument struct {
+	name  string
+	value *gqlValue
+}
+
+// gqlDirective is a directive of an operation, a fragment or a selection
+type gqlDirective struct {
+	name      string
+	arguments []*gqlArgument
+}
+
+type gqlValueKind int
+
+const (
+	gqlVariableValue gqlValueKind = iota
+	gqlIntValue
+	gqlFloatValue
+	gqlStringValue
+	gqlBooleanValue
+	gqlNullValue
+	gqlEnumValue
+	gqlListValue
+	gqlObjectValue
+)
+
+// gqlValue is a literal value or a variable reference
+type gqlValue struct {
+	kind gqlValueKind
+	// raw is the name of the variable or enum value, the string or the number as written
+	raw    string
+	list   []*gqlValue
+	fields []*gqlArgument
+}
+
+type gqlTokenKind int
+
+const (
+	gqlEOF gqlTokenKind = iota
+	gqlPunctuator
+	gqlName
+	gqlInt
+	gqlFloat
+	gqlString
+)
+
+type gqlToken struct {
+	kind  gqlTokenKind
+	value string
+	pos   int
+}
+
+// gqlLexer splits the GraphQL source into tokens, skipping the ignored tokens
+// (whitespace, commas and comments)
+type gqlLexer struct {
+	src string
+	pos int
+}
+
+func (l *gqlLexer) errorf(pos int, format string, args ...interface{}) error {
+	return fmt.Errorf("%w at %d: %s", ErrGraphQLSyntax, pos, fmt.Sprintf(format, args...))
+}
+
+func (l *gqlLexer) next() (gqlToken, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			l.pos++
+			continue
+		}
+		if strings.HasPrefix(l.src[l.pos:], "\uFEFF") {
+			l.pos += len("\uFEFF")
+			continue
+		}
+		if c == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		break
+	}
+
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return gqlToken{kind: gqlEOF, pos: start}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case strings.ContainsRune("!$&():=@[]{}|", rune(c)):
+		l.pos++
+		return gqlToken{kind: gqlPunctuator, value: string(c), pos: start}, nil
+
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return gqlToken{kind: gqlPunctuator, value: "...", pos: start}, nil
+
+	case c == '_' || isASCIILetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isASCIILetter(l.src[l.pos]) || isASCIIDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return gqlToken{kind: gqlName, value: l.src[start:l.pos], pos: start}, nil
+
+	case c == '-' || isASCIIDigit(c):
+		return l.number()
+
+	case strings.HasPrefix(l.src[l.pos:], `"""`):
+		return l.blockString()
+
+	case c == '"':
+		return l.string()
+	}
+
+	return gqlToken{}, l.errorf(start, "unexpected character %q", c)
+}
+
+func (l *gqlLexer) number() (gqlToken, error) {
+	start := l.pos
+	kind := gqlInt
+
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	digits := l.pos
+	for l.pos < len(l.src) && isASCIIDigit(l.src[l.pos]) {
+		l.pos++
+	}
+	if l.pos == digits || (l.src[digits] == '0' && l.pos-digits > 1) {
+		return gqlToken{}, l.errorf(start, "invalid number")
+	}
+
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = gqlFloat
+		l.pos++
+		fraction := l.pos
+		for l.pos < len(l.src) && isASCIIDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		if l.pos == fraction {
+			return gqlToken{}, l.errorf(start, "invalid number")
+		}
+	}
+
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = gqlFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		exponent := l.pos
+		for l.pos < len(l.src) && isASCIIDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		if l.pos == exponent {
+			return gqlToken{}, l.errorf(start, "invalid number")
+		}
+	}
+
+	// numbers must not be directly followed by a name
+	if l.pos < len(l.src) && (l.src[l.pos] == '_' || l.src[l.pos] == '.' || isASCIILetter(l.src[l.pos])) {
+		return gqlToken{}, l.errorf(start, "invalid number")
+	}
+
+	return gqlToken{kind: kind, value: l.src[start:l.pos], pos: start}, nil
+}
+
+func (l *gqlLexer) string() (gqlToken, error) {
+	start := l.pos
+	l.pos++
+
+	b := &strings.Builder{}
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return gqlToken{kind: gqlString, value: b.String(), pos: start}, nil
+
+		case c == '\n' || c == '\r':
+			return gqlToken{}, l.errorf(start, "unterminated string")
+
+		case c == '\\':
+			if l.pos+1 >= len(l.src) {
+				return gqlToken{}, l.errorf(start, "unterminated string")
+			}
+			l.pos += 2
+			switch e := l.src[l.pos-1]; e {
+			case '"', '\\', '/':
+				b.WriteByte(e)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.pos+4 > len(l.src) {
+					return gqlToken{}, l.errorf(start, "invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(l.src[l.pos:l.pos+4], 16, 32)
+				if err != nil {
+					return gqlToken{}, l.errorf(start, "invalid unicode escape")
+				}
+				b.WriteRune(rune(r))
+				l.pos += 4
+			default:
+				return gqlToken{}, l.errorf(l.pos-2, "invalid escape \\%c", e)
+			}
+
+		default:
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			b.WriteRune(r)
+			l.pos += size
+		}
+	}
+
+	return gqlToken{}, l.errorf(start, "unterminated string")
+}
+
+func (l *gqlLexer) blockString() (gqlToken, error) {
+	start := l.pos
+	l.pos += 3
+
+	b := &strings.Builder{}
+	for l.pos < len(l.src) {
+		switch {
+		case strings.HasPrefix(l.src[l.pos:], `\"""`):
+			b.WriteString(`"""`)
+			l.pos += 4
+		case strings.HasPrefix(l.src[l.pos:], `"""`):
+			l.pos += 3
+			return gqlToken{kind: gqlString, value: blockStringValue(b.String()), pos: start}, nil
+		default:
+			b.WriteByte(l.src[l.pos])
+			l.pos++
+		}
+	}
+
+	return gqlToken{}, l.errorf(start, "unterminated block string")
+}
+
+// blockStringValue removes the common indentation and the leading and trailing
+// blank lines of a block string
+func blockStringValue(raw string) string {
+	lines := strings.Split(strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(raw), "\n")
+
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= indent {
+				lines[i] = lines[i][indent:]
+			} else {
+				lines[i] = ""
+			}
+		}
+	}
+
+	for len(lines) > 0 && strings.TrimLeft(lines[0], " \t") == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[len(lines)-1], " \t") == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isASCIIDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// gqlParser is a recursive descent parser of executable GraphQL documents
+type gqlParser struct {
+	lexer *gqlLexer
+	token gqlToken
+	depth int
+}
+
+// parseGraphQL parses the executable definitions (operations and fragments) of the document
+func parseGraphQL(src string) (*gqlDocument, error) {
+	p := &gqlParser{lexer: &gqlLexer{src: src}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	doc := &gqlDocument{fragments: map[string]*gqlFragment{}}
+	for p.token.kind != gqlEOF {
+		if p.peekName("fragment") {
+			f, err := p.parseFragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.fragments[f.name]; ok {
+				return nil, p.lexer.errorf(p.token.pos, "fragment %s is defined more than once", f.name)
+			}
+			doc.fragments[f.name] = f
+			continue
+		}
+
+		op, err := p.parseOperation()
+		if err != nil {
+			return nil, err
+		}
+		doc.operations = append(doc.operations, op)
+	}
+
+	if len(doc.operations) == 0 {
+		return nil, p.lexer.errorf(p.token.pos, "the document contains no operation")
+	}
+
+	return doc, nil
+}
+
+func (p *gqlParser) advance() error {
+	t, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.token = t
+
+	return nil
+}
+
+func (p *gqlParser) unexpected() error {
+	if p.token.kind == gqlEOF {
+		return p.lexer.errorf(p.token.pos, "unexpected end of the document")
+	}
+
+	return p.lexer.errorf(p.token.pos, "unexpected %q", p.token.value)
+}
+
+// nest enters a nested selection set, value or type, failing once the document is nested
+// deeper than gqlMaxDepth. Every successful nest must be followed by unnest
+func (p *gqlParser) nest() error {
+	if p.depth >= gqlMaxDepth {
+		return fmt.Errorf("%w at %d: the document is nested deeper than %d levels", ErrGraphQLDepth, p.token.pos, gqlMaxDepth)
+	}
+	p.depth++
+
+	return nil
+}
+
+func (p *gqlParser) unnest() {
+	p.depth--
+}
+
+func (p *gqlParser) peek(punctuator string) bool {
+	return p.token.kind == gqlPunctuator && p.token.value == punctuator
+}
+
+func (p *gqlParser) peekName(name string) bool {
+	return p.token.kind == gqlName && p.token.value == name
+}
+
+func (p *gqlParser) expect(punctuator string) error {
+	if !p.peek(punctuator) {
+		return p.unexpected()
+	}
+
+	return p.advance()
+}
+
+func (p *gqlParser) expectName() (string, error) {
+	if p.token.kind != gqlName {
+		return "", p.unexpected()
+	}
+	name := p.token.value
+
+	return name, p.advance()
+}
+
+func (p *gqlParser) parseOperation() (*gqlOperation, error) {
+	op := &gqlOperation{kind: "query"}
+
+	if !p.peek("{") {
+		kind, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		if kind != "query" && kind != "mutation" && kind != "subscription" {
+			return nil, p.lexer.errorf(p.token.pos, "unknown operation type %q", kind)
+		}
+		op.kind = kind
+
+		if p.token.kind == gqlName {
+			op.name = p.token.value
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+
+		if p.peek("(") {
+			if op.variables, err = p.parseVariables(); err != nil {
+				return nil, err
+			}
+		}
+
+		if op.directives, err = p.parseDirectives(); err != nil {
+			return nil, err
+		}
+	}
+
+	selections, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.selections = selections
+
+	return op, nil
+}
+
+func (p *gqlParser) parseFragment() (*gqlFragment, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	if name == "on" {
+		return nil, p.lexer.errorf(p.token.pos, "fragments must not be named on")
+	}
+
+	if !p.peekName("on") {
+		return nil, p.unexpected()
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	f := &gqlFragment{name: name}
+	if f.typeCondition, err = p.expectName(); err != nil {
+		return nil, err
+	}
+	if f.directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	if f.selections, err = p.parseSelectionSet(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (p *gqlParser) parseVariables() ([]*gqlVariable, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	variables := []*gqlVariable{}
+	for !p.peek(")") {
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+
+		v := &gqlVariable{}
+		var err error
+		if v.name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if v.typ, err = p.parseType(); err != nil {
+			return nil, err
+		}
+
+		if p.peek("=") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if v.defaults, err = p.parseValue(true); err != nil {
+				return nil, err
+			}
+		}
+
+		// directives of variables are accepted, but ignored
+		if _, err := p.parseDirectives(); err != nil {
+			return nil, err
+		}
+
+		variables = append(variables, v)
+	}
+
+	if len(variables) == 0 {
+		return nil, p.unexpected()
+	}
+
+	return variables, p.advance()
+}
+
+// parseType parses the type reference of a variable into its textual representation
+func (p *gqlParser) parseType() (string, error) {
+	if err := p.nest(); err != nil {
+		return "", err
+	}
+	defer p.unnest()
+
+	var typ string
+	if p.peek("[") {
+		if err := p.advance(); err != nil {
+			return "", err
+		}
+		inner, err := p.parseType()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect("]"); err != nil {
+			return "", err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		name, err := p.expectName()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+
+	if p.peek("!") {
+		typ += "!"
+		return typ, p.advance()
+	}
+
+	return typ, nil
+}
+
+func (p *gqlParser) parseDirectives() ([]*gqlDirective, error) {
+	directives := []*gqlDirective{}
+	for p.peek("@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		d := &gqlDirective{}
+		var err error
+		if d.name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+		if d.arguments, err = p.parseArguments(); err != nil {
+			return nil, err
+		}
+
+		directives = append(directives, d)
+	}
+
+	return directives, nil
+}
+
+func (p *gqlParser) parseArguments() ([]*gqlArgument, error) {
+	if !p.peek("(") {
+		return nil, nil
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	arguments := []*gqlArgument{}
+	for !p.peek(")") {
+		a := &gqlArgument{}
+		var err error
+		if a.name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if a.value, err = p.parseValue(false); err != nil {
+			return nil, err
+		}
+
+		arguments = append(arguments, a)
+	}
+
+	if len(arguments) == 0 {
+		return nil, p.unexpected()
+	}
+
+	return arguments, p.advance()
+}
+
+func (p *gqlParser) parseSelectionSet() ([]*gqlSelection, error) {
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer p.unnest()
+
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	selections := []*gqlSelection{}
+	for !p.peek("}") {
+		s, err := p.parseSelection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, s)
+	}
+
+	if len(selections) == 0 {
+		return nil, p.unexpected()
+	}
+
+	return selections, p.advance()
+}
+
+func (p *gqlParser) parseSelection() (*gqlSelection, error) {
+	s := &gqlSelection{}
+	var err error
+
+	if p.peek("...") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		if p.token.kind == gqlName && p.token.value != "on" {
+			s.fragment = p.token.value
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if s.directives, err = p.parseDirectives(); err != nil {
+				return nil, err
+			}
+			return s, nil
+		}
+
+		s.inline = true
+		if p.peekName("on") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if s.typeCondition, err = p.expectName(); err != nil {
+				return nil, err
+			}
+		}
+		if s.directives, err = p.parseDirectives(); err != nil {
+			return nil, err
+		}
+		if s.selections, err = p.parseSelectionSet(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	if s.name, err = p.expectName(); err != nil {
+		return nil, err
+	}
+	if p.peek(":") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		s.alias = s.name
+		if s.name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.arguments, err = p.parseArguments(); err != nil {
+		return nil, err
+	}
+	if s.directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		if s.selections, err = p.parseSelectionSet(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// parseValue parses a value, which must not contain variables if it is constant
+func (p *gqlParser) parseValue(constant bool) (*gqlValue, error) {
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer p.unnest()
+
+	t := p.token
+	switch t.kind {
+	case gqlInt:
+		return &gqlValue{kind: gqlIntValue, raw: t.value}, p.advance()
+	case gqlFloat:
+		return &gqlValue{kind: gqlFloatValue, raw: t.value}, p.advance()
+	case gqlString:
+		return &gqlValue{kind: gqlStringValue, raw: t.value}, p.advance()
+	case gqlName:
+		switch t.value {
+		case "true", "false":
+			return &gqlValue{kind: gqlBooleanValue, raw: t.value}, p.advance()
+		case "null":
+			return &gqlValue{kind: gqlNullValue}, p.advance()
+		}
+		return &gqlValue{kind: gqlEnumValue, raw: t.value}, p.advance()
+	}
+
+	switch {
+	case p.peek("$") && !constant:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		return &gqlValue{kind: gqlVariableValue, raw: name}, nil
+
+	case p.peek("["):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		v := &gqlValue{kind: gqlListValue, list: []*gqlValue{}}
+		for !p.peek("]") {
+			item, err := p.parseValue(constant)
+			if err != nil {
+				return nil, err
+			}
+			v.list = append(v.list, item)
+		}
+		return v, p.advance()
+
+	case p.peek("{"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		v := &gqlValue{kind: gqlObjectValue, fields: []*gqlArgument{}}
+		for !p.peek("}") {
+			f := &gqlArgument{}
+			var err error
+			if f.name, err = p.expectName(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if f.value, err = p.parseValue(constant); err != nil {
+				return nil, err
+			}
+			v.fields = append(v.fields, f)
+		}
+		return v, p.advance()
+	}
+
+	return nil, p.unexpected()
+}
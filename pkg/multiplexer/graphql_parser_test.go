@@ -0,0 +1,130 @@
+package multiplexer
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"testing"
+)
+
+type GraphQLParserSuite struct {
+	suite.Suite
+}
+
+func (s *GraphQLParserSuite) TestParse() {
+	doc, err := parseGraphQL(`
+		# operations can be named and have variables with default values
+		query Operations($name: String! = "operations", $ids: [Int!], $verbose: Boolean) {
+			first: listOperations(name: $name, pageSize: 10) @include(if: $verbose) {
+				...operationsFields
+			}
+			getOperation(name: "operations/ab\n") {
+				... on Operation { name }
+				... @skip(if: true) { done }
+			}
+		}
+
+		fragment operationsFields on ListOperationsResponse {
+			operations { name, done }
+		}
+
+		mutation {
+			call(message: """
+				Hello
+				  World
+			""", filter: {list: [1, -2.5e3, ENUM, null, false]}) { message }
+		}
+	`)
+	s.Require().NoError(err)
+	s.Len(doc.operations, 2)
+	s.Contains(doc.fragments, "operationsFields")
+
+	query := doc.operations[0]
+	s.Equal("query", query.kind)
+	s.Equal("Operations", query.name)
+	s.Equal([]*gqlVariable{
+		{name: "name", typ: "String!", defaults: &gqlValue{kind: gqlStringValue, raw: "operations"}},
+		{name: "ids", typ: "[Int!]"},
+		{name: "verbose", typ: "Boolean"},
+	}, query.variables)
+
+	first := query.selections[0]
+	s.Equal("first", first.alias)
+	s.Equal("listOperations", first.name)
+	s.Equal("first", first.responseKey())
+	s.Equal(&gqlValue{kind: gqlVariableValue, raw: "name"}, first.arguments[0].value)
+	s.Equal(&gqlValue{kind: gqlIntValue, raw: "10"}, first.arguments[1].value)
+	s.Equal("include", first.directives[0].name)
+	s.Equal("operationsFields", first.selections[0].fragment)
+
+	get := query.selections[1]
+	s.Equal("operations/ab\n", get.arguments[0].value.raw)
+	s.True(get.selections[0].inline)
+	s.Equal("Operation", get.selections[0].typeCondition)
+	s.True(get.selections[1].inline)
+	s.Equal("", get.selections[1].typeCondition)
+	s.Equal("skip", get.selections[1].directives[0].name)
+
+	mutation := doc.operations[1]
+	s.Equal("mutation", mutation.kind)
+	s.Equal("Hello\n  World", mutation.selections[0].arguments[0].value.raw)
+	s.Equal(&gqlValue{kind: gqlObjectValue, fields: []*gqlArgument{{
+		name: "list",
+		value: &gqlValue{kind: gqlListValue, list: []*gqlValue{
+			{kind: gqlIntValue, raw: "1"},
+			{kind: gqlFloatValue, raw: "-2.5e3"},
+			{kind: gqlEnumValue, raw: "ENUM"},
+			{kind: gqlNullValue},
+			{kind: gqlBooleanValue, raw: "false"},
+		}},
+	}}}, mutation.selections[0].arguments[1].value)
+}
+
+func (s *GraphQLParserSuite) TestSyntaxErrors() {
+	for _, src := range []string{
+		``,
+		`fragment f on Query { a }`,
+		`{ }`,
+		`{ a `,
+		`{ a(b: ) }`,
+		`{ a(b: "unterminated) }`,
+		`{ a(b: 01) }`,
+		`{ a(b: 1.) }`,
+		`{ a(b: 1x) }`,
+		`{ a(b: "\q") }`,
+		`subscribe { a }`,
+		`query ($a: String = $b) { a }`,
+		`query () { a }`,
+		`{ a } fragment on on Query { a }`,
+		`{ a } fragment f on Query { a } fragment f on Query { a }`,
+		`{ a % }`,
+	} {
+		_, err := parseGraphQL(src)
+		s.True(errors.Is(err, ErrGraphQLSyntax), src, err)
+	}
+}
+
+func (s *GraphQLParserSuite) TestDepth() {
+	nested := func(open, close string, depth int) string {
+		return strings.Repeat(open, depth) + strings.Repeat(close, depth)
+	}
+
+	_, err := parseGraphQL(nested("{ a ", "}", gqlMaxDepth))
+	s.NoError(err)
+
+	for _, src := range []string{
+		nested("{ a ", "}", gqlMaxDepth+1),
+		// deeply nested documents are rejected before they exhaust the stack
+		nested("{ a ", "}", 1000000),
+		"{ a(b: " + nested("[", "]", 1000000) + ") }",
+		"{ a(b: " + nested("{c: ", "}", 1000000) + ") }",
+		"query ($a: " + nested("[", "]", 1000000) + ") { a }",
+	} {
+		_, err := parseGraphQL(src)
+		s.True(errors.Is(err, ErrGraphQLDepth), err)
+	}
+}
+
+func TestGraphQLParserSuite(t *testing.T) {
+	suite.Run(t, &GraphQLParserSuite{})
+}
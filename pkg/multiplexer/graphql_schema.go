@@ -0,0 +1,357 @@
+package multiplexer
+
+import (
+	"fmt"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type gqlTypeKind int
+
+const (
+	gqlScalarType gqlTypeKind = iota
+	gqlEnumType
+	gqlObjectType
+	gqlInputType
+)
+
+const (
+	// gqlJSONScalar is the custom scalar of the values without a GraphQL type, e.g. maps,
+	// google.protobuf.Struct or google.protobuf.Any, which are passed in their protojson form
+	gqlJSONScalar = "JSON"
+
+	// gqlInputArgument is the argument of the methods with a well-known input type,
+	// whose protojson form is not an object and cannot be split into arguments
+	gqlInputArgument = "input"
+)
+
+// gqlQueryPrefixes are the prefixes of the method names, which are mapped onto queries
+// instead of mutations if the methods are not annotated by the google.api.http rule
+var gqlQueryPrefixes = []string{"BatchGet", "Check", "Count", "Fetch", "Find", "Get", "List", "Lookup", "Query", "Read", "Search"}
+
+// gqlWellKnownTypes are the names of the scalars of the well-known messages, which
+// have a special protojson form
+var gqlWellKnownTypes = map[protoreflect.FullName]string{
+	"google.protobuf.Timestamp":   "String",
+	"google.protobuf.Duration":    "String",
+	"google.protobuf.FieldMask":   "String",
+	"google.protobuf.BoolValue":   "Boolean",
+	"google.protobuf.Int32Value":  "Int",
+	"google.protobuf.UInt32Value": "Int",
+	"google.protobuf.Int64Value":  "String",
+	"google.protobuf.UInt64Value": "String",
+	"google.protobuf.FloatValue":  "Float",
+	"google.protobuf.DoubleValue": "Float",
+	"google.protobuf.StringValue": "String",
+	"google.protobuf.BytesValue":  "String",
+	"google.protobuf.Struct":      gqlJSONScalar,
+	"google.protobuf.Value":       gqlJSONScalar,
+	"google.protobuf.ListValue":   gqlJSONScalar,
+	"google.protobuf.Any":         gqlJSONScalar,
+	"google.protobuf.Empty":       gqlJSONScalar,
+	"google.protobuf.NullValue":   gqlJSONScalar,
+}
+
+// gqlType is a named type of the GraphQL schema
+type gqlType struct {
+	kind   gqlTypeKind
+	name   string
+	fields []*gqlField
+	values []string
+}
+
+// field returns the field of the object or input type
+func (t *gqlType) field(name string) *gqlField {
+	for _, f := range t.fields {
+		if f.name == name {
+			return f
+		}
+	}
+
+	return nil
+}
+
+// gqlField is a field of an object or input type, or an argument of a field. Fields of the
+// Query and Mutation types are resolved by calling their grpc methods
+type gqlField struct {
+	name string
+	typ  *gqlType
+	list bool
+
+	method protoreflect.MethodDescriptor
+	args   []*gqlField
+	// input is true if the input message is passed as the single input argument
+	input bool
+}
+
+// typeRef returns the GraphQL type reference of the field
+func (f *gqlField) typeRef() string {
+	if f.list {
+		return "[" + f.typ.name + "]"
+	}
+
+	return f.typ.name
+}
+
+// gqlSchema is the GraphQL schema of the unary methods and their messages
+type gqlSchema struct {
+	query    *gqlType
+	mutation *gqlType
+	types    map[string]*gqlType
+
+	// owners are the full names of the descriptors of the type names, so colliding
+	// short names can be detected
+	owners  map[string]protoreflect.FullName
+	outputs map[protoreflect.FullName]*gqlType
+	inputs  map[protoreflect.FullName]*gqlType
+	enums   map[protoreflect.FullName]*gqlType
+}
+
+// newGraphQLSchema builds the schema of the unary methods. Methods are mapped onto the fields of
+// the Query type if they are annotated by a GET http rule or if their names start with one of
+// the gqlQueryPrefixes, onto the fields of the Mutation type otherwise
+func newGraphQLSchema(methods []protoreflect.MethodDescriptor) (*gqlSchema, error) {
+	s := &gqlSchema{
+		query:    &gqlType{kind: gqlObjectType, name: "Query"},
+		mutation: &gqlType{kind: gqlObjectType, name: "Mutation"},
+		types:    map[string]*gqlType{},
+		owners:   map[string]protoreflect.FullName{},
+		outputs:  map[protoreflect.FullName]*gqlType{},
+		inputs:   map[protoreflect.FullName]*gqlType{},
+		enums:    map[protoreflect.FullName]*gqlType{},
+	}
+	for _, t := range []*gqlType{
+		{kind: gqlScalarType, name: "Int"},
+		{kind: gqlScalarType, name: "Float"},
+		{kind: gqlScalarType, name: "String"},
+		{kind: gqlScalarType, name: "Boolean"},
+		{kind: gqlScalarType, name: gqlJSONScalar},
+		s.query,
+		s.mutation,
+	} {
+		s.types[t.name] = t
+		s.owners[t.name] = ""
+	}
+
+	for _, md := range methods {
+		if md.IsStreamingClient() || md.IsStreamingServer() {
+			continue
+		}
+
+		f := &gqlField{
+			method: md,
+			typ:    s.messageType(md.Output(), false),
+		}
+		if _, ok := gqlWellKnownTypes[md.Input().FullName()]; ok && md.Input().Fields().Len() > 0 {
+			f.input = true
+			f.args = []*gqlField{{name: gqlInputArgument, typ: s.messageType(md.Input(), true)}}
+		} else {
+			f.args = s.fields(md.Input(), true)
+		}
+
+		root := s.mutation
+		if isGraphQLQuery(md) {
+			root = s.query
+		}
+
+		f.name = lowerFirst(string(md.Name()))
+		if root.field(f.name) != nil {
+			f.name = string(md.Parent().Name()) + "_" + f.name
+		}
+		if root.field(f.name) != nil {
+			f.name = gqlFullName(md.Parent().FullName()) + "_" + lowerFirst(string(md.Name()))
+		}
+		if root.field(f.name) != nil {
+			return nil, fmt.Errorf("graphql: field %s of %s is defined more than once", f.name, root.name)
+		}
+
+		root.fields = append(root.fields, f)
+	}
+
+	return s, nil
+}
+
+// isGraphQLQuery returns true if the method is mapped onto a query
+func isGraphQLQuery(md protoreflect.MethodDescriptor) bool {
+	if rule, ok := proto.GetExtension(md.Options(), annotations.E_Http).(*annotations.HttpRule); ok && rule != nil {
+		return rule.GetGet() != ""
+	}
+
+	name := string(md.Name())
+	for _, prefix := range gqlQueryPrefixes {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		// the prefix must be a whole word of the name, e.g. Listen is not a query
+		if rest := name[len(prefix):]; rest == "" || !unicode.IsLower(rune(rest[0])) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fields returns the fields of the message, named by their JSON names
+func (s *gqlSchema) fields(md protoreflect.MessageDescriptor, input bool) []*gqlField {
+	fields := []*gqlField{}
+	for i := 0; i < md.Fields().Len(); i++ {
+		fd := md.Fields().Get(i)
+		fields = append(fields, &gqlField{
+			name: fd.JSONName(),
+			typ:  s.fieldType(fd, input),
+			list: fd.IsList(),
+		})
+	}
+
+	return fields
+}
+
+func (s *gqlSchema) fieldType(fd protoreflect.FieldDescriptor, input bool) *gqlType {
+	if fd.IsMap() {
+		return s.types[gqlJSONScalar]
+	}
+
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return s.types["Boolean"]
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return s.types["Int"]
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return s.types["Float"]
+	case protoreflect.EnumKind:
+		return s.enumType(fd.Enum())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return s.messageType(fd.Message(), input)
+	}
+
+	// 64-bit integers are strings in protojson, the same as strings and bytes
+	return s.types["String"]
+}
+
+func (s *gqlSchema) enumType(ed protoreflect.EnumDescriptor) *gqlType {
+	if name, ok := gqlWellKnownTypes[ed.FullName()]; ok {
+		return s.types[name]
+	}
+	if t, ok := s.enums[ed.FullName()]; ok {
+		return t
+	}
+
+	t := &gqlType{kind: gqlEnumType, name: s.typeName(ed, "")}
+	for i := 0; i < ed.Values().Len(); i++ {
+		t.values = append(t.values, string(ed.Values().Get(i).Name()))
+	}
+	s.enums[ed.FullName()] = t
+	s.types[t.name] = t
+
+	return t
+}
+
+func (s *gqlSchema) messageType(md protoreflect.MessageDescriptor, input bool) *gqlType {
+	if name, ok := gqlWellKnownTypes[md.FullName()]; ok {
+		return s.types[name]
+	}
+	if md.Fields().Len() == 0 {
+		// GraphQL types must have at least one field
+		return s.types[gqlJSONScalar]
+	}
+
+	cache, kind, suffix := s.outputs, gqlObjectType, ""
+	if input {
+		cache, kind, suffix = s.inputs, gqlInputType, "Input"
+	}
+	if t, ok := cache[md.FullName()]; ok {
+		return t
+	}
+
+	// the type is cached before its fields are built, so recursive messages are supported
+	t := &gqlType{kind: kind, name: s.typeName(md, suffix)}
+	cache[md.FullName()] = t
+	s.types[t.name] = t
+	t.fields = s.fields(md, input)
+
+	return t
+}
+
+// typeName returns the name of the message or enum type, which is its name relative to the
+// package (e.g. Operation or Parent_Nested) or its full name if the relative name is taken
+func (s *gqlSchema) typeName(desc protoreflect.Descriptor, suffix string) string {
+	name := strings.TrimPrefix(string(desc.FullName()), string(desc.ParentFile().Package())+".")
+	name = strings.ReplaceAll(name, ".", "_") + suffix
+
+	if owner, ok := s.owners[name]; ok && owner != desc.FullName() {
+		name = gqlFullName(desc.FullName()) + suffix
+	}
+	s.owners[name] = desc.FullName()
+
+	return name
+}
+
+// gqlFullName converts the full protobuf name into a GraphQL name
+func gqlFullName(name protoreflect.FullName) string {
+	return strings.ReplaceAll(string(name), ".", "_")
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// sdl prints the schema in the GraphQL schema definition language
+func (s *gqlSchema) sdl() string {
+	b := &strings.Builder{}
+	b.WriteString("scalar " + gqlJSONScalar + "\n")
+
+	names := []string{}
+	for name, t := range s.types {
+		if t.kind != gqlScalarType && t != s.query && t != s.mutation {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	types := []*gqlType{s.query, s.mutation}
+	for _, name := range names {
+		types = append(types, s.types[name])
+	}
+
+	for _, t := range types {
+		if len(t.fields) == 0 && len(t.values) == 0 {
+			continue
+		}
+
+		switch t.kind {
+		case gqlEnumType:
+			b.WriteString("\nenum " + t.name + " {\n")
+			for _, v := range t.values {
+				b.WriteString("  " + v + "\n")
+			}
+		case gqlInputType:
+			b.WriteString("\ninput " + t.name + " {\n")
+		default:
+			b.WriteString("\ntype " + t.name + " {\n")
+		}
+
+		for _, f := range t.fields {
+			b.WriteString("  " + f.name)
+			if len(f.args) > 0 {
+				args := []string{}
+				for _, a := range f.args {
+					args = append(args, a.name+": "+a.typeRef())
+				}
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			b.WriteString(": " + f.typeRef() + "\n")
+		}
+		b.WriteString("}\n")
+	}
+
+	return b.String()
+}
@@ -0,0 +1,89 @@
+package multiplexer
+
+import (
+	"github.com/petomalina/xrpc/v2/examples/api"
+	"github.com/stretchr/testify/suite"
+	lpb "google.golang.org/genproto/googleapis/longrunning"
+	hpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"testing"
+)
+
+type GraphQLSchemaSuite struct {
+	suite.Suite
+}
+
+func (s *GraphQLSchemaSuite) TestSDL() {
+	grpcServer := createGrpcServer(&EchoService{Logger: createLogger()})
+	lpb.RegisterOperationsServer(grpcServer, &OperationsService{})
+
+	schema, err := newGraphQLSchema(registeredMethods(grpcServer))
+	s.NoError(err)
+	s.Equal(`scalar JSON
+
+type Query {
+  listOperations(name: String, filter: String, pageSize: Int, pageToken: String): ListOperationsResponse
+  getOperation(name: String): Operation
+}
+
+type Mutation {
+  call(message: String): EchoMessage
+  deleteOperation(name: String): JSON
+  cancelOperation(name: String): JSON
+  waitOperation(name: String, timeout: String): Operation
+}
+
+type EchoMessage {
+  message: String
+}
+
+type ListOperationsResponse {
+  operations: [Operation]
+  nextPageToken: String
+}
+
+type Operation {
+  name: String
+  metadata: JSON
+  done: Boolean
+  error: Status
+  response: JSON
+}
+
+type Status {
+  code: Int
+  message: String
+  details: [JSON]
+}
+`, schema.sdl())
+}
+
+func (s *GraphQLSchemaSuite) TestNaming() {
+	health := hpb.File_grpc_health_v1_health_proto.Services().Get(0).Methods()
+	echo := api.File_echo_proto.Services().Get(0).Methods()
+
+	// methods are queries by their GET http rules or by their names
+	s.True(isGraphQLQuery(health.ByName("Check")))
+	s.False(isGraphQLQuery(health.ByName("Watch")))
+	s.False(isGraphQLQuery(echo.ByName("Call")))
+
+	// methods of the same names are prefixed by their services
+	schema, err := newGraphQLSchema([]protoreflect.MethodDescriptor{
+		health.ByName("Check"),
+		health.ByName("Check"),
+		health.ByName("Watch"),
+	})
+	s.NoError(err)
+	s.NotNil(schema.query.field("check"))
+	s.NotNil(schema.query.field("Health_check"))
+	s.Len(schema.query.fields, 2, "streaming methods are skipped")
+
+	status := schema.types["HealthCheckResponse_ServingStatus"]
+	s.Equal(gqlEnumType, status.kind)
+	s.Equal([]string{"UNKNOWN", "SERVING", "NOT_SERVING", "SERVICE_UNKNOWN"}, status.values)
+	s.Equal(status, schema.query.field("check").typ.field("status").typ)
+}
+
+func TestGraphQLSchemaSuite(t *testing.T) {
+	suite.Run(t, &GraphQLSchemaSuite{})
+}
@@ -0,0 +1,240 @@
+package multiplexer
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/grpc-ecosystem/go-grpc-middleware/util/metautils"
+	"github.com/petomalina/xrpc/v2/examples/api"
+	"github.com/stretchr/testify/suite"
+	lpb "google.golang.org/genproto/googleapis/longrunning"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type GraphQLSuite struct {
+	suite.Suite
+
+	echoService *EchoService
+	operations  *OperationsService
+	graphQL     *GraphQL
+	handler     http.Handler
+}
+
+func (s *GraphQLSuite) SetupTest() {
+	s.echoService = &EchoService{Logger: createLogger()}
+	s.operations = &OperationsService{}
+
+	grpcServer := createGrpcServer(s.echoService)
+	lpb.RegisterOperationsServer(grpcServer, s.operations)
+
+	var err error
+	s.graphQL, err = NewGraphQL(grpcServer)
+	s.NoError(err)
+
+	s.handler = Make(nil,
+		GRPCHandler(grpcServer),
+		GraphQLHandler(s.graphQL),
+	)
+}
+
+func (s *GraphQLSuite) post(query string, variables map[string]interface{}) *httptest.ResponseRecorder {
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/graphql", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Caller", "graphql")
+
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func (s *GraphQLSuite) get(query string) *httptest.ResponseRecorder {
+	target := "http://localhost/graphql"
+	if query != "" {
+		target += "?query=" + url.QueryEscape(query)
+	}
+
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+	return rec
+}
+
+func (s *GraphQLSuite) TestIsGraphQLRequest() {
+	request := func(method, contentType string) *http.Request {
+		req := httptest.NewRequest(method, "http://localhost/graphql", nil)
+		req.Header.Set("Content-Type", contentType)
+		return req
+	}
+
+	candidates := map[*http.Request]bool{
+		request(http.MethodGet, ""):                                 true,
+		request(http.MethodPost, "application/json; charset=utf-8"): true,
+		request(http.MethodPost, ContentTypeGraphQL):                true,
+		request(http.MethodPost, "application/grpc"):                false,
+		request(http.MethodPut, "application/json"):                 false,
+	}
+
+	for req, result := range candidates {
+		s.Equal(result, IsGraphQLRequest(req), "Request is badly considered a GraphQL request", req.Method, req.Header)
+	}
+}
+
+func (s *GraphQLSuite) TestQuery() {
+	rec := s.post(`
+		query Operations($name: String!, $verbose: Boolean = false) {
+			__typename
+			list: listOperations(name: $name, pageSize: 10) {
+				operations { ...operation }
+				nextPageToken @include(if: $verbose)
+			}
+			getOperation(name: "operations/a") {
+				... on Operation { kind: __typename name }
+				done
+			}
+		}
+
+		fragment operation on Operation {
+			name
+			error { code }
+		}
+	`, map[string]interface{}{"name": "operations"})
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal("application/json", rec.Header().Get("Content-Type"))
+
+	// the fields are ordered by the selections
+	s.Equal(`{"data":{"__typename":"Query",`+
+		`"list":{"operations":[{"name":"operations/first","error":null}]},`+
+		`"getOperation":{"kind":"Operation","name":"operations/a","done":true}}}`, rec.Body.String())
+	s.Equal("operations", s.operations.list.Name)
+	s.Equal(int32(10), s.operations.list.PageSize)
+
+	rec = s.get(`{ getOperation(name: "operations/b") { name } }`)
+	s.Equal(http.StatusOK, rec.Code)
+	s.JSONEq(`{"data":{"getOperation":{"name":"operations/b"}}}`, rec.Body.String())
+}
+
+func (s *GraphQLSuite) TestMutation() {
+	var incoming metautils.NiceMD
+	s.echoService.onCall = func(ctx context.Context, m *api.EchoMessage) {
+		incoming = metautils.ExtractIncoming(ctx)
+	}
+
+	rec := s.post(`mutation Echo($message: String) { first: call(message: $message) { message } second: call(message: "World") { message } }`,
+		map[string]interface{}{"message": "Hello"})
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal(`{"data":{"first":{"message":"Hello"},"second":{"message":"World"}}}`, rec.Body.String())
+	s.Equal("graphql", incoming.Get("x-caller"))
+
+	rec = s.post(`mutation { cancelOperation(name: "operations/a") }`, nil)
+	s.JSONEq(`{"data":{"cancelOperation":{}}}`, rec.Body.String())
+	s.Equal("operations/a", s.operations.cancel.Name)
+
+	// mutations are not allowed in GET requests
+	rec = s.get(`mutation { call(message: "Hello") { message } }`)
+	s.Equal(http.StatusMethodNotAllowed, rec.Code)
+	s.Equal(http.MethodPost, rec.Header().Get("Allow"))
+}
+
+func (s *GraphQLSuite) TestErrors() {
+	rec := s.post(`{ missing: getOperation(name: "operations/missing") { name } found: getOperation(name: "operations/a") { name } }`, nil)
+	s.Equal(http.StatusOK, rec.Code)
+	s.JSONEq(`{
+		"data":{"missing":null,"found":{"name":"operations/a"}},
+		"errors":[{"message":"operation not found","path":["missing"],"extensions":{"code":"NOT_FOUND"}}]
+	}`, rec.Body.String())
+
+	rec = s.post(`mutation { waitOperation(name: "operations/a") { name } }`, nil)
+	s.Contains(rec.Body.String(), `"extensions":{"code":"UNIMPLEMENTED"}`)
+
+	rec = s.post(`{ getOperation(name: 1) { name } }`, nil)
+	s.Contains(rec.Body.String(), `"extensions":{"code":"INVALID_ARGUMENT"}`)
+
+	// requests failing before the execution have no data
+	for query, message := range map[string]string{
+		`{ getOperation(name: "a") { unknown } }`:                               "cannot query field unknown on type Operation",
+		`{ getOperation(name: "a") }`:                                           "must have a selection of subfields",
+		`{ getOperation(name: "a") { name { value } } }`:                        "must not have a selection",
+		`{ getOperation(unknown: "a") { name } }`:                               "unknown argument unknown",
+		`{ getOperation(name: "a") { ...missing } }`:                            "unknown fragment missing",
+		`{ getOperation(name: "a") { ...f } } fragment f on Status { code }`:    "cannot be spread on Operation",
+		`{ getOperation(name: "a") { ...f } } fragment f on Operation { ...f }`: "spreads itself",
+		`{ getOperation(name: "a") @defer { name } }`:                           "unknown directive @defer",
+		`query ($name: String!) { getOperation(name: $name) { name } }`:         "variable $name of the required type String! was not provided",
+		`{ getOperation(name: $name) { name } }`:                                "variable $name is not defined",
+		`{ a } { b }`:                                                           "operation name is required",
+		`subscription { getOperation(name: "a") { name } }`:                     "subscriptions are not supported",
+		`{ getOperation(name: "a") { name }`:                                    "syntax error",
+	} {
+		rec := s.post(query, nil)
+		s.Equal(http.StatusOK, rec.Code, query)
+
+		res := map[string]interface{}{}
+		s.NoError(json.Unmarshal(rec.Body.Bytes(), &res), query)
+		s.NotContains(res, "data", query)
+		s.Contains(rec.Body.String(), message, query)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/graphql", strings.NewReader(`{"query":`))
+	req.Header.Set("Content-Type", "application/json")
+	s.handler.ServeHTTP(rec, req)
+	s.Equal(http.StatusBadRequest, rec.Code)
+}
+
+func (s *GraphQLSuite) TestDepthLimits() {
+	// deeply nested documents are rejected by the parser
+	query := strings.Repeat("{ getOperation ", 100000) + strings.Repeat("}", 100000)
+	rec := s.post(query, nil)
+	s.Equal(http.StatusOK, rec.Code)
+	s.Contains(rec.Body.String(), ErrGraphQLDepth.Error())
+
+	// chains of fragments are not limited by the parser, but by the validation
+	query = `{ ...f0 }`
+	for i := 0; i < 1000; i++ {
+		query += " fragment f" + strconv.Itoa(i) + " on Query { ...f" + strconv.Itoa(i+1) + " }"
+	}
+	query += ` fragment f1000 on Query { __typename }`
+	rec = s.post(query, nil)
+	s.Equal(http.StatusOK, rec.Code)
+	s.Contains(rec.Body.String(), ErrGraphQLDepth.Error())
+
+	// bodies over the limit are rejected before they are parsed
+	query = strings.Repeat("{ a ", 1500000) + strings.Repeat("}", 1500000)
+	rec = s.post(query, nil)
+	s.Equal(http.StatusBadRequest, rec.Code)
+}
+
+func (s *GraphQLSuite) TestRequests() {
+	// documents can be sent as the body with their own content type
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/graphql", strings.NewReader(`{ getOperation(name: "a") { name } }`))
+	req.Header.Set("Content-Type", ContentTypeGraphQL)
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	s.JSONEq(`{"data":{"getOperation":{"name":"a"}}}`, rec.Body.String())
+
+	// operations are selected by their names
+	body := `{"query":"query A { a: getOperation(name: \"a\") { name } } query B { b: getOperation(name: \"b\") { name } }","operationName":"B"}`
+	req = httptest.NewRequest(http.MethodPost, "http://localhost/graphql", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	s.JSONEq(`{"data":{"b":{"name":"b"}}}`, rec.Body.String())
+
+	// GET requests without a query return the schema
+	rec = s.get("")
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal(s.graphQL.Schema(), rec.Body.String())
+	s.Contains(rec.Body.String(), "getOperation(name: String): Operation")
+}
+
+func TestGraphQLSuite(t *testing.T) {
+	suite.Run(t, &GraphQLSuite{})
+}
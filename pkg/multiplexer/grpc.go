@@ -6,9 +6,9 @@ import (
 )
 
 // IsGRPCRequest returns true if the message is considered to be
-// a GRPC message
+// a GRPC message. GRPC Web requests are not considered to be GRPC messages
 func IsGRPCRequest(r *http.Request) bool {
-	return r.ProtoMajor == 2 && strings.Contains(r.Header.Get("Content-Type"), "application/grpc")
+	return r.ProtoMajor == 2 && strings.Contains(r.Header.Get("Content-Type"), "application/grpc") && !IsGRPCWebRequest(r)
 }
 
 // GRPCHandler fulfills requests that are considered to be grpc requests
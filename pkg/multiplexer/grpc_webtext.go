@@ -6,6 +6,8 @@ import (
 )
 
 // GRPCWebTextHandler fulfills requests that are considered to be grpc web text requests
+//
+// Deprecated: the improbable-eng grpc-web library is archived, use GRPCWebHandler instead
 func GRPCWebTextHandler(server *grpcweb.WrappedGrpcServer, selectors ...Selector) Handler {
 	filter := append([]Selector{
 		OrSelector(
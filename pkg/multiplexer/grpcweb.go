@@ -0,0 +1,370 @@
+package multiplexer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ContentTypeGRPCWeb is the content type of binary grpc-web requests
+	ContentTypeGRPCWeb = "application/grpc-web"
+	// ContentTypeGRPCWebText is the content type of base64 encoded grpc-web requests
+	ContentTypeGRPCWebText = "application/grpc-web-text"
+
+	// grpcWebTrailerFlag marks the frame carrying the trailers in the response body
+	grpcWebTrailerFlag = 0x80
+)
+
+var (
+	// ErrMalformedGRPCWebText is returned if the body of a grpc-web-text request
+	// is not base64 encoded
+	ErrMalformedGRPCWebText = errors.New("grpc-web: malformed base64 body")
+)
+
+// IsGRPCWebRequest returns true if the request is a grpc-web request,
+// either binary (application/grpc-web[+proto]) or text (application/grpc-web-text[+proto])
+func IsGRPCWebRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), ContentTypeGRPCWeb)
+}
+
+// IsGRPCWebPreflightRequest returns true if the request is a CORS preflight
+// of a grpc-web request
+func IsGRPCWebPreflightRequest(r *http.Request) bool {
+	if r.Method != http.MethodOptions || r.Header.Get("Origin") == "" {
+		return false
+	}
+
+	for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if strings.EqualFold(strings.TrimSpace(h), "x-grpc-web") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GRPCWebHandler fulfills grpc-web requests and their CORS preflights by translating
+// them onto the given server, which is usually a *grpc.Server
+func GRPCWebHandler(server http.Handler, selectors ...Selector) Handler {
+	return NewGRPCWebHandler(server, WithGRPCWebSelectors(selectors...))
+}
+
+// GRPCWebOption is an extendable builder for the GRPCWebHandler options
+type GRPCWebOption func(o *grpcWebOptions)
+
+type grpcWebOptions struct {
+	selectors []Selector
+	origins   []string
+	maxAge    time.Duration
+}
+
+// WithGRPCWebSelectors adds selectors that must be fulfilled on top of
+// the IsGRPCWebRequest selector
+func WithGRPCWebSelectors(selectors ...Selector) GRPCWebOption {
+	return func(o *grpcWebOptions) {
+		o.selectors = append(o.selectors, selectors...)
+	}
+}
+
+// WithGRPCWebAllowedOrigins sets the origins allowed by the CORS policy, e.g.
+// https://app.example.com. Only same-origin requests are allowed if no origins are
+// provided. All origins are allowed by *, but without credentials
+func WithGRPCWebAllowedOrigins(origins ...string) GRPCWebOption {
+	return func(o *grpcWebOptions) {
+		o.origins = append(o.origins, origins...)
+	}
+}
+
+// WithGRPCWebCORSMaxAge sets for how long browsers may cache the preflight responses
+func WithGRPCWebCORSMaxAge(maxAge time.Duration) GRPCWebOption {
+	return func(o *grpcWebOptions) {
+		o.maxAge = maxAge
+	}
+}
+
+// NewGRPCWebHandler creates a GRPCWebHandler configured by the given options. Requests
+// are rewritten into grpc requests and served by the server in-process, streaming the
+// response messages back to the client followed by the trailers frame. Requests of
+// origins not allowed by the CORS policy are rejected with 403 Forbidden
+func NewGRPCWebHandler(server http.Handler, opts ...GRPCWebOption) Handler {
+	options := &grpcWebOptions{
+		maxAge: time.Minute * 10,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	filter := append([]Selector{
+		OrSelector(
+			IsGRPCWebPreflightRequest,
+			IsGRPCWebRequest,
+		),
+	}, options.selectors...)
+
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for _, f := range filter {
+			if !f(r) {
+				return false
+			}
+		}
+
+		origin := r.Header.Get("Origin")
+		allowedOrigin, credentials := options.allowedOrigin(r)
+		if origin != "" && allowedOrigin == "" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("grpc-web: origin " + origin + " is not allowed"))
+			return true
+		}
+
+		if r.Method == http.MethodOptions {
+			options.preflight(w, r, allowedOrigin, credentials)
+			return true
+		}
+
+		req, err := grpcWebToGRPCRequest(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return true
+		}
+
+		rw := newGRPCWebResponseWriter(w, r.Header.Get("Content-Type"), allowedOrigin, credentials)
+		server.ServeHTTP(rw, req)
+		rw.finish()
+
+		return true
+	}
+}
+
+// allowedOrigin returns the value of the Access-Control-Allow-Origin header for the
+// origin of the request, and whether the credentials are allowed. The value is empty
+// if the origin is not allowed by the CORS policy
+func (o *grpcWebOptions) allowedOrigin(r *http.Request) (string, bool) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return "", false
+	}
+
+	wildcard := false
+	for _, allowed := range o.origins {
+		if allowed == origin {
+			return origin, true
+		}
+		wildcard = wildcard || allowed == "*"
+	}
+
+	if wildcard {
+		// credentials must never be allowed for any origin
+		return "*", false
+	}
+
+	if len(o.origins) == 0 && isSameOrigin(r, origin) {
+		return origin, true
+	}
+
+	return "", false
+}
+
+// isSameOrigin returns true if the origin is the host the request was sent to
+func isSameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// preflight responds to the CORS preflight, allowing all requested headers
+func (o *grpcWebOptions) preflight(w http.ResponseWriter, r *http.Request, allowedOrigin string, credentials bool) {
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", allowedOrigin)
+	h.Set("Access-Control-Allow-Methods", http.MethodPost+", "+http.MethodOptions)
+	h.Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+	if credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	h.Set("Access-Control-Max-Age", strconv.Itoa(int(o.maxAge.Seconds())))
+	h.Add("Vary", "Origin")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// grpcWebToGRPCRequest rewrites the grpc-web request into a grpc request
+// accepted by the grpc.Server
+func grpcWebToGRPCRequest(r *http.Request) (*http.Request, error) {
+	req := r.Clone(r.Context())
+	req.Proto = "HTTP/2.0"
+	req.ProtoMajor = 2
+	req.ProtoMinor = 0
+
+	contentType := r.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, ContentTypeGRPCWebText) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err = decodeGRPCWebText(body)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+		contentType = strings.TrimPrefix(contentType, ContentTypeGRPCWebText)
+	} else {
+		contentType = strings.TrimPrefix(contentType, ContentTypeGRPCWeb)
+	}
+
+	req.Header.Set("Content-Type", "application/grpc"+contentType)
+	req.Header.Set("Te", "trailers")
+	req.Header.Del("Content-Length")
+
+	return req, nil
+}
+
+// decodeGRPCWebText decodes the base64 body, which may be a concatenation
+// of separately padded chunks
+func decodeGRPCWebText(data []byte) ([]byte, error) {
+	data = bytes.Join(bytes.Fields(data), nil)
+	if len(data)%4 != 0 {
+		return nil, ErrMalformedGRPCWebText
+	}
+
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	n := 0
+	for i := 0; i < len(data); i += 4 {
+		m, err := base64.StdEncoding.Decode(decoded[n:], data[i:i+4])
+		if err != nil {
+			return nil, ErrMalformedGRPCWebText
+		}
+		n += m
+	}
+
+	return decoded[:n], nil
+}
+
+// grpcWebResponseWriter translates the grpc response into a grpc-web response,
+// moving the trailers into the body and base64 encoding the text responses
+type grpcWebResponseWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	contentType string
+	origin      string
+	credentials bool
+	text        bool
+
+	wroteHeader bool
+	trailers    []string
+	buf         bytes.Buffer
+}
+
+func newGRPCWebResponseWriter(w http.ResponseWriter, contentType, origin string, credentials bool) *grpcWebResponseWriter {
+	return &grpcWebResponseWriter{
+		w:           w,
+		header:      http.Header{},
+		contentType: contentType,
+		origin:      origin,
+		credentials: credentials,
+		text:        strings.HasPrefix(contentType, ContentTypeGRPCWebText),
+	}
+}
+
+func (w *grpcWebResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *grpcWebResponseWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.text {
+		// text responses are encoded on flush, so each message is a padded base64 chunk
+		return w.buf.Write(data)
+	}
+
+	return w.w.Write(data)
+}
+
+func (w *grpcWebResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.w.Header()
+	exposed := []string{"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin"}
+	for k, vv := range w.header {
+		switch k {
+		case "Trailer":
+			w.trailers = append(w.trailers, vv...)
+			continue
+		case "Content-Type":
+			continue
+		}
+
+		h[k] = vv
+		if len(vv) > 0 {
+			exposed = append(exposed, k)
+		}
+	}
+	h.Set("Content-Type", w.contentType)
+
+	if w.origin != "" {
+		sort.Strings(exposed[3:])
+		h.Set("Access-Control-Allow-Origin", w.origin)
+		if w.credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		h.Set("Access-Control-Expose-Headers", strings.Join(exposed, ", "))
+		h.Add("Vary", "Origin")
+	}
+
+	w.w.WriteHeader(status)
+}
+
+func (w *grpcWebResponseWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+	if w.text && w.buf.Len() > 0 {
+		_, _ = w.w.Write([]byte(base64.StdEncoding.EncodeToString(w.buf.Bytes())))
+		w.buf.Reset()
+	}
+
+	if f, ok := w.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish writes the trailers of the grpc response as the last frame of the body
+func (w *grpcWebResponseWriter) finish() {
+	trailer := http.Header{}
+	for _, k := range w.trailers {
+		if v, ok := w.header[http.CanonicalHeaderKey(k)]; ok {
+			trailer[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+	for k, vv := range w.header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			trailer[http.CanonicalHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))] = vv
+		}
+	}
+
+	keys := make([]string, 0, len(trailer))
+	for k := range trailer {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sb := strings.Builder{}
+	for _, k := range keys {
+		for _, v := range trailer[k] {
+			sb.WriteString(strings.ToLower(k) + ": " + v + "\r\n")
+		}
+	}
+
+	_, _ = w.Write(frameMessage(grpcWebTrailerFlag, []byte(sb.String())))
+	w.Flush()
+}
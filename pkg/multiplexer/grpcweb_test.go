@@ -0,0 +1,247 @@
+package multiplexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"github.com/petomalina/xrpc/v2/examples/api"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type GRPCWebSuite struct {
+	suite.Suite
+
+	echoService *EchoService
+	grpcServer  *grpc.Server
+	handler     http.Handler
+}
+
+func (s *GRPCWebSuite) SetupTest() {
+	s.echoService = &EchoService{Logger: createLogger()}
+	s.grpcServer = createGrpcServer(s.echoService)
+	s.handler = Make(nil,
+		GRPCHandler(s.grpcServer),
+		NewGRPCWebHandler(s.grpcServer, WithGRPCWebAllowedOrigins("https://app.example.com")),
+	)
+}
+
+func (s *GRPCWebSuite) request(method, contentType string, msgs ...proto.Message) *http.Request {
+	body := []byte{}
+	for _, m := range msgs {
+		data, err := proto.Marshal(m)
+		s.NoError(err)
+		body = append(body, frameMessage(0, data)...)
+	}
+	if contentType == ContentTypeGRPCWebText {
+		body = []byte(base64.StdEncoding.EncodeToString(body))
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost"+method, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Grpc-Web", "1")
+	req.Header.Set("Origin", "https://app.example.com")
+
+	return req
+}
+
+// frames reads the messages and the trailers of the grpc-web response
+func (s *GRPCWebSuite) frames(rec *httptest.ResponseRecorder) ([][]byte, string) {
+	body := rec.Body.Bytes()
+	if rec.Header().Get("Content-Type") == ContentTypeGRPCWebText {
+		var err error
+		body, err = decodeGRPCWebText(body)
+		s.NoError(err)
+	}
+
+	r := bytes.NewReader(body)
+	msgs := [][]byte{}
+	for {
+		flags, data, err := readFrame(r)
+		s.NoError(err)
+
+		if flags&grpcWebTrailerFlag != 0 {
+			_, err := ioutil.ReadAll(r)
+			s.NoError(err)
+			s.Zero(r.Len(), "trailers must be the last frame")
+			return msgs, string(data)
+		}
+		msgs = append(msgs, data)
+	}
+}
+
+func (s *GRPCWebSuite) TestIsGRPCWebRequest() {
+	candidates := map[*http.Request]bool{
+		{
+			Method: http.MethodPost,
+			Header: map[string][]string{
+				"Content-Type": {"application/grpc-web+proto"},
+			},
+		}: true,
+		{
+			Method: http.MethodPost,
+			Header: map[string][]string{
+				"Content-Type": {"application/grpc-web-text"},
+			},
+		}: true,
+		{
+			Method: http.MethodPost,
+			Header: map[string][]string{
+				"Content-Type": {"application/grpc"},
+			},
+		}: false,
+		{
+			Method: http.MethodGet,
+			Header: map[string][]string{
+				"Content-Type": {"application/grpc-web"},
+			},
+		}: false,
+	}
+
+	for req, result := range candidates {
+		s.Equal(result, IsGRPCWebRequest(req), "Request is badly considered a grpc web request", req.Header)
+	}
+
+	// grpc web requests over http2 are not grpc requests
+	s.False(IsGRPCRequest(&http.Request{
+		Method:     http.MethodPost,
+		ProtoMajor: 2,
+		Header: map[string][]string{
+			"Content-Type": {"application/grpc-web"},
+		},
+	}))
+}
+
+func (s *GRPCWebSuite) TestUnary() {
+	s.echoService.onCall = func(ctx context.Context, m *api.EchoMessage) {
+		_ = grpc.SetHeader(ctx, metadata.Pairs("x-echo-header", "header"))
+		_ = grpc.SetTrailer(ctx, metadata.Pairs("x-echo-trailer", "trailer"))
+	}
+
+	for _, contentType := range []string{ContentTypeGRPCWeb, ContentTypeGRPCWeb + "+proto", ContentTypeGRPCWebText} {
+		rec := httptest.NewRecorder()
+		s.handler.ServeHTTP(rec, s.request("/api.EchoService/Call", contentType, &api.EchoMessage{Message: "Hello World"}))
+
+		s.Equal(http.StatusOK, rec.Code, contentType)
+		s.Equal(contentType, rec.Header().Get("Content-Type"))
+		s.Equal("header", rec.Header().Get("X-Echo-Header"))
+		s.Equal("https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+		s.Equal("Grpc-Status, Grpc-Message, Grpc-Status-Details-Bin, X-Echo-Header", rec.Header().Get("Access-Control-Expose-Headers"))
+
+		msgs, trailers := s.frames(rec)
+		s.Len(msgs, 1)
+		res := &api.EchoMessage{}
+		s.NoError(proto.Unmarshal(msgs[0], res))
+		s.Equal("Hello World", res.Message)
+		s.Equal("grpc-status: 0\r\nx-echo-trailer: trailer\r\n", trailers)
+	}
+}
+
+func (s *GRPCWebSuite) TestStatus() {
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, s.request("/api.EchoService/Unknown", ContentTypeGRPCWebText, &api.EchoMessage{}))
+
+	s.Equal(http.StatusOK, rec.Code)
+	msgs, trailers := s.frames(rec)
+	s.Empty(msgs)
+	s.Contains(trailers, "grpc-status: 12\r\n")
+	s.Contains(trailers, "grpc-message: unknown method Unknown")
+
+	req := s.request("/api.EchoService/Call", ContentTypeGRPCWebText)
+	req.Body = ioutil.NopCloser(bytes.NewBufferString("not base64!"))
+	rec = httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	s.Equal(http.StatusBadRequest, rec.Code)
+}
+
+func (s *GRPCWebSuite) TestStreaming() {
+	// the reflection service responds to each of the request messages
+	listServices := &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	}
+
+	for _, contentType := range []string{ContentTypeGRPCWeb, ContentTypeGRPCWebText} {
+		rec := httptest.NewRecorder()
+		s.handler.ServeHTTP(rec, s.request("/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", contentType, listServices, listServices))
+
+		msgs, trailers := s.frames(rec)
+		s.Len(msgs, 2, contentType)
+		for _, data := range msgs {
+			res := &rpb.ServerReflectionResponse{}
+			s.NoError(proto.Unmarshal(data, res))
+			s.NotEmpty(res.GetListServicesResponse().GetService())
+		}
+		s.Equal("grpc-status: 0\r\n", trailers)
+	}
+}
+
+func (s *GRPCWebSuite) TestPreflight() {
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "http://localhost/api.EchoService/Call", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web,x-user-agent")
+
+		rec := httptest.NewRecorder()
+		s.handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := preflight("https://app.example.com")
+	s.Equal(http.StatusNoContent, rec.Code)
+	s.Equal("https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	s.Equal("POST, OPTIONS", rec.Header().Get("Access-Control-Allow-Methods"))
+	s.Equal("content-type,x-grpc-web,x-user-agent", rec.Header().Get("Access-Control-Allow-Headers"))
+	s.Equal("600", rec.Header().Get("Access-Control-Max-Age"))
+	s.Equal("true", rec.Header().Get("Access-Control-Allow-Credentials"))
+
+	s.Equal(http.StatusForbidden, preflight("https://attacker.com").Code)
+
+	req := s.request("/api.EchoService/Call", ContentTypeGRPCWeb, &api.EchoMessage{})
+	req.Header.Set("Origin", "https://attacker.com")
+	rec = httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	s.Equal(http.StatusForbidden, rec.Code)
+}
+
+func (s *GRPCWebSuite) TestCORSPolicy() {
+	serve := func(handler Handler, origin string) *httptest.ResponseRecorder {
+		req := s.request("/api.EchoService/Call", ContentTypeGRPCWeb, &api.EchoMessage{})
+		req.Header.Set("Origin", origin)
+
+		rec := httptest.NewRecorder()
+		Make(nil, handler).ServeHTTP(rec, req)
+		return rec
+	}
+
+	// only same-origin requests are allowed by default
+	handler := NewGRPCWebHandler(s.grpcServer)
+	s.Equal(http.StatusForbidden, serve(handler, "https://app.example.com").Code)
+
+	rec := serve(handler, "http://localhost")
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal("http://localhost", rec.Header().Get("Access-Control-Allow-Origin"))
+
+	// the wildcard allows all origins, but never with credentials
+	handler = NewGRPCWebHandler(s.grpcServer, WithGRPCWebAllowedOrigins("*"))
+	rec = serve(handler, "https://app.example.com")
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal("*", rec.Header().Get("Access-Control-Allow-Origin"))
+	s.Empty(rec.Header().Get("Access-Control-Allow-Credentials"))
+
+	// explicitly allowed origins are allowed with credentials
+	rec = serve(NewGRPCWebHandler(s.grpcServer, WithGRPCWebAllowedOrigins("https://app.example.com")), "https://app.example.com")
+	s.Equal("https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	s.Equal("true", rec.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestGRPCWebSuite(t *testing.T) {
+	suite.Run(t, &GRPCWebSuite{})
+}
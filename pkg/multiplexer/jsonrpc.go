@@ -0,0 +1,364 @@
+package multiplexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	// JSONRPCVersion is the only supported version of the JSON-RPC protocol
+	JSONRPCVersion = "2.0"
+
+	// JSONRPCParseError is returned if the request is not a valid JSON
+	JSONRPCParseError = -32700
+	// JSONRPCInvalidRequest is returned if the request is not a valid JSON-RPC request
+	JSONRPCInvalidRequest = -32600
+	// JSONRPCMethodNotFound is returned for unknown methods and unimplemented grpc methods
+	JSONRPCMethodNotFound = -32601
+	// JSONRPCInvalidParams is returned for malformed params and invalid arguments of grpc methods
+	JSONRPCInvalidParams = -32602
+	// JSONRPCInternalError is returned for internal errors of grpc methods
+	JSONRPCInternalError = -32603
+	// JSONRPCServerError is returned for all other grpc statuses, which are
+	// carried in the data of the error
+	JSONRPCServerError = -32000
+)
+
+// jsonRPCContentTypes are the content types accepted by the JSONRPCHandler
+var jsonRPCContentTypes = map[string]bool{
+	"application/json":        true,
+	"application/json-rpc":    true,
+	"application/jsonrequest": true,
+}
+
+// IsJSONRPCRequest returns true if the request is a POST with a JSON content type.
+// The JSONRPCHandler additionally selects the requests by its path
+func IsJSONRPCRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && jsonRPCContentTypes[requestContentType(r)]
+}
+
+// JSONRPCHandler fulfills JSON-RPC requests sent to /jsonrpc by calling the unary
+// methods registered on the server (see NewJSONRPCHandler)
+func JSONRPCHandler(server ServiceRegistry, selectors ...Selector) Handler {
+	return NewJSONRPCHandler(server, WithJSONRPCSelectors(selectors...))
+}
+
+// JSONRPCOption is an extendable builder for the JSONRPCHandler options
+type JSONRPCOption func(o *jsonRPCOptions)
+
+type jsonRPCOptions struct {
+	selectors    []Selector
+	path         string
+	maxBodySize  int64
+	maxBatchSize int
+}
+
+// WithJSONRPCSelectors adds selectors that must be fulfilled on top of
+// the IsJSONRPCRequest selector and the path
+func WithJSONRPCSelectors(selectors ...Selector) JSONRPCOption {
+	return func(o *jsonRPCOptions) {
+		o.selectors = append(o.selectors, selectors...)
+	}
+}
+
+// WithJSONRPCPath sets the path of the JSON-RPC endpoint, /jsonrpc by default
+func WithJSONRPCPath(path string) JSONRPCOption {
+	return func(o *jsonRPCOptions) {
+		o.path = path
+	}
+}
+
+// WithJSONRPCMaxBodySize rejects requests with bodies larger than the given number
+// of bytes, 4MB by default. Bodies are not limited if the size is not positive
+func WithJSONRPCMaxBodySize(size int64) JSONRPCOption {
+	return func(o *jsonRPCOptions) {
+		o.maxBodySize = size
+	}
+}
+
+// WithJSONRPCMaxBatchSize rejects batches of more than the given number of requests,
+// 100 by default. Batches are not limited if the size is not positive
+func WithJSONRPCMaxBatchSize(size int) JSONRPCOption {
+	return func(o *jsonRPCOptions) {
+		o.maxBatchSize = size
+	}
+}
+
+// NewJSONRPCHandler creates a JSONRPCHandler configured by the given options. Every unary
+// method registered on the server is exposed as the package.Service/Method JSON-RPC method,
+// with the params and the result in their protojson representation. Batch requests and
+// notifications are supported and grpc statuses are mapped into JSON-RPC error objects.
+// All services must be registered on the server before the handler is created
+func NewJSONRPCHandler(server ServiceRegistry, opts ...JSONRPCOption) Handler {
+	options := &jsonRPCOptions{
+		path:         "/jsonrpc",
+		maxBodySize:  4 << 20,
+		maxBatchSize: 100,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	filter := append([]Selector{IsJSONRPCRequest, func(r *http.Request) bool {
+		return r.URL.Path == options.path
+	}}, options.selectors...)
+
+	j := &jsonRPC{
+		conn:         NewLocalConn(server),
+		methods:      map[string]protoreflect.MethodDescriptor{},
+		maxBodySize:  options.maxBodySize,
+		maxBatchSize: options.maxBatchSize,
+	}
+	for _, md := range registeredMethods(server) {
+		if md.IsStreamingClient() || md.IsStreamingServer() {
+			continue
+		}
+		j.methods[strings.TrimPrefix(fullMethodName(md), "/")] = md
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for _, f := range filter {
+			if !f(r) {
+				return false
+			}
+		}
+
+		j.ServeHTTP(w, r)
+		return true
+	}
+}
+
+// jsonRPCRequest is a single JSON-RPC request. Requests without the id member are
+// notifications, while the null id is still answered
+type jsonRPCRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// isNotification returns true if the request does not have the id member. The null
+// id is kept by the json.RawMessage, so it is not a notification
+func (req *jsonRPCRequest) isNotification() bool {
+	return len(req.ID) == 0
+}
+
+// validID returns true if the id is a string, a number or null
+func (req *jsonRPCRequest) validID() bool {
+	if req.isNotification() {
+		return true
+	}
+
+	switch req.ID[0] {
+	case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+
+	return false
+}
+
+// jsonRPCResponse is a single JSON-RPC response with either the result or the error
+type jsonRPCResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonRPCError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// jsonRPCError is the JSON-RPC error object. The data of errors returned by grpc
+// methods is the grpc status
+type jsonRPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// jsonRPC dispatches the JSON-RPC requests onto the grpc methods
+type jsonRPC struct {
+	conn    *LocalConn
+	methods map[string]protoreflect.MethodDescriptor
+
+	maxBodySize  int64
+	maxBatchSize int
+}
+
+func (j *jsonRPC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if j.maxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, j.maxBodySize)
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeJSONRPC(w, newJSONRPCErrorResponse(nil, JSONRPCInvalidRequest, err.Error()))
+		return
+	}
+
+	md, err := requestMetadata(r.Header)
+	if err != nil {
+		writeJSONRPC(w, newJSONRPCErrorResponse(nil, JSONRPCInvalidRequest, err.Error()))
+		return
+	}
+	ctx := metadata.NewOutgoingContext(r.Context(), md)
+
+	body = bytes.TrimSpace(body)
+	if !bytes.HasPrefix(body, []byte("[")) {
+		if res := j.call(ctx, body); res != nil {
+			writeJSONRPC(w, res)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	batch := []json.RawMessage{}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		writeJSONRPC(w, newJSONRPCErrorResponse(nil, JSONRPCParseError, err.Error()))
+		return
+	}
+	if len(batch) == 0 {
+		writeJSONRPC(w, newJSONRPCErrorResponse(nil, JSONRPCInvalidRequest, "jsonrpc: empty batch"))
+		return
+	}
+	if j.maxBatchSize > 0 && len(batch) > j.maxBatchSize {
+		writeJSONRPC(w, newJSONRPCErrorResponse(nil, JSONRPCInvalidRequest, "jsonrpc: batch exceeds the size limit"))
+		return
+	}
+
+	responses := []*jsonRPCResponse{}
+	for _, req := range batch {
+		if res := j.call(ctx, req); res != nil {
+			responses = append(responses, res)
+		}
+	}
+	if len(responses) == 0 {
+		// the batch contained only notifications
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeJSONRPC(w, responses)
+}
+
+// call invokes the method of a single request, returning its response or nil for
+// notifications. Invalid requests are always answered, as their ids cannot be trusted
+func (j *jsonRPC) call(ctx context.Context, data json.RawMessage) *jsonRPCResponse {
+	req := &jsonRPCRequest{}
+	if err := json.Unmarshal(data, req); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return newJSONRPCErrorResponse(nil, JSONRPCParseError, err.Error())
+		}
+		return newJSONRPCErrorResponse(nil, JSONRPCInvalidRequest, err.Error())
+	}
+
+	if !req.validID() {
+		return newJSONRPCErrorResponse(nil, JSONRPCInvalidRequest, "jsonrpc: id must be a string, a number or null")
+	}
+	if req.Version != JSONRPCVersion || req.Method == "" {
+		res := newJSONRPCErrorResponse(nil, JSONRPCInvalidRequest, "jsonrpc: invalid request")
+		if !req.isNotification() {
+			res.ID = req.ID
+		}
+		return res
+	}
+
+	res := j.invoke(ctx, req)
+	if req.isNotification() {
+		return nil
+	}
+	res.ID = req.ID
+
+	return res
+}
+
+func (j *jsonRPC) invoke(ctx context.Context, req *jsonRPCRequest) *jsonRPCResponse {
+	desc, ok := j.methods[req.Method]
+	if !ok {
+		return newJSONRPCErrorResponse(nil, JSONRPCMethodNotFound, "jsonrpc: method "+req.Method+" not found")
+	}
+
+	params := bytes.TrimSpace(req.Params)
+	if bytes.HasPrefix(params, []byte("[")) {
+		// by-position params are accepted if the only param is the message
+		positional := []json.RawMessage{}
+		if err := json.Unmarshal(params, &positional); err != nil || len(positional) != 1 {
+			return newJSONRPCErrorResponse(nil, JSONRPCInvalidParams, "jsonrpc: params must be a single object")
+		}
+		params = positional[0]
+	}
+
+	in := newMessage(desc.Input())
+	if len(params) > 0 && !bytes.Equal(params, []byte("null")) {
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(params, in); err != nil {
+			return newJSONRPCErrorResponse(nil, JSONRPCInvalidParams, err.Error())
+		}
+	}
+
+	out := newMessage(desc.Output())
+	if err := j.conn.Invoke(ctx, fullMethodName(desc), in, out); err != nil {
+		return newJSONRPCStatusResponse(status.Convert(err))
+	}
+
+	result, err := protojson.Marshal(out)
+	if err != nil {
+		return newJSONRPCStatusResponse(status.New(codes.Internal, err.Error()))
+	}
+
+	return &jsonRPCResponse{
+		Version: JSONRPCVersion,
+		Result:  result,
+		ID:      json.RawMessage("null"),
+	}
+}
+
+func newJSONRPCErrorResponse(data json.RawMessage, code int, message string) *jsonRPCResponse {
+	return &jsonRPCResponse{
+		Version: JSONRPCVersion,
+		Error: &jsonRPCError{
+			Code:    code,
+			Message: message,
+			Data:    data,
+		},
+		ID: json.RawMessage("null"),
+	}
+}
+
+// newJSONRPCStatusResponse maps the grpc status into the JSON-RPC error, carrying
+// the status in the data of the error
+func newJSONRPCStatusResponse(st *status.Status) *jsonRPCResponse {
+	code := JSONRPCServerError
+	switch st.Code() {
+	case codes.InvalidArgument:
+		code = JSONRPCInvalidParams
+	case codes.Unimplemented:
+		code = JSONRPCMethodNotFound
+	case codes.Internal:
+		code = JSONRPCInternalError
+	}
+
+	data, err := protojson.Marshal(st.Proto())
+	if err != nil {
+		data = nil
+	}
+
+	return newJSONRPCErrorResponse(data, code, st.Message())
+}
+
+func writeJSONRPC(w http.ResponseWriter, res interface{}) {
+	data, err := json.Marshal(res)
+	if err != nil {
+		data = []byte(`{"jsonrpc":"2.0","error":{"code":-32603,"message":"failed to marshal the response"},"id":null}`)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,200 @@
+package multiplexer
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/grpc-ecosystem/go-grpc-middleware/util/metautils"
+	"github.com/petomalina/xrpc/v2/examples/api"
+	"github.com/stretchr/testify/suite"
+	lpb "google.golang.org/genproto/googleapis/longrunning"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type JSONRPCSuite struct {
+	suite.Suite
+
+	echoService *EchoService
+	handler     http.Handler
+}
+
+func (s *JSONRPCSuite) SetupTest() {
+	s.echoService = &EchoService{Logger: createLogger()}
+	grpcServer := createGrpcServer(s.echoService)
+	lpb.RegisterOperationsServer(grpcServer, &OperationsService{})
+
+	s.handler = Make(nil,
+		GRPCHandler(grpcServer),
+		JSONRPCHandler(grpcServer),
+	)
+}
+
+func (s *JSONRPCSuite) serve(body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/jsonrpc", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Caller", "jsonrpc")
+
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func (s *JSONRPCSuite) TestIsJSONRPCRequest() {
+	request := func(method, contentType string) *http.Request {
+		req := httptest.NewRequest(method, "http://localhost/jsonrpc", nil)
+		req.Header.Set("Content-Type", contentType)
+		return req
+	}
+
+	candidates := map[*http.Request]bool{
+		request(http.MethodPost, "application/json"):                true,
+		request(http.MethodPost, "application/json; charset=utf-8"): true,
+		request(http.MethodPost, "application/json-rpc"):            true,
+		request(http.MethodPost, "application/grpc"):                false,
+		request(http.MethodGet, "application/json"):                 false,
+	}
+
+	for req, result := range candidates {
+		s.Equal(result, IsJSONRPCRequest(req), "Request is badly considered a JSON-RPC request", req.Method, req.Header)
+	}
+}
+
+func (s *JSONRPCSuite) TestCall() {
+	var incoming metautils.NiceMD
+	s.echoService.onCall = func(ctx context.Context, m *api.EchoMessage) {
+		incoming = metautils.ExtractIncoming(ctx)
+	}
+
+	rec := s.serve(`{"jsonrpc":"2.0","method":"api.EchoService/Call","params":{"message":"Hello World"},"id":1}`)
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal("application/json", rec.Header().Get("Content-Type"))
+	s.JSONEq(`{"jsonrpc":"2.0","result":{"message":"Hello World"},"id":1}`, rec.Body.String())
+	s.Equal("jsonrpc", incoming.Get("x-caller"))
+
+	// by-position params are accepted for the single message
+	rec = s.serve(`{"jsonrpc":"2.0","method":"api.EchoService/Call","params":[{"message":"Hi"}],"id":"a"}`)
+	s.JSONEq(`{"jsonrpc":"2.0","result":{"message":"Hi"},"id":"a"}`, rec.Body.String())
+
+	// requests sent to other paths are not fulfilled by the handler
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/rpc", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	s.Equal(http.StatusNotFound, rec.Code)
+}
+
+func (s *JSONRPCSuite) TestNotification() {
+	called := false
+	s.echoService.onCall = func(ctx context.Context, m *api.EchoMessage) {
+		called = true
+	}
+
+	rec := s.serve(`{"jsonrpc":"2.0","method":"api.EchoService/Call","params":{"message":"Hello World"}}`)
+	s.Equal(http.StatusNoContent, rec.Code)
+	s.Empty(rec.Body.String())
+	s.True(called)
+
+	// requests with the null id are not notifications
+	rec = s.serve(`{"jsonrpc":"2.0","method":"api.EchoService/Call","params":{"message":"Hello World"},"id":null}`)
+	s.Equal(http.StatusOK, rec.Code)
+	s.JSONEq(`{"jsonrpc":"2.0","result":{"message":"Hello World"},"id":null}`, rec.Body.String())
+
+	// invalid requests are answered even without the id
+	for _, body := range []string{
+		`{"jsonrpc":"1.0","method":"api.EchoService/Call"}`,
+		`{"jsonrpc":"2.0"}`,
+		`{"jsonrpc":"2.0","method":"api.EchoService/Call","id":{"key":1}}`,
+	} {
+		rec = s.serve(body)
+		s.Equal(http.StatusOK, rec.Code, body)
+
+		res := &jsonRPCResponse{}
+		s.NoError(json.Unmarshal(rec.Body.Bytes(), res), body)
+		s.Equal(JSONRPCInvalidRequest, res.Error.Code, body)
+		s.Equal("null", string(res.ID), body)
+	}
+}
+
+func (s *JSONRPCSuite) TestBatch() {
+	rec := s.serve(`[
+		{"jsonrpc":"2.0","method":"api.EchoService/Call","params":{"message":"first"},"id":1},
+		{"jsonrpc":"2.0","method":"api.EchoService/Call","params":{"message":"notification"}},
+		{"jsonrpc":"2.0","method":"api.EchoService/Unknown","id":2},
+		{"jsonrpc":"2.0","method":"api.EchoService/Unknown"},
+		{"method":"api.EchoService/Call"},
+		1
+	]`)
+	s.Equal(http.StatusOK, rec.Code)
+	s.JSONEq(`[
+		{"jsonrpc":"2.0","result":{"message":"first"},"id":1},
+		{"jsonrpc":"2.0","error":{"code":-32601,"message":"jsonrpc: method api.EchoService/Unknown not found"},"id":2},
+		{"jsonrpc":"2.0","error":{"code":-32600,"message":"jsonrpc: invalid request"},"id":null},
+		{"jsonrpc":"2.0","error":{"code":-32600,"message":"json: cannot unmarshal number into Go value of type multiplexer.jsonRPCRequest"},"id":null}
+	]`, rec.Body.String())
+
+	rec = s.serve(`[{"jsonrpc":"2.0","method":"api.EchoService/Call","params":{}}]`)
+	s.Equal(http.StatusNoContent, rec.Code)
+
+	rec = s.serve(`[]`)
+	s.Contains(rec.Body.String(), `"code":-32600`)
+}
+
+func (s *JSONRPCSuite) TestErrors() {
+	candidates := map[string]struct {
+		body string
+		code int
+	}{
+		"parse":            {body: `{"jsonrpc":"2.0",`, code: JSONRPCParseError},
+		"version":          {body: `{"jsonrpc":"1.0","method":"api.EchoService/Call","id":1}`, code: JSONRPCInvalidRequest},
+		"unknown method":   {body: `{"jsonrpc":"2.0","method":"api.EchoService/Unknown","id":1}`, code: JSONRPCMethodNotFound},
+		"streaming method": {body: `{"jsonrpc":"2.0","method":"grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo","id":1}`, code: JSONRPCMethodNotFound},
+		"invalid params":   {body: `{"jsonrpc":"2.0","method":"api.EchoService/Call","params":{"message":1},"id":1}`, code: JSONRPCInvalidParams},
+		"too many params":  {body: `{"jsonrpc":"2.0","method":"api.EchoService/Call","params":[{},{}],"id":1}`, code: JSONRPCInvalidParams},
+		"unimplemented":    {body: `{"jsonrpc":"2.0","method":"google.longrunning.Operations/WaitOperation","id":1}`, code: JSONRPCMethodNotFound},
+		"status":           {body: `{"jsonrpc":"2.0","method":"google.longrunning.Operations/GetOperation","params":{"name":"operations/missing"},"id":1}`, code: JSONRPCServerError},
+	}
+
+	for name, c := range candidates {
+		rec := s.serve(c.body)
+		s.Equal(http.StatusOK, rec.Code, name)
+
+		res := &jsonRPCResponse{}
+		s.NoError(json.Unmarshal(rec.Body.Bytes(), res), name)
+		s.Nil(res.Result, name)
+		if s.NotNil(res.Error, name) {
+			s.Equal(c.code, res.Error.Code, name)
+		}
+	}
+
+	// the grpc status is carried in the data of the error
+	rec := s.serve(`{"jsonrpc":"2.0","method":"google.longrunning.Operations/GetOperation","params":{"name":"operations/missing"},"id":1}`)
+	s.JSONEq(`{"jsonrpc":"2.0","error":{"code":-32000,"message":"operation not found","data":{"code":5,"message":"operation not found"}},"id":1}`, rec.Body.String())
+}
+
+func (s *JSONRPCSuite) TestLimits() {
+	s.handler = Make(nil, NewJSONRPCHandler(createGrpcServer(s.echoService),
+		WithJSONRPCMaxBodySize(256),
+		WithJSONRPCMaxBatchSize(2),
+	))
+
+	call := `{"jsonrpc":"2.0","method":"api.EchoService/Call","params":{"message":"Hi"},"id":1}`
+	rec := s.serve("[" + call + "," + call + "]")
+	s.JSONEq(`[
+		{"jsonrpc":"2.0","result":{"message":"Hi"},"id":1},
+		{"jsonrpc":"2.0","result":{"message":"Hi"},"id":1}
+	]`, rec.Body.String())
+
+	rec = s.serve("[" + call + "," + call + "," + call + "]")
+	s.JSONEq(`{"jsonrpc":"2.0","error":{"code":-32600,"message":"jsonrpc: batch exceeds the size limit"},"id":null}`, rec.Body.String())
+
+	rec = s.serve(`{"jsonrpc":"2.0","method":"api.EchoService/Call","params":{"message":"` + strings.Repeat("a", 256) + `"},"id":1}`)
+	s.Contains(rec.Body.String(), `"code":-32600`)
+	s.NotContains(rec.Body.String(), `"result"`)
+}
+
+func TestJSONRPCSuite(t *testing.T) {
+	suite.Run(t, &JSONRPCSuite{})
+}
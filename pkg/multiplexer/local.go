@@ -0,0 +1,422 @@
+package multiplexer
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrCompressedMessage is returned if the server responds with a compressed
+	// message, which is not supported by the LocalConn
+	ErrCompressedMessage = errors.New("local: compressed messages are not supported")
+)
+
+// LocalConn is a grpc.ClientConnInterface that calls methods of a grpc server
+// in-process. Calls are driven through the http.Handler implementation of the
+// server (grpc.Server.ServeHTTP), so interceptors, metadata and statuses behave
+// the same way as for calls made over the network, without dialing the server
+type LocalConn struct {
+	server http.Handler
+	codec  encoding.Codec
+}
+
+// NewLocalConn creates a LocalConn for the given server, which is usually
+// a *grpc.Server
+func NewLocalConn(server http.Handler) *LocalConn {
+	return &LocalConn{
+		server: server,
+		codec:  encoding.GetCodec("proto"),
+	}
+}
+
+// Invoke performs a unary call on the server
+func (c *LocalConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...grpc.CallOption) error {
+	cs, err := c.NewStream(ctx, &grpc.StreamDesc{}, method, opts...)
+	if err != nil {
+		return err
+	}
+
+	if err := cs.SendMsg(args); err != nil && err != io.EOF {
+		return err
+	}
+	if err := cs.CloseSend(); err != nil {
+		return err
+	}
+
+	if err := cs.RecvMsg(reply); err != nil {
+		return err
+	}
+
+	return cs.(*localStream).finish()
+}
+
+// NewStream starts a new stream call on the server
+func (c *LocalConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	reqReader, reqWriter := io.Pipe()
+	resReader, resWriter := io.Pipe()
+
+	// the outgoing metadata of the caller is passed by the headers, and it must not be
+	// forwarded by the downstream calls the server makes with the request context
+	req, err := http.NewRequestWithContext(metadata.NewOutgoingContext(ctx, nil), http.MethodPost, "/", reqReader)
+	if err != nil {
+		cancel()
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	req.URL = &url.URL{Path: method}
+	req.RequestURI = method
+	req.Proto = "HTTP/2.0"
+	req.ProtoMajor = 2
+	req.ProtoMinor = 0
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Set("Te", "trailers")
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	for k, vv := range md {
+		for _, v := range vv {
+			if strings.HasSuffix(k, "-bin") {
+				v = base64.RawStdEncoding.EncodeToString([]byte(v))
+			}
+			req.Header.Add(k, v)
+		}
+	}
+
+	cs := &localStream{
+		ctx:       ctx,
+		cancel:    cancel,
+		desc:      desc,
+		codec:     c.codec,
+		opts:      opts,
+		reqWriter: reqWriter,
+		resReader: resReader,
+		rw: &localResponseWriter{
+			header:        http.Header{},
+			body:          resWriter,
+			headerWritten: make(chan struct{}),
+		},
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(cs.done)
+		defer cs.rw.writeHeaderOnce()
+		defer resWriter.Close()
+		// unblock pending sends after the server stops reading the request
+		defer reqReader.CloseWithError(io.EOF)
+
+		c.server.ServeHTTP(cs.rw, req)
+	}()
+
+	return cs, nil
+}
+
+// localResponseWriter collects the response of the server, streaming
+// the body into a pipe read by the localStream
+type localResponseWriter struct {
+	header http.Header
+	body   *io.PipeWriter
+
+	once          sync.Once
+	headerWritten chan struct{}
+	sentHeader    http.Header
+}
+
+func (w *localResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *localResponseWriter) Write(data []byte) (int, error) {
+	w.writeHeaderOnce()
+	return w.body.Write(data)
+}
+
+func (w *localResponseWriter) WriteHeader(int) {
+	w.writeHeaderOnce()
+}
+
+func (w *localResponseWriter) Flush() {
+	w.writeHeaderOnce()
+}
+
+// writeHeaderOnce snapshots the headers sent by the server before they
+// are mutated by trailers
+func (w *localResponseWriter) writeHeaderOnce() {
+	w.once.Do(func() {
+		w.sentHeader = w.header.Clone()
+		close(w.headerWritten)
+	})
+}
+
+// localStream is the grpc.ClientStream of the LocalConn
+type localStream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	desc   *grpc.StreamDesc
+	codec  encoding.Codec
+	opts   []grpc.CallOption
+
+	reqWriter *io.PipeWriter
+	resReader *io.PipeReader
+	rw        *localResponseWriter
+	done      chan struct{}
+
+	// received is set once a message was delivered to the client
+	received bool
+}
+
+func (s *localStream) Header() (metadata.MD, error) {
+	select {
+	case <-s.rw.headerWritten:
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+
+	return headerToMetadata(s.rw.sentHeader), nil
+}
+
+func (s *localStream) Trailer() metadata.MD {
+	select {
+	case <-s.done:
+	default:
+		return nil
+	}
+
+	trailer := http.Header{}
+	for k, vv := range s.rw.header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			trailer[strings.TrimPrefix(k, http.TrailerPrefix)] = vv
+		}
+	}
+
+	return headerToMetadata(trailer)
+}
+
+func (s *localStream) CloseSend() error {
+	return s.reqWriter.Close()
+}
+
+func (s *localStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *localStream) SendMsg(m interface{}) error {
+	data, err := s.codec.Marshal(m)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to marshal the message: %v", err)
+	}
+
+	if _, err := s.reqWriter.Write(frameMessage(0, data)); err != nil {
+		// the server stopped reading the stream, status is returned by RecvMsg
+		return io.EOF
+	}
+
+	return nil
+}
+
+func (s *localStream) RecvMsg(m interface{}) error {
+	flags, data, err := readFrame(s.resReader)
+	if err == io.EOF {
+		if err := s.finish(); err != nil {
+			return err
+		}
+		if !s.desc.ServerStreams && !s.received {
+			// a unary response must contain exactly one message
+			return status.Error(codes.Internal, "local: server returned no message")
+		}
+		return io.EOF
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if flags&1 != 0 {
+		return status.Error(codes.Internal, ErrCompressedMessage.Error())
+	}
+
+	if err := s.codec.Unmarshal(data, m); err != nil {
+		return status.Errorf(codes.Internal, "failed to unmarshal the message: %v", err)
+	}
+	s.received = true
+
+	return nil
+}
+
+// finish waits for the server to complete the call and returns its status
+func (s *localStream) finish() error {
+	// drain messages that were not read by the client
+	_, _ = io.Copy(ioutil.Discard, s.resReader)
+	<-s.done
+	defer s.cancel()
+
+	for _, opt := range s.opts {
+		switch o := opt.(type) {
+		case grpc.HeaderCallOption:
+			*o.HeaderAddr = headerToMetadata(s.rw.sentHeader)
+		case grpc.TrailerCallOption:
+			*o.TrailerAddr = s.Trailer()
+		}
+	}
+
+	return statusFromHeader(s.rw.header).Err()
+}
+
+// statusFromHeader reads the grpc status from the response trailers
+func statusFromHeader(h http.Header) *status.Status {
+	code := trailerValue(h, "Grpc-Status")
+	if code == "" {
+		return status.New(codes.Internal, "local: server did not return a status")
+	}
+
+	if details := trailerValue(h, "Grpc-Status-Details-Bin"); details != "" {
+		if data, err := decodeBinaryHeader(details); err == nil {
+			st := &spb.Status{}
+			if err := proto.Unmarshal(data, st); err == nil {
+				return status.FromProto(st)
+			}
+		}
+	}
+
+	c, err := strconv.Atoi(code)
+	if err != nil {
+		return status.Newf(codes.Internal, "local: malformed status %q", code)
+	}
+
+	msg, err := url.PathUnescape(trailerValue(h, "Grpc-Message"))
+	if err != nil {
+		msg = trailerValue(h, "Grpc-Message")
+	}
+
+	return status.New(codes.Code(c), msg)
+}
+
+// trailerValue returns the value of a trailer, which is either a declared
+// trailer or an undeclared one prefixed by http.TrailerPrefix
+func trailerValue(h http.Header, key string) string {
+	if v := h.Get(key); v != "" {
+		return v
+	}
+
+	return h.Get(http.TrailerPrefix + key)
+}
+
+// headerToMetadata converts the response headers into grpc metadata,
+// skipping the transport headers
+func headerToMetadata(h http.Header) metadata.MD {
+	md := metadata.MD{}
+	for k, vv := range h {
+		k = strings.ToLower(k)
+		switch k {
+		case "content-type", "trailer", "grpc-status", "grpc-message", "grpc-status-details-bin", "grpc-encoding":
+			continue
+		}
+
+		for _, v := range vv {
+			if strings.HasSuffix(k, "-bin") {
+				if data, err := decodeBinaryHeader(v); err == nil {
+					v = string(data)
+				}
+			}
+			md.Append(k, v)
+		}
+	}
+
+	return md
+}
+
+// reservedRequestHeaders are the transport headers of the requests, which are not
+// passed as the grpc metadata
+var reservedRequestHeaders = map[string]bool{
+	"Accept-Encoding":  true,
+	"Connection":       true,
+	"Content-Encoding": true,
+	"Content-Length":   true,
+	"Content-Type":     true,
+	"Host":             true,
+	"Te":               true,
+	"User-Agent":       true,
+}
+
+// requestMetadata converts the request headers into grpc metadata, skipping the
+// transport headers and the protocol headers of the given prefixes
+func requestMetadata(h http.Header, skipPrefixes ...string) (metadata.MD, error) {
+	md := metadata.MD{}
+
+headers:
+	for k, vv := range h {
+		if reservedRequestHeaders[k] {
+			continue
+		}
+		for _, prefix := range skipPrefixes {
+			if strings.HasPrefix(k, prefix) {
+				continue headers
+			}
+		}
+
+		for _, v := range vv {
+			if strings.HasSuffix(k, "-Bin") {
+				data, err := decodeBinaryHeader(v)
+				if err != nil {
+					return nil, err
+				}
+				v = string(data)
+			}
+			md.Append(k, v)
+		}
+	}
+
+	return md, nil
+}
+
+func decodeBinaryHeader(v string) ([]byte, error) {
+	if len(v)%4 == 0 {
+		return base64.StdEncoding.DecodeString(v)
+	}
+
+	return base64.RawStdEncoding.DecodeString(v)
+}
+
+// frameMessage prefixes the message with the grpc length-prefixed
+// message header (1 byte of flags and 4 bytes of length)
+func frameMessage(flags byte, data []byte) []byte {
+	frame := make([]byte, 5+len(data))
+	frame[0] = flags
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(data)))
+	copy(frame[5:], data)
+
+	return frame
+}
+
+// readFrame reads a single length-prefixed message from the reader
+func readFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, fmt.Errorf("truncated message header: %w", err)
+		}
+		return 0, nil, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint32(header[1:5]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, fmt.Errorf("truncated message: %w", err)
+	}
+
+	return header[0], data, nil
+}
@@ -0,0 +1,155 @@
+package multiplexer
+
+import (
+	"context"
+	"github.com/grpc-ecosystem/go-grpc-middleware/util/metautils"
+	"github.com/petomalina/xrpc/v2/examples/api"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	tpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"io"
+	"testing"
+)
+
+// StreamingService implements the client-streaming method of the grpc testing service,
+// aggregating the sizes of the received payloads
+type StreamingService struct {
+	// noResponse makes the method finish successfully without a response
+	noResponse bool
+
+	tpb.UnimplementedTestServiceServer
+}
+
+func (s *StreamingService) StreamingInputCall(stream tpb.TestService_StreamingInputCallServer) error {
+	size := 0
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF && s.noResponse {
+			return nil
+		}
+		if err == io.EOF {
+			return stream.SendAndClose(&tpb.StreamingInputCallResponse{AggregatedPayloadSize: int32(size)})
+		}
+		if err != nil {
+			return err
+		}
+
+		size += len(req.GetPayload().GetBody())
+	}
+}
+
+type LocalConnSuite struct {
+	suite.Suite
+
+	echoService      *EchoService
+	streamingService *StreamingService
+	conn             *LocalConn
+}
+
+func (s *LocalConnSuite) SetupTest() {
+	s.echoService = &EchoService{Logger: createLogger()}
+
+	grpcServer := createGrpcServer(s.echoService)
+	s.streamingService = &StreamingService{}
+	tpb.RegisterTestServiceServer(grpcServer, s.streamingService)
+	s.conn = NewLocalConn(grpcServer)
+}
+
+func (s *LocalConnSuite) TestInvoke() {
+	var incoming metautils.NiceMD
+	var outgoing metadata.MD
+	s.echoService.onCall = func(ctx context.Context, m *api.EchoMessage) {
+		incoming = metautils.ExtractIncoming(ctx)
+		outgoing, _ = metadata.FromOutgoingContext(ctx)
+		_ = grpc.SetHeader(ctx, metadata.Pairs("x-echo-header", "header"))
+		_ = grpc.SetTrailer(ctx, metadata.Pairs("x-echo-trailer", "trailer"))
+	}
+
+	client := api.NewEchoServiceClient(s.conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-caller", "local", "x-caller-bin", "\x00\x01")
+
+	var header, trailer metadata.MD
+	res, err := client.Call(ctx, &api.EchoMessage{Message: "Hey There!"}, grpc.Header(&header), grpc.Trailer(&trailer))
+	s.NoError(err)
+	s.Equal("Hey There!", res.Message)
+
+	s.Equal("local", incoming.Get("x-caller"))
+	s.Equal("\x00\x01", incoming.Get("x-caller-bin"))
+	s.Empty(outgoing, "the outgoing metadata of the caller must not reach the server")
+	s.Equal([]string{"header"}, header.Get("x-echo-header"))
+	s.Equal([]string{"trailer"}, trailer.Get("x-echo-trailer"))
+}
+
+func (s *LocalConnSuite) TestInvokeStatus() {
+	err := s.conn.Invoke(context.Background(), "/api.EchoService/Unknown", &api.EchoMessage{}, &api.EchoMessage{})
+	s.Equal(codes.Unimplemented, status.Code(err))
+
+	s.echoService.onCall = func(ctx context.Context, m *api.EchoMessage) {
+		panic("the call must be cancelled before reaching the service")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = s.conn.Invoke(ctx, "/api.EchoService/Call", &api.EchoMessage{}, &api.EchoMessage{})
+	s.Error(err)
+}
+
+func (s *LocalConnSuite) TestStream() {
+	// the reflection service is a bidirectional stream registered on the testing server
+	client := rpb.NewServerReflectionClient(s.conn)
+
+	stream, err := client.ServerReflectionInfo(context.Background())
+	s.NoError(err)
+
+	for i := 0; i < 2; i++ {
+		s.NoError(stream.Send(&rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+		}))
+
+		res, err := stream.Recv()
+		s.NoError(err)
+
+		services := []string{}
+		for _, svc := range res.GetListServicesResponse().GetService() {
+			services = append(services, svc.Name)
+		}
+		s.Contains(services, "api.EchoService")
+	}
+
+	s.NoError(stream.CloseSend())
+	_, err = stream.Recv()
+	s.Equal(io.EOF, err)
+}
+
+func (s *LocalConnSuite) TestClientStream() {
+	desc := &tpb.TestService_ServiceDesc.Streams[1]
+	stream, err := s.conn.NewStream(context.Background(), desc, "/grpc.testing.TestService/StreamingInputCall")
+	s.NoError(err)
+
+	for _, body := range []string{"Hello", "World!"} {
+		s.NoError(stream.SendMsg(&tpb.StreamingInputCallRequest{Payload: &tpb.Payload{Body: []byte(body)}}))
+	}
+	s.NoError(stream.CloseSend())
+
+	res := &tpb.StreamingInputCallResponse{}
+	s.NoError(stream.RecvMsg(res))
+	s.Equal(int32(11), res.AggregatedPayloadSize)
+
+	// the stream ends by io.EOF once the single response was received
+	s.Equal(io.EOF, stream.RecvMsg(&tpb.StreamingInputCallResponse{}))
+
+	// a successful call without any response message is still an error
+	s.streamingService.noResponse = true
+	stream, err = s.conn.NewStream(context.Background(), desc, "/grpc.testing.TestService/StreamingInputCall")
+	s.NoError(err)
+	s.NoError(stream.CloseSend())
+	s.Equal(codes.Internal, status.Code(stream.RecvMsg(res)))
+}
+
+func TestLocalConnSuite(t *testing.T) {
+	suite.Run(t, &LocalConnSuite{})
+}
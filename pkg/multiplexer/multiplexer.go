@@ -14,6 +14,12 @@ var (
 	ErrNoHandlerFulfilled = errors.New("no handler was fulfilled for your request")
 )
 
+const (
+	// gatewayMetadataPrefix is the header prefix recognized by the grpc-gateway. The prefix
+	// is stripped away by the gateway and the rest of the header is passed as grpc metadata
+	gatewayMetadataPrefix = "Grpc-Metadata-"
+)
+
 // HandlerFactory is a function that implements wrapping a given http handler
 // into a specific Handler
 type HandlerFactory func(server http.Handler, selectors ...Selector) Handler
@@ -39,6 +45,26 @@ func OrSelector(selectors ...Selector) Selector {
 	}
 }
 
+// AndSelector returns true if all of the selectors are fulfilled
+func AndSelector(selectors ...Selector) Selector {
+	return func(r *http.Request) bool {
+		for _, s := range selectors {
+			if !s(r) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// NotSelector returns true if the selector is not fulfilled
+func NotSelector(selector Selector) Selector {
+	return func(r *http.Request) bool {
+		return !selector(r)
+	}
+}
+
 // Make creates a new multiplexer with given handlers. It combines all handlers
 // to create a new h2c handler. If the server is not provided, a default http2 server
 // will be created instead.
@@ -60,6 +60,32 @@ func (s *MultiplexerSuite) TestOrSelector() {
 	}
 }
 
+func (s *MultiplexerSuite) TestAndSelector() {
+	grpcRequest := &http.Request{
+		ProtoMajor: 2,
+		Method:     http.MethodPost,
+		Header: map[string][]string{
+			"Content-Type": {"application/grpc"},
+		},
+	}
+
+	s.True(AndSelector(IsGRPCRequest, Method(http.MethodPost))(grpcRequest))
+	s.False(AndSelector(IsGRPCRequest, IsPubSubRequest)(grpcRequest))
+	s.True(AndSelector()(grpcRequest), "no selectors are always fulfilled")
+}
+
+func (s *MultiplexerSuite) TestNotSelector() {
+	grpcRequest := &http.Request{
+		ProtoMajor: 2,
+		Header: map[string][]string{
+			"Content-Type": {"application/grpc"},
+		},
+	}
+
+	s.False(NotSelector(IsGRPCRequest)(grpcRequest))
+	s.True(NotSelector(IsPubSubRequest)(grpcRequest))
+}
+
 func TestMultiplexerSuite(t *testing.T) {
 	suite.Run(t, &MultiplexerSuite{})
 }
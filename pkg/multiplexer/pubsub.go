@@ -3,21 +3,107 @@ package multiplexer
 import (
 	"bytes"
 	"encoding/json"
+	"google.golang.org/protobuf/proto"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // IsPubSubRequest returns true if the given request is considered
-// to be made by Google servers and thus pushed by Pub/Sub service
+// to be made by Google servers and thus pushed by Pub/Sub service.
+// CloudEvents delivered by Eventarc and Cloud Tasks requests are excluded
+// (see IsCloudEventsRequest and IsCloudTasksRequest)
 func IsPubSubRequest(r *http.Request) bool {
-	return strings.Contains(r.Header.Get("user-agent"), "APIs-Google") && r.Method == http.MethodPost
+	return strings.Contains(r.Header.Get("user-agent"), "APIs-Google") && r.Method == http.MethodPost &&
+		!IsCloudEventsRequest(r) && !IsCloudTasksRequest(r)
 }
 
 // PubSubHandler fulfills requests that are considered to be PubSub requests,
 // automatically unwrapping their bodies and appending metadata as headers
 func PubSubHandler(handler http.Handler, selectors ...Selector) Handler {
-	filter := append([]Selector{IsPubSubRequest}, selectors...)
+	return NewPubSubHandler(handler, WithPubSubSelectors(selectors...))
+}
+
+// PubSubOption is an extendable builder for the PubSubHandler options
+type PubSubOption func(o *pubSubOptions)
+
+type pubSubOptions struct {
+	selectors    []Selector
+	verifier     *PushTokenVerifier
+	encodings    map[string]PubSubEncoding
+	messageTypes map[string]proto.Message
+
+	ackPolicy               PubSubAckPolicy
+	subscriptionAckPolicies map[string]PubSubAckPolicy
+
+	dedupStore     DedupStore
+	dedupTTL       time.Duration
+	dedupAttribute string
+
+	batchWorkers int
+
+	ordering *OrderingQueue
+
+	maxDeliveryAttempts int
+	deadLetter          http.Handler
+
+	maxBodySize          int64
+	requiredFields       []PubSubMetaAttribute
+	allowedSubscriptions []string
+}
+
+// WithPubSubSelectors adds selectors that must be fulfilled on top of
+// the IsPubSubRequest selector
+func WithPubSubSelectors(selectors ...Selector) PubSubOption {
+	return func(o *pubSubOptions) {
+		o.selectors = append(o.selectors, selectors...)
+	}
+}
+
+// WithPubSubTokenVerifier enables verification of the OIDC token attached
+// by Pub/Sub to authenticated push subscriptions. Pushes that fail the
+// verification are rejected with 401 Unauthorized
+func WithPubSubTokenVerifier(verifier *PushTokenVerifier) PubSubOption {
+	return func(o *pubSubOptions) {
+		o.verifier = verifier
+	}
+}
+
+// WithPubSubEncoding sets the encoding of the message data for pushes of the given
+// subscription. The encoding is used if the message does not declare it in its
+// attributes (see PubSubEncodingOf)
+func WithPubSubEncoding(subscription string, encoding PubSubEncoding) PubSubOption {
+	return func(o *pubSubOptions) {
+		if o.encodings == nil {
+			o.encodings = map[string]PubSubEncoding{}
+		}
+		o.encodings[subscription] = encoding
+	}
+}
+
+// WithPubSubMessageType sets the protobuf type of the message data for pushes of the
+// given subscription. Binary encoded data of such pushes are transcoded into JSON, which
+// is expected by the grpc-gateway. Binary data of subscriptions without a message type
+// are passed through with the ContentTypeProtobuf content type (see PubSubRouter)
+func WithPubSubMessageType(subscription string, msg proto.Message) PubSubOption {
+	return func(o *pubSubOptions) {
+		if o.messageTypes == nil {
+			o.messageTypes = map[string]proto.Message{}
+		}
+		o.messageTypes[subscription] = msg
+	}
+}
+
+// NewPubSubHandler creates a PubSubHandler configured by the given options
+func NewPubSubHandler(handler http.Handler, opts ...PubSubOption) Handler {
+	options := &pubSubOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	filter := append([]Selector{IsPubSubRequest}, options.selectors...)
 
 	return func(w http.ResponseWriter, r *http.Request) bool {
 		for _, f := range filter {
@@ -26,22 +112,96 @@ func PubSubHandler(handler http.Handler, selectors ...Selector) Handler {
 			}
 		}
 
-		req, err := InterceptPubSubRequest(r)
+		if options.verifier != nil {
+			if _, err := options.verifier.Verify(r); err != nil {
+				writePubSubError(w, http.StatusUnauthorized, err)
+				return true
+			}
+		}
+
+		options.limitBody(r)
+
+		if options.batchWorkers > 0 && isPubSubBatch(r) {
+			options.serveBatch(handler, w, r)
+			return true
+		}
+
+		psmsg, err := decodePushMessage(r)
 		if err != nil {
-			_, _ = w.Write([]byte(err.Error()))
-			w.WriteHeader(http.StatusBadRequest)
+			writePubSubError(w, http.StatusBadRequest, err)
 			return true
 		}
 
-		handler.ServeHTTP(w, req)
+		options.handle(handler, w, r, psmsg)
 		return true
 	}
 }
 
+// handle unwraps the decoded push message into the request and serves it
+func (o *pubSubOptions) handle(handler http.Handler, w http.ResponseWriter, r *http.Request, psmsg *PushMessage) {
+	if err := o.validate(psmsg); err != nil {
+		writePubSubError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if o.exceedsDeliveryAttempts(psmsg) {
+		// poison messages are diverted before transcoding, as it might be the cause of their failures
+		o.deadLetter.ServeHTTP(w, applyPushMessage(r, psmsg))
+		return
+	}
+
+	if err := o.transcode(r, psmsg); err != nil {
+		writePubSubError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	o.serve(handler, w, applyPushMessage(r, psmsg), psmsg)
+}
+
+// serve passes the unwrapped push to the handler
+func (o *pubSubOptions) serve(handler http.Handler, w http.ResponseWriter, r *http.Request, psmsg *PushMessage) {
+	release, err := o.acquireOrdering(r, psmsg)
+	if err != nil {
+		// the push was cancelled while waiting, so it will be redelivered
+		writePubSubError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer release()
+
+	key := o.dedupKey(psmsg)
+	switch o.reserve(r, key) {
+	case DedupCommitted:
+		// duplicates are acknowledged without reaching the handler
+		w.WriteHeader(http.StatusOK)
+		return
+	case DedupInFlight:
+		// the first push may still fail, so the duplicate must be redelivered
+		writePubSubError(w, http.StatusConflict, ErrPubSubInFlight)
+		return
+	}
+
+	if !o.hasAckPolicy() && key == "" {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	rec := newResponseRecorder()
+	handler.ServeHTTP(rec, r)
+
+	if o.hasAckPolicy() {
+		o.acknowledge(rec, psmsg.Subscription)
+	}
+	o.settle(r, key, rec.status >= 200 && rec.status <= 299)
+
+	rec.writeTo(w)
+}
+
 // PushMessage is a definition of the Google PubSub message received as a push message
 type PushMessage struct {
 	Message      *PubSubMessage `json:"message,omitempty"`
 	Subscription string         `json:"subscription,omitempty"`
+	// DeliveryAttempt is only sent for subscriptions with a dead letter policy
+	DeliveryAttempt int `json:"deliveryAttempt,omitempty"`
 }
 
 // PubSubMessage is a definition of the internal PubSub message received on PubSub push
@@ -79,6 +239,12 @@ const (
 	PubSubMetaMessageID = "message-id"
 	// PubSubMetaPublishTime is the meta attribute in the 'message.publishTime' path
 	PubSubMetaPublishTime = "publish-time"
+	// PubSubMetaOrderingKey is the meta attribute in the 'message.orderingKey' path. It is
+	// only sent for subscriptions with message ordering enabled
+	PubSubMetaOrderingKey = "ordering-key"
+	// PubSubMetaDeliveryAttempt is the meta attribute 'deliveryAttempt' in the message root.
+	// It is only sent for subscriptions with a dead letter policy
+	PubSubMetaDeliveryAttempt = "delivery-attempt"
 )
 
 // PubSubQueryParam are query parameters known to be sent by the Pub/Sub push messages
@@ -115,7 +281,19 @@ func PubSubQueryHeader(param PubSubQueryParam) string {
 // with the PubSub data, and corrects the Content-Length header
 func InterceptPubSubRequest(r *http.Request) (*http.Request, error) {
 	// handle Google APIs pushed events (PubSub)
+	psmsg, err := decodePushMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	if psmsg.Message == nil {
+		return nil, ErrPubSubMissingMessage
+	}
+
+	return applyPushMessage(r, psmsg), nil
+}
 
+// decodePushMessage reads the body of the http request and unmarshals the push message
+func decodePushMessage(r *http.Request) (*PushMessage, error) {
 	// read the contents of the http request (this will be replaced later)
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -123,36 +301,44 @@ func InterceptPubSubRequest(r *http.Request) (*http.Request, error) {
 	}
 
 	// unmarshal to the pubsub message
-	psmsg := PushMessage{}
-	err = json.Unmarshal(body, &psmsg)
+	psmsg := &PushMessage{}
+	err = json.Unmarshal(body, psmsg)
 	if err != nil {
 		return nil, err
 	}
 
+	return psmsg, nil
+}
+
+// applyPushMessage replaces the body of the http request with the message data and
+// adds all PubSub metadata into headers
+func applyPushMessage(r *http.Request, psmsg *PushMessage) *http.Request {
 	// get the body from the pubsub and re-create it
 	psbody := psmsg.Message.Data
 	r.Body = ioutil.NopCloser(bytes.NewBuffer(psbody))
 	r.ContentLength = int64(len(psbody))
 
-	// headerPrefix is set to this prefix for GRPC gateway. The prefix is special and is stripped away
-	// by the grpc gateway
-	var headerPrefix = "Grpc-Metadata-"
-
 	// the Grpc-Metadata- prefix is stripped by the grpc-gateway, so these headers
 	// are accessible by their original names
-	r.Header.Add(headerPrefix+PubSubMetaAttributeHeader(PubSubMetaSubscription), psmsg.Subscription)
-	r.Header.Add(headerPrefix+PubSubMetaAttributeHeader(PubSubMetaMessageID), psmsg.Message.MessageID)
-	r.Header.Add(headerPrefix+PubSubMetaAttributeHeader(PubSubMetaPublishTime), psmsg.Message.PublishTime)
+	r.Header.Add(gatewayMetadataPrefix+PubSubMetaAttributeHeader(PubSubMetaSubscription), psmsg.Subscription)
+	r.Header.Add(gatewayMetadataPrefix+PubSubMetaAttributeHeader(PubSubMetaMessageID), psmsg.Message.MessageID)
+	r.Header.Add(gatewayMetadataPrefix+PubSubMetaAttributeHeader(PubSubMetaPublishTime), psmsg.Message.PublishTime)
+	if psmsg.Message.OrderingKey != "" {
+		r.Header.Add(gatewayMetadataPrefix+PubSubMetaAttributeHeader(PubSubMetaOrderingKey), psmsg.Message.OrderingKey)
+	}
+	if psmsg.DeliveryAttempt > 0 {
+		r.Header.Add(gatewayMetadataPrefix+PubSubMetaAttributeHeader(PubSubMetaDeliveryAttempt), strconv.Itoa(psmsg.DeliveryAttempt))
+	}
 	for k, v := range psmsg.Message.Attributes {
-		r.Header.Add(headerPrefix+PubSubAttributeHeader(k), v)
+		r.Header.Add(gatewayMetadataPrefix+PubSubAttributeHeader(k), v)
 	}
 
 	// copy query parameters into headers as well
 	for k, vv := range r.URL.Query() {
 		for _, v := range vv {
-			r.Header.Add(headerPrefix+PubSubQueryHeader(PubSubQueryParam(k)), v)
+			r.Header.Add(gatewayMetadataPrefix+PubSubQueryHeader(PubSubQueryParam(k)), v)
 		}
 	}
 
-	return r, nil
+	return r
 }
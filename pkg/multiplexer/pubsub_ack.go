@@ -0,0 +1,140 @@
+package multiplexer
+
+import (
+	"encoding/json"
+	"google.golang.org/grpc/codes"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// PubSubAck is the push response sent to Pub/Sub for a handled message
+type PubSubAck struct {
+	// Ack acknowledges the message, so it is not redelivered by Pub/Sub. Failed
+	// messages that are acknowledged are dropped
+	Ack bool
+	// RetryAfter is a backoff hint sent in the Retry-After header of the
+	// negative acknowledgement
+	RetryAfter time.Duration
+}
+
+// PubSubAckPolicy maps the grpc status codes returned by the handler to the push
+// responses. Codes missing in the policy are mapped by the DefaultPubSubAckPolicy, and
+// codes missing in both are negatively acknowledged
+type PubSubAckPolicy map[codes.Code]PubSubAck
+
+var (
+	// DefaultPubSubAckPolicy acknowledges successful messages and messages that would fail
+	// the same way on every redelivery. Messages failing on transient errors are negatively
+	// acknowledged, so they are redelivered by Pub/Sub
+	DefaultPubSubAckPolicy = PubSubAckPolicy{
+		codes.OK:                {Ack: true},
+		codes.InvalidArgument:   {Ack: true},
+		codes.NotFound:          {Ack: true},
+		codes.AlreadyExists:     {Ack: true},
+		codes.OutOfRange:        {Ack: true},
+		codes.ResourceExhausted: {RetryAfter: time.Second * 10},
+	}
+)
+
+// WithPubSubAckPolicy maps the responses of the handler to the push responses by
+// the grpc status code returned by the handler. Codes missing in the policy are mapped
+// by the DefaultPubSubAckPolicy
+func WithPubSubAckPolicy(policy PubSubAckPolicy) PubSubOption {
+	return func(o *pubSubOptions) {
+		o.ackPolicy = policy
+	}
+}
+
+// WithPubSubSubscriptionAckPolicy overrides the policy set by WithPubSubAckPolicy for
+// the given subscription. Codes missing in the override are mapped by the default policy
+func WithPubSubSubscriptionAckPolicy(subscription string, policy PubSubAckPolicy) PubSubOption {
+	return func(o *pubSubOptions) {
+		if o.subscriptionAckPolicies == nil {
+			o.subscriptionAckPolicies = map[string]PubSubAckPolicy{}
+		}
+		o.subscriptionAckPolicies[subscription] = policy
+	}
+}
+
+// hasAckPolicy returns true if the push responses should be mapped by a policy
+func (o *pubSubOptions) hasAckPolicy() bool {
+	return o.ackPolicy != nil || len(o.subscriptionAckPolicies) > 0
+}
+
+// ackFor returns the push response for the given subscription and status code. The
+// policies are looked up by the full subscription name first and the short one second,
+// and codes missing in all of them are mapped by the DefaultPubSubAckPolicy
+func (o *pubSubOptions) ackFor(subscription string, code codes.Code) PubSubAck {
+	for _, name := range subscriptionNames(subscription) {
+		if ack, ok := o.subscriptionAckPolicies[name][code]; ok {
+			return ack
+		}
+	}
+
+	if ack, ok := o.ackPolicy[code]; ok {
+		return ack
+	}
+
+	return DefaultPubSubAckPolicy[code]
+}
+
+// acknowledge rewrites the recorded response to the push response mapped by the policy
+func (o *pubSubOptions) acknowledge(rec *responseRecorder, subscription string) {
+	ack := o.ackFor(subscription, responseCode(rec))
+
+	if ack.Ack {
+		if rec.status < 200 || rec.status > 299 {
+			rec.status = http.StatusOK
+		}
+		return
+	}
+
+	if rec.status >= 200 && rec.status <= 299 {
+		rec.status = http.StatusInternalServerError
+	}
+	if ack.RetryAfter > 0 {
+		rec.header.Set("Retry-After", strconv.Itoa(int(ack.RetryAfter.Seconds())))
+	}
+}
+
+// responseCode returns the grpc status code of the recorded response. The code is read
+// from the error body written by the grpc-gateway (or the PubSubRouter) and derived from
+// the http status if the body does not contain it
+func responseCode(rec *responseRecorder) codes.Code {
+	if rec.status >= 200 && rec.status <= 299 {
+		return codes.OK
+	}
+
+	body := struct {
+		Code *int `json:"code"`
+	}{}
+	if err := json.Unmarshal(rec.body.Bytes(), &body); err == nil && body.Code != nil {
+		return codes.Code(*body.Code)
+	}
+
+	switch rec.status {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusRequestTimeout:
+		return codes.Canceled
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+
+	return codes.Unknown
+}
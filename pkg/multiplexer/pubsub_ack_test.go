@@ -0,0 +1,150 @@
+package multiplexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type PubSubAckSuite struct {
+	suite.Suite
+}
+
+// statusHandler responds the same way the grpc-gateway does for the given code
+func statusHandler(code codes.Code) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code == codes.OK {
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte("{}"))
+			return
+		}
+
+		writeGatewayStatus(w, status.New(code, "failed"))
+	})
+}
+
+func (s *PubSubAckSuite) push(handler Handler, subscription string) *httptest.ResponseRecorder {
+	body, err := json.Marshal(PushMessage{
+		Subscription: subscription,
+		Message:      &PubSubMessage{Data: []byte("{}"), MessageID: "abc12345"},
+	})
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/echo", bytes.NewReader(body))
+	req.Header.Set("User-Agent", "APIs-Google; (+https://developers.google.com/webmasters/APIs-Google.html)")
+
+	rec := httptest.NewRecorder()
+	s.True(handler(rec, req))
+
+	return rec
+}
+
+func (s *PubSubAckSuite) TestDefaultPolicy() {
+	candidates := map[codes.Code]struct {
+		status     int
+		retryAfter string
+	}{
+		codes.OK:                {status: http.StatusOK},
+		codes.InvalidArgument:   {status: http.StatusOK},
+		codes.AlreadyExists:     {status: http.StatusOK},
+		codes.Unavailable:       {status: http.StatusServiceUnavailable},
+		codes.Internal:          {status: http.StatusInternalServerError},
+		codes.ResourceExhausted: {status: http.StatusTooManyRequests, retryAfter: "10"},
+		// failed precondition shares the 400 status with invalid argument, so the code
+		// must be read from the body for the message to be redelivered
+		codes.FailedPrecondition: {status: http.StatusBadRequest},
+	}
+
+	for code, res := range candidates {
+		handler := NewPubSubHandler(statusHandler(code), WithPubSubAckPolicy(DefaultPubSubAckPolicy))
+
+		rec := s.push(handler, "projects/myproject/subscriptions/echo")
+		s.Equal(res.status, rec.Code, code.String())
+		s.Equal(res.retryAfter, rec.Header().Get("Retry-After"), code.String())
+	}
+}
+
+func (s *PubSubAckSuite) TestSubscriptionPolicy() {
+	handler := NewPubSubHandler(statusHandler(codes.InvalidArgument),
+		WithPubSubAckPolicy(DefaultPubSubAckPolicy),
+		WithPubSubSubscriptionAckPolicy("strict", PubSubAckPolicy{
+			codes.InvalidArgument: {Ack: false, RetryAfter: time.Minute},
+		}),
+	)
+
+	s.Equal(http.StatusOK, s.push(handler, "projects/myproject/subscriptions/echo").Code)
+
+	rec := s.push(handler, "projects/myproject/subscriptions/strict")
+	s.Equal(http.StatusBadRequest, rec.Code)
+	s.Equal("60", rec.Header().Get("Retry-After"))
+
+	// other codes fall back to the default policy
+	handler = NewPubSubHandler(statusHandler(codes.OK),
+		WithPubSubSubscriptionAckPolicy("strict", PubSubAckPolicy{
+			codes.InvalidArgument: {Ack: false},
+		}),
+	)
+	s.Equal(http.StatusOK, s.push(handler, "projects/myproject/subscriptions/strict").Code)
+}
+
+func (s *PubSubAckSuite) TestNackSuccess() {
+	handler := NewPubSubHandler(statusHandler(codes.OK), WithPubSubAckPolicy(PubSubAckPolicy{
+		codes.OK: {Ack: false},
+	}))
+	s.Equal(http.StatusInternalServerError, s.push(handler, "projects/myproject/subscriptions/echo").Code)
+}
+
+func (s *PubSubAckSuite) TestPartialPolicy() {
+	policy := WithPubSubAckPolicy(PubSubAckPolicy{
+		codes.Unavailable: {RetryAfter: time.Second * 30},
+	})
+
+	// codes missing in the policy are mapped by the default policy
+	s.Equal(http.StatusOK, s.push(NewPubSubHandler(statusHandler(codes.OK), policy), "projects/myproject/subscriptions/echo").Code)
+	s.Equal(http.StatusOK, s.push(NewPubSubHandler(statusHandler(codes.InvalidArgument), policy), "projects/myproject/subscriptions/echo").Code)
+
+	rec := s.push(NewPubSubHandler(statusHandler(codes.Unavailable), policy), "projects/myproject/subscriptions/echo")
+	s.Equal(http.StatusServiceUnavailable, rec.Code)
+	s.Equal("30", rec.Header().Get("Retry-After"))
+
+	// the full subscription name takes precedence over the short one
+	handler := NewPubSubHandler(statusHandler(codes.InvalidArgument),
+		WithPubSubSubscriptionAckPolicy("echo", PubSubAckPolicy{
+			codes.InvalidArgument: {Ack: true},
+		}),
+		WithPubSubSubscriptionAckPolicy("projects/myproject/subscriptions/echo", PubSubAckPolicy{
+			codes.InvalidArgument: {Ack: false},
+		}),
+	)
+	for i := 0; i < 10; i++ {
+		s.Equal(http.StatusBadRequest, s.push(handler, "projects/myproject/subscriptions/echo").Code)
+	}
+}
+
+func (s *PubSubAckSuite) TestResponseCode() {
+	candidates := map[*responseRecorder]codes.Code{
+		{status: http.StatusOK}:                  codes.OK,
+		{status: http.StatusNoContent}:           codes.OK,
+		{status: http.StatusServiceUnavailable}:  codes.Unavailable,
+		{status: http.StatusTeapot}:              codes.Unknown,
+		{status: http.StatusInternalServerError}: codes.Unknown,
+	}
+
+	for rec, code := range candidates {
+		s.Equal(code, responseCode(rec), rec.status)
+	}
+
+	rec := newResponseRecorder()
+	writeGatewayStatus(rec, status.New(codes.FailedPrecondition, "failed"))
+	s.Equal(codes.FailedPrecondition, responseCode(rec))
+}
+
+func TestPubSubAckSuite(t *testing.T) {
+	suite.Run(t, &PubSubAckSuite{})
+}
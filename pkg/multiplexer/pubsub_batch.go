@@ -0,0 +1,172 @@
+package multiplexer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// PubSubBatchResult is the result of a single message of the batch push
+type PubSubBatchResult struct {
+	Subscription string `json:"subscription,omitempty"`
+	MessageID    string `json:"messageId,omitempty"`
+	// Status is the http status the message was handled with
+	Status int `json:"status"`
+	// Ack is true if the message was acknowledged by the handler
+	Ack bool `json:"ack"`
+	// Error is the response body of messages that were not acknowledged
+	Error string `json:"error,omitempty"`
+}
+
+// PubSubBatchResponse is the response document of the batch push. Results are
+// in the same order as the messages of the batch
+type PubSubBatchResponse struct {
+	Results []PubSubBatchResult `json:"results"`
+}
+
+// WithPubSubBatch enables pushes of JSON arrays of PushMessage envelopes (e.g. replayed
+// by a relay from a file). Every message of the batch is handled the same way as a single
+// push, concurrently by at most the given number of workers. Messages with the same
+// ordering key are handled one by one in the order of the batch. The batch is responded with
+// the PubSubBatchResponse document, with 200 OK if all messages were acknowledged and
+// 207 Multi-Status otherwise
+func WithPubSubBatch(workers int) PubSubOption {
+	return func(o *pubSubOptions) {
+		o.batchWorkers = workers
+	}
+}
+
+// isPubSubBatch returns true if the request body is a JSON array. The body is
+// peeked, so it can still be read as a whole by the handlers
+func isPubSubBatch(r *http.Request) bool {
+	br := bufio.NewReader(r.Body)
+	r.Body = ioutil.NopCloser(br)
+
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return false
+		}
+
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			_, _ = br.ReadByte()
+		default:
+			return b[0] == '['
+		}
+	}
+}
+
+// serveBatch handles all messages of the batch and responds with the aggregated results
+func (o *pubSubOptions) serveBatch(handler http.Handler, w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writePubSubError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	batch := []*PushMessage{}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		writePubSubError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	res := PubSubBatchResponse{Results: make([]PubSubBatchResult, len(batch))}
+	workers := make(chan struct{}, o.batchWorkers)
+	wg := sync.WaitGroup{}
+
+	for _, group := range batchGroups(batch) {
+		workers <- struct{}{}
+		wg.Add(1)
+
+		go func(group []int) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+
+			// messages of the same group are handled in the order of the batch
+			for _, i := range group {
+				res.Results[i] = o.handleBatched(handler, r, batch[i])
+			}
+		}(group)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	for _, result := range res.Results {
+		if !result.Ack {
+			status = http.StatusMultiStatus
+			break
+		}
+	}
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+// batchGroups splits the batch into groups of message indexes that are handled one by one.
+// Messages sharing the subscription and the ordering key are grouped together in the order
+// of the batch, and every message without an ordering key forms a group of its own
+func batchGroups(batch []*PushMessage) [][]int {
+	groups := [][]int{}
+	ordered := map[[2]string]int{}
+
+	for i, psmsg := range batch {
+		if psmsg == nil || psmsg.Message == nil || psmsg.Message.OrderingKey == "" {
+			groups = append(groups, []int{i})
+			continue
+		}
+
+		key := [2]string{psmsg.Subscription, psmsg.Message.OrderingKey}
+		g, ok := ordered[key]
+		if !ok {
+			g = len(groups)
+			ordered[key] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+
+	return groups
+}
+
+// handleBatched handles a single message of the batch as if it was pushed by itself
+func (o *pubSubOptions) handleBatched(handler http.Handler, r *http.Request, psmsg *PushMessage) PubSubBatchResult {
+	result := PubSubBatchResult{}
+	if err := o.validate(psmsg); err != nil {
+		result.Status = http.StatusBadRequest
+		result.Error = err.Error()
+
+		perr := &PubSubError{}
+		if errors.As(err, &perr) {
+			result.Status = perr.Status
+		}
+		return result
+	}
+
+	result.Subscription = psmsg.Subscription
+	result.MessageID = psmsg.Message.MessageID
+
+	rec := newResponseRecorder()
+	o.handle(handler, rec, r.Clone(r.Context()), psmsg)
+
+	result.Status = rec.status
+	result.Ack = rec.status >= 200 && rec.status <= 299
+	if !result.Ack {
+		result.Error = rec.body.String()
+	}
+
+	return result
+}
@@ -0,0 +1,159 @@
+package multiplexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type PubSubBatchSuite struct {
+	suite.Suite
+
+	mu          sync.Mutex
+	running     int
+	maxRunning  int
+	handledData []string
+
+	handler Handler
+}
+
+func (s *PubSubBatchSuite) SetupTest() {
+	s.running, s.maxRunning, s.handledData = 0, 0, nil
+
+	s.handler = NewPubSubHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.running++
+		if s.running > s.maxRunning {
+			s.maxRunning = s.running
+		}
+		s.mu.Unlock()
+
+		time.Sleep(time.Millisecond * 10)
+		data, _ := ioutil.ReadAll(r.Body)
+
+		s.mu.Lock()
+		s.running--
+		s.handledData = append(s.handledData, string(data))
+		s.mu.Unlock()
+
+		if string(data) == "fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("unavailable"))
+		}
+	}), WithPubSubBatch(2))
+}
+
+func (s *PubSubBatchSuite) push(body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/echo", bytes.NewReader(body))
+	req.Header.Set("User-Agent", "APIs-Google; (+https://developers.google.com/webmasters/APIs-Google.html)")
+
+	rec := httptest.NewRecorder()
+	s.True(s.handler(rec, req))
+
+	return rec
+}
+
+func (s *PubSubBatchSuite) TestBatch() {
+	batch := []PushMessage{}
+	for i := 0; i < 5; i++ {
+		data := "message-" + strconv.Itoa(i)
+		if i == 3 {
+			data = "fail"
+		}
+
+		batch = append(batch, PushMessage{
+			Subscription: "projects/myproject/subscriptions/echo",
+			Message: &PubSubMessage{
+				Data:      []byte(data),
+				MessageID: strconv.Itoa(i),
+			},
+		})
+	}
+	batch = append(batch, PushMessage{Subscription: "projects/myproject/subscriptions/echo"})
+
+	body, err := json.Marshal(batch)
+	s.NoError(err)
+
+	rec := s.push(append([]byte("\n  "), body...))
+	s.Equal(http.StatusMultiStatus, rec.Code)
+
+	res := PubSubBatchResponse{}
+	s.NoError(json.Unmarshal(rec.Body.Bytes(), &res))
+	s.Len(res.Results, 6)
+
+	for i, result := range res.Results[:5] {
+		s.Equal(strconv.Itoa(i), result.MessageID)
+		s.Equal("projects/myproject/subscriptions/echo", result.Subscription)
+		s.Equal(i != 3, result.Ack)
+	}
+	s.Equal(http.StatusServiceUnavailable, res.Results[3].Status)
+	s.Equal("unavailable", res.Results[3].Error)
+	s.Equal(http.StatusBadRequest, res.Results[5].Status)
+
+	s.Len(s.handledData, 5)
+	s.Equal(2, s.maxRunning)
+}
+
+func (s *PubSubBatchSuite) TestOrderingKeys() {
+	var mu sync.Mutex
+	handled := map[string][]string{}
+
+	// earlier messages are handled slower, so they would finish last if handled concurrently
+	handler := NewPubSubHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		pos, _ := strconv.Atoi(string(data[len(data)-1:]))
+		time.Sleep(time.Millisecond * time.Duration(10*(6-pos)))
+
+		key := r.Header.Get(gatewayMetadataPrefix + PubSubMetaAttributeHeader(PubSubMetaOrderingKey))
+		mu.Lock()
+		handled[key] = append(handled[key], string(data))
+		mu.Unlock()
+	}), WithPubSubBatch(4))
+
+	batch := []PushMessage{}
+	for i := 0; i < 6; i++ {
+		msg := &PubSubMessage{
+			Data:      []byte("message-" + strconv.Itoa(i)),
+			MessageID: strconv.Itoa(i),
+		}
+		if i%3 != 2 {
+			msg.OrderingKey = "key-" + strconv.Itoa(i%3)
+		}
+
+		batch = append(batch, PushMessage{Subscription: "projects/myproject/subscriptions/echo", Message: msg})
+	}
+
+	body, err := json.Marshal(batch)
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/echo", bytes.NewReader(body))
+	req.Header.Set("User-Agent", "APIs-Google; (+https://developers.google.com/webmasters/APIs-Google.html)")
+	rec := httptest.NewRecorder()
+	s.True(handler(rec, req))
+	s.Equal(http.StatusOK, rec.Code)
+
+	// messages with the same ordering key are handled in the order of the batch
+	s.Equal([]string{"message-0", "message-3"}, handled["key-0"])
+	s.Equal([]string{"message-1", "message-4"}, handled["key-1"])
+	s.ElementsMatch([]string{"message-2", "message-5"}, handled[""])
+}
+
+func (s *PubSubBatchSuite) TestSinglePush() {
+	body, err := json.Marshal(goldenPubSubMessageJSON)
+	s.NoError(err)
+
+	rec := s.push(body)
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal([]string{string(goldenPubSubMessageData)}, s.handledData)
+}
+
+func TestPubSubBatchSuite(t *testing.T) {
+	suite.Run(t, &PubSubBatchSuite{})
+}
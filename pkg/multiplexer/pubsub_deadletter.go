@@ -0,0 +1,23 @@
+package multiplexer
+
+import (
+	"net/http"
+)
+
+// WithPubSubDeadLetter diverts pushes delivered more than maxAttempts times to the
+// fallback handler (e.g. a local quarantine writer) instead of the main handler, so
+// poison messages do not keep failing in the gateway. The fallback receives the
+// unwrapped request with the same headers and its response acknowledges the push.
+// Pub/Sub only sends the delivery attempt for subscriptions with a dead letter policy
+func WithPubSubDeadLetter(maxAttempts int, fallback http.Handler) PubSubOption {
+	return func(o *pubSubOptions) {
+		o.maxDeliveryAttempts = maxAttempts
+		o.deadLetter = fallback
+	}
+}
+
+// exceedsDeliveryAttempts returns true if the message should be diverted to the
+// dead letter handler
+func (o *pubSubOptions) exceedsDeliveryAttempts(psmsg *PushMessage) bool {
+	return o.deadLetter != nil && psmsg.DeliveryAttempt > o.maxDeliveryAttempts
+}
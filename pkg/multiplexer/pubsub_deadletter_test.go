@@ -0,0 +1,74 @@
+package multiplexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type PubSubDeadLetterSuite struct {
+	suite.Suite
+
+	handled     []string
+	quarantined []string
+	attempts    []string
+
+	handler Handler
+}
+
+func (s *PubSubDeadLetterSuite) SetupTest() {
+	s.handled, s.quarantined, s.attempts = nil, nil, nil
+
+	s.handler = NewPubSubHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		s.handled = append(s.handled, string(data))
+		s.attempts = append(s.attempts, r.Header.Get(gatewayMetadataPrefix+PubSubMetaAttributeHeader(PubSubMetaDeliveryAttempt)))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}), WithPubSubDeadLetter(3, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		s.quarantined = append(s.quarantined, string(data))
+		s.attempts = append(s.attempts, r.Header.Get(gatewayMetadataPrefix+PubSubMetaAttributeHeader(PubSubMetaDeliveryAttempt)))
+	})))
+}
+
+func (s *PubSubDeadLetterSuite) push(attempt int) *httptest.ResponseRecorder {
+	body, err := json.Marshal(PushMessage{
+		Subscription:    "projects/myproject/subscriptions/echo",
+		DeliveryAttempt: attempt,
+		Message:         &PubSubMessage{Data: []byte("poison"), MessageID: "1"},
+	})
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/echo", bytes.NewReader(body))
+	req.Header.Set("User-Agent", "APIs-Google; (+https://developers.google.com/webmasters/APIs-Google.html)")
+
+	rec := httptest.NewRecorder()
+	s.True(s.handler(rec, req))
+
+	return rec
+}
+
+func (s *PubSubDeadLetterSuite) TestDeadLetter() {
+	// subscriptions without a dead letter policy do not send the attempt
+	s.Equal(http.StatusServiceUnavailable, s.push(0).Code)
+	s.Equal(http.StatusServiceUnavailable, s.push(3).Code)
+	s.Equal(http.StatusOK, s.push(4).Code)
+
+	s.Equal([]string{"poison", "poison"}, s.handled)
+	s.Equal([]string{"poison"}, s.quarantined)
+	s.Equal([]string{"", "3", "4"}, s.attempts)
+}
+
+func (s *PubSubDeadLetterSuite) TestDeliveryAttemptDecoding() {
+	psmsg := PushMessage{}
+	s.NoError(json.Unmarshal([]byte(`{"message":{"messageId":"1"},"deliveryAttempt":5}`), &psmsg))
+	s.Equal(5, psmsg.DeliveryAttempt)
+}
+
+func TestPubSubDeadLetterSuite(t *testing.T) {
+	suite.Run(t, &PubSubDeadLetterSuite{})
+}
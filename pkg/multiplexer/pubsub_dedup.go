@@ -0,0 +1,211 @@
+package multiplexer
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrPubSubInFlight is returned for a duplicate push of a message that is still being
+	// handled. The push is not acknowledged, so it is redelivered if the handling fails
+	ErrPubSubInFlight = errors.New("pubsub: message is already being handled")
+)
+
+// DedupState is the state of a key in the DedupStore
+type DedupState int
+
+const (
+	// DedupReserved means the key was not known and was reserved by the call
+	DedupReserved DedupState = iota
+	// DedupInFlight means the key is reserved by a message that is still being handled
+	DedupInFlight
+	// DedupCommitted means the message of the key was handled successfully
+	DedupCommitted
+)
+
+// DedupStore remembers the keys of the handled Pub/Sub messages, so duplicate
+// pushes of the same message are not handled repeatedly
+type DedupStore interface {
+	// Reserve marks the key as in flight for the given ttl if it is not known yet. It
+	// returns DedupReserved if the key was reserved by the call, and the state of the
+	// key otherwise
+	Reserve(ctx context.Context, key string, ttl time.Duration) (DedupState, error)
+	// Commit marks the reserved key as handled for the given ttl
+	Commit(ctx context.Context, key string, ttl time.Duration) error
+	// Release removes the reservation of the key, so the message can be handled
+	// again, e.g. after its handling failed and Pub/Sub redelivers it
+	Release(ctx context.Context, key string) error
+}
+
+// MemoryDedupStore is an in-memory DedupStore. Keys expire after their ttl and the
+// least recently reserved keys are evicted once the store reaches its capacity
+type MemoryDedupStore struct {
+	mu       sync.Mutex
+	capacity int
+	keys     map[string]*list.Element
+	order    *list.List
+
+	now func() time.Time
+}
+
+type dedupEntry struct {
+	key       string
+	expires   time.Time
+	committed bool
+}
+
+// NewMemoryDedupStore creates an in-memory DedupStore holding at most capacity keys.
+// The store is unbounded if the capacity is not positive
+func NewMemoryDedupStore(capacity int) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		capacity: capacity,
+		keys:     map[string]*list.Element{},
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+// Reserve marks the key as in flight for the given ttl if it is not known yet
+func (s *MemoryDedupStore) Reserve(_ context.Context, key string, ttl time.Duration) (DedupState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if el, ok := s.keys[key]; ok {
+		entry := el.Value.(*dedupEntry)
+		if now.Before(entry.expires) {
+			if entry.committed {
+				return DedupCommitted, nil
+			}
+			return DedupInFlight, nil
+		}
+		s.remove(el)
+	}
+
+	s.insert(&dedupEntry{key: key, expires: now.Add(ttl)})
+	return DedupReserved, nil
+}
+
+// Commit marks the key as handled for the given ttl
+func (s *MemoryDedupStore) Commit(_ context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.keys[key]; ok {
+		s.remove(el)
+	}
+
+	s.insert(&dedupEntry{key: key, expires: s.now().Add(ttl), committed: true})
+	return nil
+}
+
+// Release removes the reservation of the key
+func (s *MemoryDedupStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.keys[key]; ok {
+		s.remove(el)
+	}
+
+	return nil
+}
+
+// Len returns the number of keys in the store, including the expired ones
+// that were not evicted yet
+func (s *MemoryDedupStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.order.Len()
+}
+
+func (s *MemoryDedupStore) insert(entry *dedupEntry) {
+	s.keys[entry.key] = s.order.PushFront(entry)
+
+	for s.capacity > 0 && s.order.Len() > s.capacity {
+		s.remove(s.order.Back())
+	}
+}
+
+func (s *MemoryDedupStore) remove(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.keys, el.Value.(*dedupEntry).key)
+}
+
+// WithPubSubDedup short-circuits repeated pushes of the same message within the ttl
+// window with a successful response, without passing them to the handler. Messages
+// are identified by their subscription and message ID (see WithPubSubDedupAttribute).
+// Pushes repeated while the message is still being handled are rejected with 409
+// Conflict, so they are redelivered if the handling fails. Messages without an ID
+// are never deduplicated
+func WithPubSubDedup(store DedupStore, ttl time.Duration) PubSubOption {
+	return func(o *pubSubOptions) {
+		o.dedupStore = store
+		o.dedupTTL = ttl
+	}
+}
+
+// WithPubSubDedupAttribute identifies messages by the given attribute instead of
+// the message ID. This is useful if publishers retry publishing the same message,
+// which is then received with different message IDs
+func WithPubSubDedupAttribute(attr string) PubSubOption {
+	return func(o *pubSubOptions) {
+		o.dedupAttribute = attr
+	}
+}
+
+// dedupKey returns the key identifying the message in the DedupStore, or an empty
+// key if the message cannot be deduplicated
+func (o *pubSubOptions) dedupKey(psmsg *PushMessage) string {
+	if o.dedupStore == nil || psmsg.Message == nil {
+		return ""
+	}
+
+	id := psmsg.Message.MessageID
+	if o.dedupAttribute != "" {
+		if v, ok := psmsg.Message.Attributes[o.dedupAttribute]; ok && v != "" {
+			id = v
+		}
+	}
+	if id == "" {
+		return ""
+	}
+
+	return psmsg.Subscription + "/" + id
+}
+
+// reserve returns the state of the message in the DedupStore. Failures of the store
+// are ignored, so the message is rather handled twice than never
+func (o *pubSubOptions) reserve(r *http.Request, key string) DedupState {
+	if key == "" {
+		return DedupReserved
+	}
+
+	state, err := o.dedupStore.Reserve(r.Context(), key, o.dedupTTL)
+	if err != nil {
+		return DedupReserved
+	}
+
+	return state
+}
+
+// settle commits the reservation of an acknowledged message, or removes the
+// reservation of a message that was not acknowledged
+func (o *pubSubOptions) settle(r *http.Request, key string, acknowledged bool) {
+	if key == "" {
+		return
+	}
+
+	if acknowledged {
+		_ = o.dedupStore.Commit(r.Context(), key, o.dedupTTL)
+		return
+	}
+
+	// the message will be redelivered, so it must not be considered a duplicate
+	_ = o.dedupStore.Release(r.Context(), key)
+}
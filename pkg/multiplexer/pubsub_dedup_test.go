@@ -0,0 +1,174 @@
+package multiplexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type PubSubDedupSuite struct {
+	suite.Suite
+
+	now   time.Time
+	store *MemoryDedupStore
+}
+
+func (s *PubSubDedupSuite) SetupTest() {
+	s.now = time.Unix(1600000000, 0)
+	s.store = NewMemoryDedupStore(2)
+	s.store.now = func() time.Time {
+		return s.now
+	}
+}
+
+func (s *PubSubDedupSuite) TestMemoryDedupStore() {
+	ctx := context.Background()
+
+	state, err := s.store.Reserve(ctx, "a", time.Minute)
+	s.NoError(err)
+	s.Equal(DedupReserved, state)
+
+	state, err = s.store.Reserve(ctx, "a", time.Minute)
+	s.NoError(err)
+	s.Equal(DedupInFlight, state)
+
+	// released keys can be reserved again
+	s.NoError(s.store.Release(ctx, "a"))
+	state, _ = s.store.Reserve(ctx, "a", time.Minute)
+	s.Equal(DedupReserved, state)
+
+	// committed keys are handled
+	s.NoError(s.store.Commit(ctx, "a", time.Minute))
+	state, _ = s.store.Reserve(ctx, "a", time.Minute)
+	s.Equal(DedupCommitted, state)
+
+	// expired keys can be reserved again
+	s.now = s.now.Add(time.Minute)
+	state, _ = s.store.Reserve(ctx, "a", time.Minute)
+	s.Equal(DedupReserved, state)
+
+	// the least recently reserved key is evicted over capacity
+	state, _ = s.store.Reserve(ctx, "b", time.Minute)
+	s.Equal(DedupReserved, state)
+	state, _ = s.store.Reserve(ctx, "c", time.Minute)
+	s.Equal(DedupReserved, state)
+	s.Equal(2, s.store.Len())
+
+	state, _ = s.store.Reserve(ctx, "a", time.Minute)
+	s.Equal(DedupReserved, state)
+	state, _ = s.store.Reserve(ctx, "c", time.Minute)
+	s.Equal(DedupInFlight, state)
+}
+
+func (s *PubSubDedupSuite) push(handler Handler, msg *PubSubMessage) *httptest.ResponseRecorder {
+	body, err := json.Marshal(PushMessage{
+		Subscription: "projects/myproject/subscriptions/echo",
+		Message:      msg,
+	})
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/echo", bytes.NewReader(body))
+	req.Header.Set("User-Agent", "APIs-Google; (+https://developers.google.com/webmasters/APIs-Google.html)")
+
+	rec := httptest.NewRecorder()
+	s.True(handler(rec, req))
+
+	return rec
+}
+
+func (s *PubSubDedupSuite) TestPubSubHandlerDedup() {
+	calls := 0
+	status := http.StatusOK
+	handler := NewPubSubHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(status)
+	}), WithPubSubDedup(NewMemoryDedupStore(100), time.Minute))
+
+	msg := &PubSubMessage{Data: []byte("{}"), MessageID: "abc12345"}
+
+	s.Equal(http.StatusOK, s.push(handler, msg).Code)
+	s.Equal(http.StatusOK, s.push(handler, msg).Code)
+	s.Equal(1, calls)
+
+	// failed messages are redelivered and must be handled again
+	status = http.StatusServiceUnavailable
+	msg.MessageID = "abc67890"
+	s.Equal(http.StatusServiceUnavailable, s.push(handler, msg).Code)
+	s.Equal(http.StatusServiceUnavailable, s.push(handler, msg).Code)
+	s.Equal(3, calls)
+}
+
+func (s *PubSubDedupSuite) TestPubSubHandlerDedupAttribute() {
+	calls := 0
+	handler := NewPubSubHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}),
+		WithPubSubDedup(NewMemoryDedupStore(100), time.Minute),
+		WithPubSubDedupAttribute("event-id"),
+	)
+
+	s.push(handler, &PubSubMessage{MessageID: "1", Attributes: map[string]string{"event-id": "event"}})
+	s.push(handler, &PubSubMessage{MessageID: "2", Attributes: map[string]string{"event-id": "event"}})
+	s.push(handler, &PubSubMessage{MessageID: "3", Attributes: map[string]string{"event-id": "other"}})
+	s.Equal(2, calls)
+}
+
+func (s *PubSubDedupSuite) TestPubSubHandlerDedupWithoutID() {
+	calls := 0
+	handler := NewPubSubHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}),
+		WithPubSubDedup(NewMemoryDedupStore(100), time.Minute),
+		WithPubSubDedupAttribute("event-id"),
+	)
+
+	// messages without an ID are never considered duplicates
+	s.Equal(http.StatusOK, s.push(handler, &PubSubMessage{Data: []byte("{}")}).Code)
+	s.Equal(http.StatusOK, s.push(handler, &PubSubMessage{Data: []byte("{}")}).Code)
+	s.Equal(http.StatusOK, s.push(handler, &PubSubMessage{Attributes: map[string]string{"event-id": ""}}).Code)
+	s.Equal(3, calls)
+}
+
+func (s *PubSubDedupSuite) TestPubSubHandlerDedupInFlight() {
+	entered := make(chan struct{})
+	proceed := make(chan struct{})
+	status := http.StatusServiceUnavailable
+	handler := NewPubSubHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-proceed
+		w.WriteHeader(status)
+	}), WithPubSubDedup(NewMemoryDedupStore(100), time.Minute))
+
+	msg := &PubSubMessage{Data: []byte("{}"), MessageID: "abc12345"}
+
+	first := make(chan int)
+	go func() {
+		first <- s.push(handler, msg).Code
+	}()
+	<-entered
+
+	// the duplicate is not acknowledged while the first push is being handled
+	rec := s.push(handler, msg)
+	s.Equal(http.StatusConflict, rec.Code)
+	s.Contains(rec.Body.String(), ErrPubSubInFlight.Error())
+
+	// the first push fails, so the redelivery is handled again
+	proceed <- struct{}{}
+	s.Equal(http.StatusServiceUnavailable, <-first)
+
+	status = http.StatusOK
+	go func() {
+		<-entered
+		proceed <- struct{}{}
+	}()
+	s.Equal(http.StatusOK, s.push(handler, msg).Code)
+}
+
+func TestPubSubDedupSuite(t *testing.T) {
+	suite.Run(t, &PubSubDedupSuite{})
+}
@@ -0,0 +1,153 @@
+package multiplexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// EmulatorHostEnv is the environment variable with the host of the Pub/Sub emulator
+const EmulatorHostEnv = "PUBSUB_EMULATOR_HOST"
+
+// EmulatorSubscriber is a Subscriber pulling from the Pub/Sub emulator over its REST API
+type EmulatorSubscriber struct {
+	client       *http.Client
+	baseURL      string
+	subscription string
+}
+
+// NewEmulatorSubscriber creates a Subscriber of the subscription (in the projects/{project}/subscriptions/{name}
+// form) in the emulator running on the given host. The host defaults to the PUBSUB_EMULATOR_HOST environment
+// variable and the client defaults to http.DefaultClient
+func NewEmulatorSubscriber(host, subscription string, client *http.Client) *EmulatorSubscriber {
+	return &EmulatorSubscriber{
+		client:       emulatorClient(client),
+		baseURL:      emulatorURL(host),
+		subscription: subscription,
+	}
+}
+
+// Subscription returns the full name of the subscription
+func (s *EmulatorSubscriber) Subscription() string {
+	return s.subscription
+}
+
+// Pull pulls at most max messages. The emulator holds the request until a message
+// is available
+func (s *EmulatorSubscriber) Pull(ctx context.Context, max int) ([]*ReceivedMessage, error) {
+	res := struct {
+		ReceivedMessages []*ReceivedMessage `json:"receivedMessages"`
+	}{}
+
+	err := s.call(ctx, "pull", map[string]interface{}{"maxMessages": max}, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.ReceivedMessages, nil
+}
+
+// Ack acknowledges the messages
+func (s *EmulatorSubscriber) Ack(ctx context.Context, ackIDs ...string) error {
+	return s.call(ctx, "acknowledge", map[string]interface{}{"ackIds": ackIDs}, nil)
+}
+
+// Nack makes the messages available for redelivery immediately by resetting their
+// ack deadline
+func (s *EmulatorSubscriber) Nack(ctx context.Context, ackIDs ...string) error {
+	return s.call(ctx, "modifyAckDeadline", map[string]interface{}{"ackIds": ackIDs, "ackDeadlineSeconds": 0}, nil)
+}
+
+func (s *EmulatorSubscriber) call(ctx context.Context, method string, in, out interface{}) error {
+	return emulatorCall(ctx, s.client, s.baseURL+"/v1/"+s.subscription+":"+method, in, out)
+}
+
+// emulatorCall posts the JSON request to the emulator and decodes its response into out
+func emulatorCall(ctx context.Context, client *http.Client, url string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("pubsub: emulator responded with %d: %s", res.StatusCode, data)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+func emulatorURL(host string) string {
+	if host == "" {
+		host = os.Getenv(EmulatorHostEnv)
+	}
+
+	return "http://" + host
+}
+
+func emulatorClient(client *http.Client) *http.Client {
+	if client == nil {
+		return http.DefaultClient
+	}
+
+	return client
+}
+
+// EmulatorPublisher is a Publisher publishing to the Pub/Sub emulator over its REST API
+type EmulatorPublisher struct {
+	client  *http.Client
+	baseURL string
+}
+
+// NewEmulatorPublisher creates a Publisher of the emulator running on the given host. The host
+// defaults to the PUBSUB_EMULATOR_HOST environment variable and the client defaults to http.DefaultClient
+func NewEmulatorPublisher(host string, client *http.Client) *EmulatorPublisher {
+	return &EmulatorPublisher{
+		client:  emulatorClient(client),
+		baseURL: emulatorURL(host),
+	}
+}
+
+// Publish publishes the message to the topic
+func (p *EmulatorPublisher) Publish(ctx context.Context, topic string, msg *PubSubMessage) (string, error) {
+	res := struct {
+		MessageIDs []string `json:"messageIds"`
+	}{}
+
+	err := emulatorCall(ctx, p.client, p.baseURL+"/v1/"+topic+":publish", map[string]interface{}{
+		"messages": []*PubSubMessage{msg},
+	}, &res)
+	if err != nil {
+		return "", err
+	}
+
+	if len(res.MessageIDs) != 1 {
+		return "", fmt.Errorf("pubsub: emulator returned %d message ids", len(res.MessageIDs))
+	}
+
+	return res.MessageIDs[0], nil
+}
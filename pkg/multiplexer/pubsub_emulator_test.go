@@ -0,0 +1,88 @@
+package multiplexer
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type PubSubEmulatorSuite struct {
+	suite.Suite
+
+	requests map[string]string
+	emulator *httptest.Server
+}
+
+func (s *PubSubEmulatorSuite) SetupTest() {
+	s.requests = map[string]string{}
+	s.emulator = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.requests[r.URL.Path] = string(body)
+
+		if strings.HasSuffix(r.URL.Path, ":pull") {
+			_, _ = w.Write([]byte(`{"receivedMessages":[{"ackId":"ack-1","message":{"data":"aGVsbG8=","messageId":"1"},"deliveryAttempt":2}]}`))
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, ":publish") {
+			_, _ = w.Write([]byte(`{"messageIds":["42"]}`))
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, ":fail") {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("not found"))
+			return
+		}
+
+		_, _ = w.Write([]byte("{}"))
+	}))
+}
+
+func (s *PubSubEmulatorSuite) TearDownTest() {
+	s.emulator.Close()
+}
+
+func (s *PubSubEmulatorSuite) TestEmulatorSubscriber() {
+	ctx := context.Background()
+	sub := NewEmulatorSubscriber(strings.TrimPrefix(s.emulator.URL, "http://"), "projects/myproject/subscriptions/echo", nil)
+
+	msgs, err := sub.Pull(ctx, 5)
+	s.NoError(err)
+	s.Equal([]*ReceivedMessage{{
+		AckID:           "ack-1",
+		Message:         &PubSubMessage{Data: []byte("hello"), MessageID: "1"},
+		DeliveryAttempt: 2,
+	}}, msgs)
+	s.JSONEq(`{"maxMessages":5}`, s.requests["/v1/projects/myproject/subscriptions/echo:pull"])
+
+	s.NoError(sub.Ack(ctx, "ack-1"))
+	s.JSONEq(`{"ackIds":["ack-1"]}`, s.requests["/v1/projects/myproject/subscriptions/echo:acknowledge"])
+
+	s.NoError(sub.Nack(ctx, "ack-2"))
+	s.JSONEq(`{"ackIds":["ack-2"],"ackDeadlineSeconds":0}`, s.requests["/v1/projects/myproject/subscriptions/echo:modifyAckDeadline"])
+}
+
+func (s *PubSubEmulatorSuite) TestEmulatorPublisher() {
+	pub := NewEmulatorPublisher(strings.TrimPrefix(s.emulator.URL, "http://"), nil)
+
+	id, err := pub.Publish(context.Background(), "projects/myproject/topics/echo", &PubSubMessage{
+		Data:       []byte("hello"),
+		Attributes: map[string]string{"key": "value"},
+	})
+	s.NoError(err)
+	s.Equal("42", id)
+	s.JSONEq(`{"messages":[{"data":"aGVsbG8=","attributes":{"key":"value"}}]}`, s.requests["/v1/projects/myproject/topics/echo:publish"])
+}
+
+func (s *PubSubEmulatorSuite) TestEmulatorErrors() {
+	err := emulatorCall(context.Background(), http.DefaultClient, s.emulator.URL+"/v1/projects/myproject/topics/echo:fail", json.RawMessage("{}"), nil)
+	s.EqualError(err, "pubsub: emulator responded with 404: not found")
+}
+
+func TestPubSubEmulatorSuite(t *testing.T) {
+	suite.Run(t, &PubSubEmulatorSuite{})
+}
@@ -0,0 +1,138 @@
+package multiplexer
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"strings"
+)
+
+const (
+	// ContentTypeProtobuf is the content type of binary encoded protobuf messages
+	ContentTypeProtobuf = "application/x-protobuf"
+
+	// PubSubContentTypeAttribute is the message attribute declaring the content
+	// type of the message data, e.g. application/json or application/x-protobuf
+	PubSubContentTypeAttribute = "content-type"
+
+	// PubSubSchemaEncodingAttribute is the message attribute set by Pub/Sub for
+	// topics with a schema. Its value is either JSON or BINARY
+	PubSubSchemaEncodingAttribute = "googclient_schemaencoding"
+)
+
+// PubSubEncoding is the encoding of the Pub/Sub message data
+type PubSubEncoding string
+
+const (
+	// PubSubEncodingUnknown is used if the encoding is neither declared by the
+	// message nor configured for its subscription. Such data are passed as they are
+	PubSubEncodingUnknown PubSubEncoding = ""
+	// PubSubEncodingJSON is the JSON encoding of the message data. Messages validated
+	// by Avro or protobuf schemas with the JSON encoding are using it as well
+	PubSubEncodingJSON PubSubEncoding = "json"
+	// PubSubEncodingBinary is the binary protobuf encoding of the message data
+	PubSubEncodingBinary PubSubEncoding = "binary"
+)
+
+// PubSubEncodingOf returns the encoding declared by the message attributes. The
+// PubSubContentTypeAttribute is preferred over the PubSubSchemaEncodingAttribute
+func PubSubEncodingOf(msg *PubSubMessage) PubSubEncoding {
+	if ct := msg.Attributes[PubSubContentTypeAttribute]; ct != "" {
+		if isProtobufContentType(ct) {
+			return PubSubEncodingBinary
+		}
+		if strings.Contains(ct, "json") {
+			return PubSubEncodingJSON
+		}
+	}
+
+	switch strings.ToUpper(msg.Attributes[PubSubSchemaEncodingAttribute]) {
+	case "BINARY":
+		return PubSubEncodingBinary
+	case "JSON":
+		return PubSubEncodingJSON
+	}
+
+	return PubSubEncodingUnknown
+}
+
+// isProtobufContentType returns true for all content types used for binary protobuf
+func isProtobufContentType(ct string) bool {
+	for _, known := range []string{ContentTypeProtobuf, "application/protobuf", "application/x-proto", "application/proto"} {
+		if strings.HasPrefix(ct, known) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// subscriptionMatches returns true if the full subscription name (projects/myproject/subscriptions/mysubscription)
+// matches the given name, which is either the full or the short one
+func subscriptionMatches(subscription, name string) bool {
+	return subscription == name || strings.HasSuffix(subscription, "/subscriptions/"+name)
+}
+
+// subscriptionNames returns the names the subscription can be configured by, the full
+// name (projects/myproject/subscriptions/mysubscription) first and the short one second
+func subscriptionNames(subscription string) []string {
+	names := []string{subscription}
+	if pos := strings.LastIndex(subscription, "/subscriptions/"); pos >= 0 {
+		names = append(names, subscription[pos+len("/subscriptions/"):])
+	}
+
+	return names
+}
+
+// transcode converts the binary data of the push message into JSON if the message
+// type of its subscription is known. Binary data of unknown types are kept and
+// the Content-Type of the request is set to ContentTypeProtobuf
+func (o *pubSubOptions) transcode(r *http.Request, psmsg *PushMessage) error {
+	if psmsg.Message == nil {
+		return nil
+	}
+
+	encoding := PubSubEncodingOf(psmsg.Message)
+	if encoding == PubSubEncodingUnknown {
+		for _, name := range subscriptionNames(psmsg.Subscription) {
+			if e, ok := o.encodings[name]; ok {
+				encoding = e
+				break
+			}
+		}
+	}
+
+	switch encoding {
+	case PubSubEncodingJSON:
+		r.Header.Set("Content-Type", "application/json")
+		return nil
+	case PubSubEncodingBinary:
+	default:
+		return nil
+	}
+
+	for _, name := range subscriptionNames(psmsg.Subscription) {
+		msgType, ok := o.messageTypes[name]
+		if !ok {
+			continue
+		}
+
+		msg := msgType.ProtoReflect().New().Interface()
+		if err := proto.Unmarshal(psmsg.Message.Data, msg); err != nil {
+			return fmt.Errorf("pubsub: failed to decode binary data: %w", err)
+		}
+
+		data, err := protojson.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("pubsub: failed to transcode binary data: %w", err)
+		}
+
+		psmsg.Message.Data = data
+		r.Header.Set("Content-Type", "application/json")
+		return nil
+	}
+
+	r.Header.Set("Content-Type", ContentTypeProtobuf)
+	return nil
+}
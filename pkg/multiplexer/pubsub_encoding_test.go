@@ -0,0 +1,152 @@
+package multiplexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/petomalina/xrpc/v2/examples/api"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type PubSubEncodingSuite struct {
+	suite.Suite
+
+	binaryData []byte
+}
+
+func (s *PubSubEncodingSuite) SetupTest() {
+	data, err := proto.Marshal(&api.EchoMessage{Message: "Hello World"})
+	s.NoError(err)
+	s.binaryData = data
+}
+
+func (s *PubSubEncodingSuite) push(handler Handler, msg PushMessage) *httptest.ResponseRecorder {
+	body, err := json.Marshal(msg)
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/echo", bytes.NewReader(body))
+	req.Header.Set("User-Agent", "APIs-Google; (+https://developers.google.com/webmasters/APIs-Google.html)")
+	req.Header.Set("Content-Type", "application/json")
+
+	rec := httptest.NewRecorder()
+	s.True(handler(rec, req))
+
+	return rec
+}
+
+func (s *PubSubEncodingSuite) TestPubSubEncodingOf() {
+	candidates := map[*PubSubMessage]PubSubEncoding{
+		{Attributes: map[string]string{PubSubContentTypeAttribute: "application/x-protobuf"}}:        PubSubEncodingBinary,
+		{Attributes: map[string]string{PubSubContentTypeAttribute: "application/protobuf; proto=x"}}: PubSubEncodingBinary,
+		{Attributes: map[string]string{PubSubContentTypeAttribute: "application/json"}}:              PubSubEncodingJSON,
+		{Attributes: map[string]string{PubSubSchemaEncodingAttribute: "BINARY"}}:                     PubSubEncodingBinary,
+		{Attributes: map[string]string{PubSubSchemaEncodingAttribute: "JSON"}}:                       PubSubEncodingJSON,
+		{Attributes: map[string]string{PubSubContentTypeAttribute: "text/plain"}}:                    PubSubEncodingUnknown,
+		{Attributes: map[string]string{"my-label": "this-is-value"}}:                                 PubSubEncodingUnknown,
+	}
+
+	for msg, encoding := range candidates {
+		s.Equal(encoding, PubSubEncodingOf(msg), msg.Attributes)
+	}
+}
+
+func (s *PubSubEncodingSuite) TestTranscodeForGateway() {
+	var body []byte
+	var contentType string
+	handler := NewPubSubHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		contentType = r.Header.Get("Content-Type")
+	}),
+		WithPubSubMessageType("echo", &api.EchoMessage{}),
+		WithPubSubEncoding("echo-binary", PubSubEncodingBinary),
+	)
+
+	// binary data of a subscription with a known message type are transcoded to JSON
+	s.Equal(http.StatusOK, s.push(handler, PushMessage{
+		Subscription: "projects/myproject/subscriptions/echo",
+		Message: &PubSubMessage{
+			Data:       s.binaryData,
+			Attributes: map[string]string{PubSubSchemaEncodingAttribute: "BINARY"},
+		},
+	}).Code)
+	s.JSONEq(`{"message":"Hello World"}`, string(body))
+	s.Equal("application/json", contentType)
+
+	// binary data of unknown types are passed through
+	s.Equal(http.StatusOK, s.push(handler, PushMessage{
+		Subscription: "projects/myproject/subscriptions/echo-binary",
+		Message: &PubSubMessage{
+			Data: s.binaryData,
+		},
+	}).Code)
+	s.Equal(s.binaryData, body)
+	s.Equal(ContentTypeProtobuf, contentType)
+
+	// malformed binary data are rejected
+	body = nil
+	rec := s.push(handler, PushMessage{
+		Subscription: "projects/myproject/subscriptions/echo",
+		Message: &PubSubMessage{
+			Data:       []byte("not a proto message"),
+			Attributes: map[string]string{PubSubContentTypeAttribute: ContentTypeProtobuf},
+		},
+	})
+	s.Equal(http.StatusBadRequest, rec.Code)
+	s.Contains(rec.Body.String(), "failed to decode binary data")
+	s.Nil(body)
+}
+
+func (s *PubSubEncodingSuite) TestFullSubscriptionNamesFirst() {
+	var contentType string
+	handler := NewPubSubHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+	}),
+		WithPubSubEncoding("echo", PubSubEncodingBinary),
+		WithPubSubEncoding("projects/myproject/subscriptions/echo", PubSubEncodingJSON),
+	)
+
+	// the options of the full subscription name are always used before the short one
+	for i := 0; i < 10; i++ {
+		s.Equal(http.StatusOK, s.push(handler, PushMessage{
+			Subscription: "projects/myproject/subscriptions/echo",
+			Message:      &PubSubMessage{Data: []byte(`{"message":"Hello World"}`)},
+		}).Code)
+		s.Equal("application/json", contentType)
+	}
+
+	s.Equal(http.StatusOK, s.push(handler, PushMessage{
+		Subscription: "projects/other/subscriptions/echo",
+		Message:      &PubSubMessage{Data: s.binaryData},
+	}).Code)
+	s.Equal(ContentTypeProtobuf, contentType)
+}
+
+func (s *PubSubEncodingSuite) TestDispatchBinaryToRouter() {
+	echoService := &EchoService{Logger: createLogger()}
+	router := NewPubSubRouter(createGrpcServer(echoService), PubSubRoute{Method: "/api.EchoService/Call"})
+	handler := NewPubSubHandler(router)
+
+	var received string
+	echoService.onCall = func(ctx context.Context, m *api.EchoMessage) {
+		received = m.Message
+	}
+
+	rec := s.push(handler, PushMessage{
+		Subscription: "projects/myproject/subscriptions/echo",
+		Message: &PubSubMessage{
+			Data:       s.binaryData,
+			Attributes: map[string]string{PubSubContentTypeAttribute: ContentTypeProtobuf},
+		},
+	})
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal("Hello World", received)
+}
+
+func TestPubSubEncodingSuite(t *testing.T) {
+	suite.Run(t, &PubSubEncodingSuite{})
+}
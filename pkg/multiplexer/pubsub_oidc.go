@@ -0,0 +1,318 @@
+package multiplexer
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrMissingPushToken is returned if the push request does not carry
+	// the Authorization: Bearer token
+	ErrMissingPushToken = errors.New("pubsub: missing bearer token")
+
+	// ErrInvalidPushToken is returned if the push token is malformed or any
+	// of its claims or its signature do not match the verifier configuration
+	ErrInvalidPushToken = errors.New("pubsub: invalid push token")
+
+	// ErrUnknownKey is returned by a KeySource if no key with the given
+	// key ID is known to it
+	ErrUnknownKey = errors.New("pubsub: unknown signing key")
+)
+
+var (
+	// GoogleIssuers are the issuers used by Google in tokens attached
+	// to authenticated push subscriptions
+	GoogleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+)
+
+// KeySource provides public keys used to verify push token signatures
+type KeySource interface {
+	PublicKey(ctx context.Context, keyID string) (crypto.PublicKey, error)
+}
+
+// StaticKeySource is an in-memory KeySource mapping key IDs to public keys
+type StaticKeySource map[string]crypto.PublicKey
+
+// PublicKey returns the key with the given ID
+func (s StaticKeySource) PublicKey(_ context.Context, keyID string) (crypto.PublicKey, error) {
+	key, ok := s[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return key, nil
+}
+
+// jsonWebKey is a single RSA key of the JSON Web Key Set document
+type jsonWebKey struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+// ParseJWKS parses a JSON Web Key Set document (e.g. the one published at
+// https://www.googleapis.com/oauth2/v3/certs) into a StaticKeySource.
+// Only RSA keys are supported, keys of other types are skipped
+func ParseJWKS(data []byte) (StaticKeySource, error) {
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse the key set: %w", err)
+	}
+
+	keys := StaticKeySource{}
+	for _, k := range set.Keys {
+		if k.KeyType != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode modulus of key %s: %w", k.KeyID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode exponent of key %s: %w", k.KeyID, err)
+		}
+
+		keys[k.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+// FileKeySource is a KeySource reading a JSON Web Key Set document from
+// a local file. The file is re-read whenever its modification time changes
+type FileKeySource struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	keys    StaticKeySource
+}
+
+// NewFileKeySource creates a KeySource backed by the JWKS file on the given path
+func NewFileKeySource(path string) *FileKeySource {
+	return &FileKeySource{path: path}
+}
+
+// PublicKey returns the key with the given ID from the key set file
+func (s *FileKeySource) PublicKey(ctx context.Context, keyID string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat the key set: %w", err)
+	}
+
+	if s.keys == nil || !info.ModTime().Equal(s.modTime) {
+		data, err := ioutil.ReadFile(s.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the key set: %w", err)
+		}
+
+		keys, err := ParseJWKS(data)
+		if err != nil {
+			return nil, err
+		}
+
+		s.keys = keys
+		s.modTime = info.ModTime()
+	}
+
+	return s.keys.PublicKey(ctx, keyID)
+}
+
+// PushTokenClaims are the claims of the OIDC token attached by Pub/Sub
+type PushTokenClaims struct {
+	Issuer        string   `json:"iss"`
+	Audience      audience `json:"aud"`
+	Subject       string   `json:"sub"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	IssuedAt      int64    `json:"iat"`
+	ExpiresAt     int64    `json:"exp"`
+}
+
+// audience is a JWT aud claim which can be either a string or a list of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+
+	return nil
+}
+
+func (a audience) contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PushTokenVerifier verifies the OIDC token that Pub/Sub attaches to pushes
+// of authenticated push subscriptions in the Authorization: Bearer header
+type PushTokenVerifier struct {
+	// Keys are used to verify the token signatures
+	Keys KeySource
+	// Audience is the expected aud claim. If empty, the URL of the incoming
+	// request is expected, which is the Pub/Sub default
+	Audience string
+	// Email is the expected service account email of the push subscription.
+	// If empty, any verified email is accepted
+	Email string
+	// Issuers are the accepted iss claims, GoogleIssuers are used if empty
+	Issuers []string
+	// Leeway is the tolerated clock skew when validating expiry
+	Leeway time.Duration
+	// Now returns the current time, time.Now is used if nil
+	Now func() time.Time
+}
+
+// Verify validates the bearer token of the given request and returns its claims
+func (v *PushTokenVerifier) Verify(r *http.Request) (*PushTokenClaims, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, ErrMissingPushToken
+	}
+
+	aud := v.Audience
+	if aud == "" {
+		aud = requestURL(r)
+	}
+
+	return v.VerifyToken(r.Context(), strings.TrimPrefix(auth, "Bearer "), aud)
+}
+
+// VerifyToken validates the given raw token against the expected audience
+func (v *PushTokenVerifier) VerifyToken(ctx context.Context, token, aud string) (*PushTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidPushToken)
+	}
+
+	header := struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}{}
+	if err := decodeTokenSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header: %v", ErrInvalidPushToken, err)
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidPushToken, header.Algorithm)
+	}
+
+	key, err := v.Keys.PublicKey(ctx, header.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPushToken, err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: key %s is not an RSA key", ErrInvalidPushToken, header.KeyID)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature: %v", ErrInvalidPushToken, err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidPushToken)
+	}
+
+	claims := &PushTokenClaims{}
+	if err := decodeTokenSegment(parts[1], claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims: %v", ErrInvalidPushToken, err)
+	}
+
+	return claims, v.validateClaims(claims, aud)
+}
+
+func (v *PushTokenVerifier) validateClaims(claims *PushTokenClaims, aud string) error {
+	issuers := v.Issuers
+	if len(issuers) == 0 {
+		issuers = GoogleIssuers
+	}
+	if !audience(issuers).contains(claims.Issuer) {
+		return fmt.Errorf("%w: unexpected issuer %s", ErrInvalidPushToken, claims.Issuer)
+	}
+
+	if !claims.Audience.contains(aud) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidPushToken)
+	}
+
+	if !claims.EmailVerified || (v.Email != "" && claims.Email != v.Email) {
+		return fmt.Errorf("%w: unexpected email %s", ErrInvalidPushToken, claims.Email)
+	}
+
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+	if now().Add(-v.Leeway).After(time.Unix(claims.ExpiresAt, 0)) {
+		return fmt.Errorf("%w: token expired", ErrInvalidPushToken)
+	}
+	if now().Add(v.Leeway).Before(time.Unix(claims.IssuedAt, 0)) {
+		return fmt.Errorf("%w: token used before issued", ErrInvalidPushToken)
+	}
+
+	return nil
+}
+
+func decodeTokenSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// requestURL reconstructs the URL the request was sent to. Serverless
+// environments terminate TLS in front of the service, so the forwarded
+// protocol is preferred
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
@@ -0,0 +1,201 @@
+package multiplexer
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	testingKeyID     = "test-key"
+	testingAudience  = "https://example.com/echo"
+	testingPushEmail = "pusher@project.iam.gserviceaccount.com"
+)
+
+type PushTokenSuite struct {
+	suite.Suite
+
+	key      *rsa.PrivateKey
+	now      time.Time
+	verifier *PushTokenVerifier
+}
+
+func (s *PushTokenSuite) SetupTest() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	s.NoError(err)
+	s.key = key
+
+	s.now = time.Unix(1600000000, 0)
+	s.verifier = &PushTokenVerifier{
+		Keys:     StaticKeySource{testingKeyID: &key.PublicKey},
+		Audience: testingAudience,
+		Email:    testingPushEmail,
+		Now: func() time.Time {
+			return s.now
+		},
+	}
+}
+
+func (s *PushTokenSuite) validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            "https://accounts.google.com",
+		"aud":            testingAudience,
+		"sub":            "1234567890",
+		"email":          testingPushEmail,
+		"email_verified": true,
+		"iat":            s.now.Add(-time.Minute).Unix(),
+		"exp":            s.now.Add(time.Hour).Unix(),
+	}
+}
+
+func signTestingToken(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (s *PushTokenSuite) TestVerifyToken() {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	s.NoError(err)
+
+	claims := func(mutate func(c map[string]interface{})) map[string]interface{} {
+		c := s.validClaims()
+		mutate(c)
+		return c
+	}
+
+	candidates := map[string]bool{
+		signTestingToken(s.key, testingKeyID, s.validClaims()): true,
+		signTestingToken(s.key, testingKeyID, claims(func(c map[string]interface{}) {
+			c["aud"] = []string{"https://other.com", testingAudience}
+		})): true,
+		// expired token
+		signTestingToken(s.key, testingKeyID, claims(func(c map[string]interface{}) {
+			c["exp"] = s.now.Add(-time.Minute).Unix()
+		})): false,
+		// audience of a different push endpoint
+		signTestingToken(s.key, testingKeyID, claims(func(c map[string]interface{}) {
+			c["aud"] = "https://other.com"
+		})): false,
+		// different service account
+		signTestingToken(s.key, testingKeyID, claims(func(c map[string]interface{}) {
+			c["email"] = "attacker@project.iam.gserviceaccount.com"
+		})): false,
+		signTestingToken(s.key, testingKeyID, claims(func(c map[string]interface{}) {
+			c["email_verified"] = false
+		})): false,
+		signTestingToken(s.key, testingKeyID, claims(func(c map[string]interface{}) {
+			c["iss"] = "https://evil.com"
+		})): false,
+		// signed by an unknown key
+		signTestingToken(otherKey, testingKeyID, s.validClaims()): false,
+		signTestingToken(s.key, "unknown-key", s.validClaims()):   false,
+		"not.a-token": false,
+	}
+
+	for token, valid := range candidates {
+		_, err := s.verifier.VerifyToken(context.Background(), token, testingAudience)
+		if valid {
+			s.NoError(err)
+		} else {
+			s.ErrorIs(err, ErrInvalidPushToken)
+		}
+	}
+}
+
+func (s *PushTokenSuite) TestVerifyRequestAudience() {
+	s.verifier.Audience = ""
+	token := signTestingToken(s.key, testingKeyID, s.validClaims())
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/echo", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("Authorization", "Bearer "+token)
+	_, err := s.verifier.Verify(req)
+	s.NoError(err)
+
+	req = httptest.NewRequest(http.MethodPost, "http://example.com/other", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("Authorization", "Bearer "+token)
+	_, err = s.verifier.Verify(req)
+	s.ErrorIs(err, ErrInvalidPushToken)
+
+	_, err = s.verifier.Verify(httptest.NewRequest(http.MethodPost, "http://example.com/echo", nil))
+	s.ErrorIs(err, ErrMissingPushToken)
+}
+
+func (s *PushTokenSuite) TestFileKeySource() {
+	jwks, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kid": testingKeyID,
+				"kty": "RSA",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+			},
+		},
+	})
+	s.NoError(err)
+
+	path := filepath.Join(s.T().TempDir(), "jwks.json")
+	s.NoError(ioutil.WriteFile(path, jwks, os.ModePerm))
+
+	s.verifier.Keys = NewFileKeySource(path)
+	_, err = s.verifier.VerifyToken(context.Background(), signTestingToken(s.key, testingKeyID, s.validClaims()), testingAudience)
+	s.NoError(err)
+}
+
+func (s *PushTokenSuite) TestPubSubHandlerRejectsUnverified() {
+	reqBody, err := json.Marshal(goldenPubSubMessageJSON)
+	s.NoError(err)
+
+	handled := false
+	handler := NewPubSubHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled = true
+	}), WithPubSubTokenVerifier(s.verifier))
+
+	candidates := map[string]int{
+		"":                 http.StatusUnauthorized,
+		"Bearer malformed": http.StatusUnauthorized,
+		"Bearer " + signTestingToken(s.key, testingKeyID, s.validClaims()): http.StatusOK,
+	}
+
+	for auth, status := range candidates {
+		handled = false
+
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/echo", bytes.NewReader(reqBody))
+		req.Header.Set("User-Agent", "APIs-Google; (+https://developers.google.com/webmasters/APIs-Google.html)")
+		req.Header.Set("Authorization", auth)
+
+		rec := httptest.NewRecorder()
+		s.True(handler(rec, req))
+		s.Equal(status, rec.Code)
+		s.Equal(status == http.StatusOK, handled)
+	}
+}
+
+func TestPushTokenSuite(t *testing.T) {
+	suite.Run(t, &PushTokenSuite{})
+}
@@ -0,0 +1,152 @@
+package multiplexer
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// OrderingObserver is notified about every change of the queue depth of an ordering
+// key, e.g. to export it as a metric. The depth includes the message being handled
+type OrderingObserver func(key string, depth int)
+
+// OrderingQueue serializes handling of messages sharing the same ordering key, while
+// messages with distinct keys are handled in parallel. Messages of the same key are
+// handled in the order they arrived
+type OrderingQueue struct {
+	mu       sync.Mutex
+	keys     map[string]*orderingKey
+	observer OrderingObserver
+}
+
+type orderingKey struct {
+	depth   int
+	busy    bool
+	waiters []chan struct{}
+}
+
+// NewOrderingQueue creates a new OrderingQueue. The observer is optional
+func NewOrderingQueue(observer OrderingObserver) *OrderingQueue {
+	return &OrderingQueue{
+		keys:     map[string]*orderingKey{},
+		observer: observer,
+	}
+}
+
+// Acquire blocks until all messages of the key that arrived earlier are handled. The
+// returned function must be called once the message is handled
+func (q *OrderingQueue) Acquire(ctx context.Context, key string) (func(), error) {
+	q.mu.Lock()
+	k, ok := q.keys[key]
+	if !ok {
+		k = &orderingKey{}
+		q.keys[key] = k
+	}
+	k.depth++
+	q.notify(key, k.depth)
+
+	release := func() {
+		q.release(key)
+	}
+
+	if !k.busy {
+		k.busy = true
+		q.mu.Unlock()
+		return release, nil
+	}
+
+	ch := make(chan struct{})
+	k.waiters = append(k.waiters, ch)
+	q.mu.Unlock()
+
+	select {
+	case <-ch:
+		return release, nil
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	for i, w := range k.waiters {
+		if w == ch {
+			k.waiters = append(k.waiters[:i], k.waiters[i+1:]...)
+			k.depth--
+			q.notify(key, k.depth)
+			q.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+	q.mu.Unlock()
+
+	// the turn was granted while the context was cancelled, so it is passed on
+	release()
+	return nil, ctx.Err()
+}
+
+func (q *OrderingQueue) release(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	k := q.keys[key]
+	k.depth--
+	q.notify(key, k.depth)
+
+	if len(k.waiters) > 0 {
+		next := k.waiters[0]
+		k.waiters = k.waiters[1:]
+		close(next)
+		return
+	}
+
+	k.busy = false
+	if k.depth == 0 {
+		delete(q.keys, key)
+	}
+}
+
+func (q *OrderingQueue) notify(key string, depth int) {
+	if q.observer != nil {
+		q.observer(key, depth)
+	}
+}
+
+// Depth returns the number of messages of the key that are handled or waiting
+func (q *OrderingQueue) Depth(key string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if k, ok := q.keys[key]; ok {
+		return k.depth
+	}
+
+	return 0
+}
+
+// Depths returns the queue depths of all keys with messages being handled
+func (q *OrderingQueue) Depths() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	depths := make(map[string]int, len(q.keys))
+	for key, k := range q.keys {
+		depths[key] = k.depth
+	}
+
+	return depths
+}
+
+// WithPubSubOrdering serializes handling of pushes with the same ordering key by the
+// given queue. Pushes without an ordering key are not queued
+func WithPubSubOrdering(queue *OrderingQueue) PubSubOption {
+	return func(o *pubSubOptions) {
+		o.ordering = queue
+	}
+}
+
+// acquireOrdering waits for the turn of the message in its ordering key queue
+func (o *pubSubOptions) acquireOrdering(r *http.Request, psmsg *PushMessage) (func(), error) {
+	if o.ordering == nil || psmsg.Message == nil || psmsg.Message.OrderingKey == "" {
+		return func() {}, nil
+	}
+
+	return o.ordering.Acquire(r.Context(), psmsg.Message.OrderingKey)
+}
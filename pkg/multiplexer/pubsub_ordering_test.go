@@ -0,0 +1,156 @@
+package multiplexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type PubSubOrderingSuite struct {
+	suite.Suite
+
+	mu     sync.Mutex
+	depths map[string][]int
+	queue  *OrderingQueue
+}
+
+func (s *PubSubOrderingSuite) SetupTest() {
+	s.depths = map[string][]int{}
+	s.queue = NewOrderingQueue(func(key string, depth int) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.depths[key] = append(s.depths[key], depth)
+	})
+}
+
+func (s *PubSubOrderingSuite) TestOrderingQueue() {
+	ctx := context.Background()
+
+	first, err := s.queue.Acquire(ctx, "a")
+	s.NoError(err)
+
+	// distinct keys are not blocked
+	other, err := s.queue.Acquire(ctx, "b")
+	s.NoError(err)
+	other()
+
+	order := make(chan int, 3)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			release, err := s.queue.Acquire(ctx, "a")
+			s.NoError(err)
+			order <- i
+			release()
+		}(i)
+
+		// wait for the waiter to be queued, so the arrival order is deterministic
+		s.Eventually(func() bool {
+			return s.queue.Depth("a") == i+2
+		}, time.Second, time.Millisecond)
+	}
+
+	s.Equal(map[string]int{"a": 4}, s.queue.Depths())
+	first()
+	wg.Wait()
+	close(order)
+
+	received := []int{}
+	for i := range order {
+		received = append(received, i)
+	}
+	s.Equal([]int{0, 1, 2}, received)
+
+	s.Equal(0, s.queue.Depth("a"))
+	s.Empty(s.queue.Depths())
+	s.Equal([]int{1, 2, 3, 4, 3, 2, 1, 0}, s.depths["a"])
+	s.Equal([]int{1, 0}, s.depths["b"])
+}
+
+func (s *PubSubOrderingSuite) TestOrderingQueueCancel() {
+	first, err := s.queue.Acquire(context.Background(), "a")
+	s.NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	_, err = s.queue.Acquire(ctx, "a")
+	s.Equal(context.DeadlineExceeded, err)
+	s.Equal(1, s.queue.Depth("a"))
+
+	first()
+	s.Equal(0, s.queue.Depth("a"))
+}
+
+func (s *PubSubOrderingSuite) TestPubSubHandlerOrdering() {
+	running := map[string]int{}
+	maxRunning := map[string]int{}
+	total, maxTotal := 0, 0
+
+	handler := NewPubSubHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(gatewayMetadataPrefix + PubSubMetaAttributeHeader(PubSubMetaOrderingKey))
+
+		s.mu.Lock()
+		running[key]++
+		total++
+		if running[key] > maxRunning[key] {
+			maxRunning[key] = running[key]
+		}
+		if total > maxTotal {
+			maxTotal = total
+		}
+		s.mu.Unlock()
+
+		time.Sleep(time.Millisecond * 20)
+
+		s.mu.Lock()
+		running[key]--
+		total--
+		s.mu.Unlock()
+	}), WithPubSubOrdering(s.queue))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 6; i++ {
+		body, err := json.Marshal(PushMessage{
+			Subscription: "projects/myproject/subscriptions/echo",
+			Message: &PubSubMessage{
+				Data:        []byte("{}"),
+				MessageID:   strconv.Itoa(i),
+				OrderingKey: "key-" + strconv.Itoa(i%2),
+			},
+		})
+		s.NoError(err)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req := httptest.NewRequest(http.MethodPost, "http://localhost/echo", bytes.NewReader(body))
+			req.Header.Set("User-Agent", "APIs-Google; (+https://developers.google.com/webmasters/APIs-Google.html)")
+
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			s.Equal(http.StatusOK, rec.Code)
+		}()
+	}
+	wg.Wait()
+
+	s.Equal(map[string]int{"key-0": 1, "key-1": 1}, maxRunning)
+	s.Equal(2, maxTotal)
+	s.Empty(s.queue.Depths())
+}
+
+func TestPubSubOrderingSuite(t *testing.T) {
+	suite.Run(t, &PubSubOrderingSuite{})
+}
@@ -0,0 +1,182 @@
+package multiplexer
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Publisher publishes messages to Pub/Sub topics
+type Publisher interface {
+	// Publish publishes the message to the topic (in the projects/{project}/topics/{name}
+	// form) and returns the ID of the published message
+	Publish(ctx context.Context, topic string, msg *PubSubMessage) (string, error)
+}
+
+// MemoryPublisher is an in-memory Publisher, e.g. for tests and local development.
+// Messages published to topics with a subscriber attached by Subscribe are delivered
+// to the subscriber
+type MemoryPublisher struct {
+	mu          sync.Mutex
+	messages    map[string][]*PubSubMessage
+	subscribers map[string][]*MemorySubscriber
+	lastID      int
+}
+
+// NewMemoryPublisher creates an in-memory Publisher
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{
+		messages:    map[string][]*PubSubMessage{},
+		subscribers: map[string][]*MemorySubscriber{},
+	}
+}
+
+// Publish stores a copy of the message and delivers it to the subscribers of the topic
+func (p *MemoryPublisher) Publish(_ context.Context, topic string, msg *PubSubMessage) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lastID++
+	stored := copyPubSubMessage(msg)
+	stored.MessageID = strconv.Itoa(p.lastID)
+	p.messages[topic] = append(p.messages[topic], stored)
+
+	for _, sub := range p.subscribers[topic] {
+		sub.Publish(copyPubSubMessage(stored))
+	}
+
+	return stored.MessageID, nil
+}
+
+// copyPubSubMessage copies the message along with its attributes, so the copy can be
+// modified without affecting the original message
+func copyPubSubMessage(msg *PubSubMessage) *PubSubMessage {
+	copied := *msg
+	if msg.Attributes != nil {
+		copied.Attributes = make(map[string]string, len(msg.Attributes))
+		for k, v := range msg.Attributes {
+			copied.Attributes[k] = v
+		}
+	}
+
+	return &copied
+}
+
+// Subscribe attaches the subscriber to the topic
+func (p *MemoryPublisher) Subscribe(topic string, sub *MemorySubscriber) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.subscribers[topic] = append(p.subscribers[topic], sub)
+}
+
+// Messages returns the messages published to the topic
+func (p *MemoryPublisher) Messages(topic string) []*PubSubMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]*PubSubMessage{}, p.messages[topic]...)
+}
+
+// PublishRoute configures the PublishInterceptor to publish messages of the method
+type PublishRoute struct {
+	// Method is the full name of the GRPC method, e.g. /api.EchoService/Call
+	Method string
+	// Topic is the full name of the topic, e.g. projects/myproject/topics/echo
+	Topic string
+	// Request publishes the request of the method instead of its response
+	Request bool
+	// Encoding of the published data, JSON by default. Binary encoded messages
+	// are published with the ContentTypeProtobuf content type attribute
+	Encoding PubSubEncoding
+}
+
+// PublishInterceptor publishes the response (or request) of every successful call of
+// the configured methods through the publisher. The x-pubsub-* metadata of the call
+// (e.g. set by the PubSubHandler) are carried over into the message attributes for
+// correlation, with the x-pubsub-attr-* metadata restored to their original attribute
+// names, except for the encoding attributes set by the route. Calls are failed with the Unavailable code if publishing fails, so pushed
+// messages are redelivered
+func PublishInterceptor(publisher Publisher, routes ...PublishRoute) grpc.UnaryServerInterceptor {
+	byMethod := map[string][]PublishRoute{}
+	for _, route := range routes {
+		byMethod[route.Method] = append(byMethod[route.Method], route)
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		res, err := handler(ctx, req)
+		if err != nil {
+			return res, err
+		}
+
+		for _, route := range byMethod[info.FullMethod] {
+			msg := res
+			if route.Request {
+				msg = req
+			}
+
+			if err := publishMessage(ctx, publisher, route, msg); err != nil {
+				return nil, status.Errorf(codes.Unavailable, "pubsub: failed to publish to %s: %v", route.Topic, err)
+			}
+		}
+
+		return res, nil
+	}
+}
+
+// publishMessage encodes the proto message and publishes it with the correlation attributes
+func publishMessage(ctx context.Context, publisher Publisher, route PublishRoute, msg interface{}) error {
+	pmsg, ok := msg.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "pubsub: %T is not a proto message", msg)
+	}
+
+	psmsg := &PubSubMessage{Attributes: correlationAttributes(ctx)}
+
+	var err error
+	if route.Encoding == PubSubEncodingBinary {
+		psmsg.Data, err = proto.Marshal(pmsg)
+		psmsg.Attributes[PubSubContentTypeAttribute] = ContentTypeProtobuf
+	} else {
+		psmsg.Data, err = protojson.Marshal(pmsg)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = publisher.Publish(ctx, route.Topic, psmsg)
+	return err
+}
+
+// correlationAttributes returns the x-pubsub-* metadata of the incoming call as attributes,
+// except for the attributes declaring the encoding of the message
+func correlationAttributes(ctx context.Context) map[string]string {
+	attrs := map[string]string{}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, vv := range md {
+		if len(vv) == 0 {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(k, PubSubAttributeHeader("")):
+			attrs[strings.TrimPrefix(k, PubSubAttributeHeader(""))] = vv[0]
+		case strings.HasPrefix(k, PubSubMetaAttributeHeader("")):
+			attrs[k] = vv[0]
+		}
+	}
+
+	// the encoding of the incoming message does not describe the published data
+	delete(attrs, PubSubContentTypeAttribute)
+	delete(attrs, PubSubSchemaEncodingAttribute)
+
+	return attrs
+}
@@ -0,0 +1,109 @@
+package multiplexer
+
+import (
+	"context"
+	"errors"
+	"github.com/petomalina/xrpc/v2/examples/api"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"testing"
+)
+
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(context.Context, string, *PubSubMessage) (string, error) {
+	return "", errors.New("unavailable")
+}
+
+type PubSubPublishSuite struct {
+	suite.Suite
+
+	publisher *MemoryPublisher
+	conn      *LocalConn
+}
+
+func (s *PubSubPublishSuite) SetupTest() {
+	s.publisher = NewMemoryPublisher()
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(PublishInterceptor(s.publisher,
+		PublishRoute{Method: "/api.EchoService/Call", Topic: "projects/myproject/topics/responses"},
+		PublishRoute{Method: "/api.EchoService/Call", Topic: "projects/myproject/topics/requests", Request: true, Encoding: PubSubEncodingBinary},
+	)))
+	api.RegisterEchoServiceServer(server, &EchoService{Logger: createLogger()})
+
+	s.conn = NewLocalConn(server)
+}
+
+func (s *PubSubPublishSuite) TestPublishInterceptor() {
+	sub := NewMemorySubscriber("projects/myproject/subscriptions/responses")
+	s.publisher.Subscribe("projects/myproject/topics/responses", sub)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		PubSubMetaAttributeHeader(PubSubMetaMessageID), "abc12345",
+		PubSubAttributeHeader("trace"), "trace-id",
+		PubSubAttributeHeader(PubSubContentTypeAttribute), ContentTypeProtobuf,
+		PubSubAttributeHeader(PubSubSchemaEncodingAttribute), "BINARY",
+		"x-unrelated", "value",
+	)
+
+	_, err := api.NewEchoServiceClient(s.conn).Call(ctx, &api.EchoMessage{Message: "Hello World"})
+	s.NoError(err)
+
+	responses := s.publisher.Messages("projects/myproject/topics/responses")
+	s.Len(responses, 1)
+	s.JSONEq(`{"message":"Hello World"}`, string(responses[0].Data))
+	// the encoding attributes of the incoming message are not carried over
+	s.Equal(map[string]string{
+		"x-pubsub-message-id": "abc12345",
+		"trace":               "trace-id",
+	}, responses[0].Attributes)
+	s.Equal(1, sub.Len())
+
+	requests := s.publisher.Messages("projects/myproject/topics/requests")
+	s.Len(requests, 1)
+	s.Equal(ContentTypeProtobuf, requests[0].Attributes[PubSubContentTypeAttribute])
+	s.NotContains(requests[0].Attributes, PubSubSchemaEncodingAttribute)
+
+	msg := &api.EchoMessage{}
+	s.NoError(proto.Unmarshal(requests[0].Data, msg))
+	s.Equal("Hello World", msg.Message)
+}
+
+func (s *PubSubPublishSuite) TestMemoryPublisherCopies() {
+	msg := &PubSubMessage{Data: []byte("a"), Attributes: map[string]string{"trace": "trace-id"}}
+
+	id, err := s.publisher.Publish(context.Background(), "projects/myproject/topics/echo", msg)
+	s.NoError(err)
+	s.Equal("1", id)
+	s.Empty(msg.MessageID, "the published message is not modified")
+
+	msg.Attributes["trace"] = "changed"
+	published := s.publisher.Messages("projects/myproject/topics/echo")
+	s.Len(published, 1)
+	s.Equal("1", published[0].MessageID)
+	s.Equal("trace-id", published[0].Attributes["trace"])
+}
+
+func (s *PubSubPublishSuite) TestPublishFailure() {
+	interceptor := PublishInterceptor(failingPublisher{}, PublishRoute{Method: "/api.EchoService/Call", Topic: "projects/myproject/topics/responses"})
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.EchoService/Call"}
+
+	_, err := interceptor(context.Background(), &api.EchoMessage{}, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req, nil
+	})
+	s.Equal(codes.Unavailable, status.Code(err))
+
+	// failed calls are not published
+	_, err = interceptor(context.Background(), &api.EchoMessage{}, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.InvalidArgument, "invalid")
+	})
+	s.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func TestPubSubPublishSuite(t *testing.T) {
+	suite.Run(t, &PubSubPublishSuite{})
+}
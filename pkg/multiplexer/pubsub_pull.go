@@ -0,0 +1,280 @@
+package multiplexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// pubSubPushUserAgent is the user agent of the pushes sent by Pub/Sub, which is
+// recognized by IsPubSubRequest
+const pubSubPushUserAgent = "APIs-Google; (+https://developers.google.com/webmasters/APIs-Google.html)"
+
+// ReceivedMessage is a message pulled from a subscription by the Subscriber
+type ReceivedMessage struct {
+	AckID           string         `json:"ackId"`
+	Message         *PubSubMessage `json:"message"`
+	DeliveryAttempt int            `json:"deliveryAttempt,omitempty"`
+}
+
+// Subscriber pulls messages of a single subscription
+type Subscriber interface {
+	// Subscription returns the full name of the subscription
+	Subscription() string
+	// Pull blocks until at least one message is available and returns at most
+	// max messages
+	Pull(ctx context.Context, max int) ([]*ReceivedMessage, error)
+	// Ack acknowledges the messages, so they are not delivered again
+	Ack(ctx context.Context, ackIDs ...string) error
+	// Nack makes the messages available for redelivery
+	Nack(ctx context.Context, ackIDs ...string) error
+}
+
+// MemorySubscriber is an in-memory Subscriber, e.g. for tests and local development.
+// Messages are added to the subscription by Publish
+type MemorySubscriber struct {
+	mu           sync.Mutex
+	subscription string
+	queue        []*ReceivedMessage
+	outstanding  map[string]*ReceivedMessage
+	available    chan struct{}
+	lastID       int
+}
+
+// NewMemorySubscriber creates an in-memory Subscriber of the given subscription
+func NewMemorySubscriber(subscription string) *MemorySubscriber {
+	return &MemorySubscriber{
+		subscription: subscription,
+		outstanding:  map[string]*ReceivedMessage{},
+		available:    make(chan struct{}, 1),
+	}
+}
+
+// Publish adds the message to the subscription. The message ID is generated if it
+// is not set
+func (s *MemorySubscriber) Publish(msg *PubSubMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	if msg.MessageID == "" {
+		msg.MessageID = strconv.Itoa(s.lastID)
+	}
+
+	s.enqueue(&ReceivedMessage{
+		AckID:   strconv.Itoa(s.lastID),
+		Message: msg,
+	})
+}
+
+// Subscription returns the full name of the subscription
+func (s *MemorySubscriber) Subscription() string {
+	return s.subscription
+}
+
+// Pull blocks until at least one message is available
+func (s *MemorySubscriber) Pull(ctx context.Context, max int) ([]*ReceivedMessage, error) {
+	for {
+		s.mu.Lock()
+		if len(s.queue) > 0 {
+			n := len(s.queue)
+			if max > 0 && n > max {
+				n = max
+			}
+
+			msgs := s.queue[:n:n]
+			s.queue = s.queue[n:]
+			for _, msg := range msgs {
+				msg.DeliveryAttempt++
+				s.outstanding[msg.AckID] = msg
+			}
+			if len(s.queue) > 0 {
+				s.notify()
+			}
+
+			s.mu.Unlock()
+			return msgs, nil
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.available:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Ack acknowledges the messages
+func (s *MemorySubscriber) Ack(_ context.Context, ackIDs ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ackIDs {
+		delete(s.outstanding, id)
+	}
+
+	return nil
+}
+
+// Nack returns the messages to the subscription
+func (s *MemorySubscriber) Nack(_ context.Context, ackIDs ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ackIDs {
+		if msg, ok := s.outstanding[id]; ok {
+			delete(s.outstanding, id)
+			s.enqueue(msg)
+		}
+	}
+
+	return nil
+}
+
+// Len returns the number of messages that were not acknowledged yet
+func (s *MemorySubscriber) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.queue) + len(s.outstanding)
+}
+
+func (s *MemorySubscriber) enqueue(msg *ReceivedMessage) {
+	s.queue = append(s.queue, msg)
+	s.notify()
+}
+
+func (s *MemorySubscriber) notify() {
+	select {
+	case s.available <- struct{}{}:
+	default:
+	}
+}
+
+// PubSubPuller consumes messages from a Subscriber and drives them through the handler
+// (e.g. the multiplexer created by Make) as if they were pushed by Pub/Sub. Messages are
+// acknowledged if the handler responds with a 2xx status and returned for redelivery
+// otherwise. As pulled messages are not signed, the handler must not verify push tokens
+type PubSubPuller struct {
+	subscriber Subscriber
+	handler    http.Handler
+
+	endpoint    string
+	maxMessages int
+}
+
+// PubSubPullOption is an extendable builder for the PubSubPuller options
+type PubSubPullOption func(p *PubSubPuller)
+
+// WithPullEndpoint sets the push endpoint URL the synthesized requests are sent to.
+// It defaults to http://localhost/
+func WithPullEndpoint(endpoint string) PubSubPullOption {
+	return func(p *PubSubPuller) {
+		p.endpoint = endpoint
+	}
+}
+
+// WithPullMaxMessages sets the maximum number of messages pulled and handled
+// concurrently. It defaults to 10
+func WithPullMaxMessages(max int) PubSubPullOption {
+	return func(p *PubSubPuller) {
+		p.maxMessages = max
+	}
+}
+
+// NewPubSubPuller creates a new PubSubPuller of the subscriber, serving the messages
+// by the given handler
+func NewPubSubPuller(subscriber Subscriber, handler http.Handler, opts ...PubSubPullOption) *PubSubPuller {
+	p := &PubSubPuller{
+		subscriber:  subscriber,
+		handler:     handler,
+		endpoint:    "http://localhost/",
+		maxMessages: 10,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Run pulls and handles messages until the context is done or the subscriber fails
+func (p *PubSubPuller) Run(ctx context.Context) error {
+	for {
+		msgs, err := p.subscriber.Pull(ctx, p.maxMessages)
+		if err != nil {
+			return err
+		}
+
+		if err := p.Process(ctx, msgs); err != nil {
+			return err
+		}
+	}
+}
+
+// Process handles the pulled messages concurrently and acknowledges them
+func (p *PubSubPuller) Process(ctx context.Context, msgs []*ReceivedMessage) error {
+	acks := make([]bool, len(msgs))
+	wg := sync.WaitGroup{}
+
+	for i, msg := range msgs {
+		wg.Add(1)
+		go func(i int, msg *ReceivedMessage) {
+			defer wg.Done()
+			acks[i] = p.serve(ctx, msg)
+		}(i, msg)
+	}
+	wg.Wait()
+
+	acked, nacked := []string{}, []string{}
+	for i, msg := range msgs {
+		if acks[i] {
+			acked = append(acked, msg.AckID)
+		} else {
+			nacked = append(nacked, msg.AckID)
+		}
+	}
+
+	if len(acked) > 0 {
+		if err := p.subscriber.Ack(ctx, acked...); err != nil {
+			return err
+		}
+	}
+	if len(nacked) > 0 {
+		if err := p.subscriber.Nack(ctx, nacked...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// serve synthesizes the push request of the message and returns true if the handler
+// acknowledged it
+func (p *PubSubPuller) serve(ctx context.Context, msg *ReceivedMessage) bool {
+	body, err := json.Marshal(PushMessage{
+		Message:         msg.Message,
+		Subscription:    p.subscriber.Subscription(),
+		DeliveryAttempt: msg.DeliveryAttempt,
+	})
+	if err != nil {
+		return false
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("User-Agent", pubSubPushUserAgent)
+	req.Header.Set("Content-Type", "application/json")
+
+	rec := newResponseRecorder()
+	p.handler.ServeHTTP(rec, req)
+
+	return rec.status >= 200 && rec.status <= 299
+}
@@ -0,0 +1,92 @@
+package multiplexer
+
+import (
+	"context"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+type PubSubPullSuite struct {
+	suite.Suite
+}
+
+func (s *PubSubPullSuite) TestMemorySubscriber() {
+	ctx := context.Background()
+	sub := NewMemorySubscriber("projects/myproject/subscriptions/echo")
+
+	sub.Publish(&PubSubMessage{Data: []byte("a")})
+	sub.Publish(&PubSubMessage{Data: []byte("b"), MessageID: "custom"})
+
+	msgs, err := sub.Pull(ctx, 1)
+	s.NoError(err)
+	s.Len(msgs, 1)
+	s.Equal("1", msgs[0].Message.MessageID)
+	s.Equal(1, msgs[0].DeliveryAttempt)
+
+	s.NoError(sub.Nack(ctx, msgs[0].AckID))
+	s.Equal(2, sub.Len())
+
+	msgs, err = sub.Pull(ctx, 10)
+	s.NoError(err)
+	s.Len(msgs, 2)
+	s.Equal("custom", msgs[0].Message.MessageID)
+	s.Equal("1", msgs[1].Message.MessageID)
+	s.Equal(2, msgs[1].DeliveryAttempt)
+
+	s.NoError(sub.Ack(ctx, msgs[0].AckID, msgs[1].AckID))
+	s.Equal(0, sub.Len())
+
+	// pulling an empty subscription blocks until the context is done
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond*10)
+	defer cancel()
+
+	_, err = sub.Pull(ctx, 10)
+	s.Equal(context.DeadlineExceeded, err)
+}
+
+func (s *PubSubPullSuite) TestPuller() {
+	sub := NewMemorySubscriber("projects/myproject/subscriptions/echo")
+	sub.Publish(&PubSubMessage{Data: []byte("ok")})
+	sub.Publish(&PubSubMessage{Data: []byte("fail")})
+
+	mu := sync.Mutex{}
+	handled := map[string][]string{}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	mux := Make(nil, PubSubHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempt := r.Header.Get(gatewayMetadataPrefix + PubSubMetaAttributeHeader(PubSubMetaDeliveryAttempt))
+		handled[string(data)] = append(handled[string(data)], attempt)
+		s.Equal("projects/myproject/subscriptions/echo", r.Header.Get(gatewayMetadataPrefix+PubSubMetaAttributeHeader(PubSubMetaSubscription)))
+
+		// the failing message is acknowledged on its second attempt
+		if string(data) == "fail" && attempt == "1" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if len(handled["fail"]) == 2 {
+			cancel()
+		}
+	})))
+
+	err := NewPubSubPuller(sub, mux, WithPullMaxMessages(1)).Run(ctx)
+	s.Equal(context.Canceled, err)
+
+	s.Equal(map[string][]string{
+		"ok":   {"1"},
+		"fail": {"1", "2"},
+	}, handled)
+	s.Equal(0, sub.Len())
+}
+
+func TestPubSubPullSuite(t *testing.T) {
+	suite.Run(t, &PubSubPullSuite{})
+}
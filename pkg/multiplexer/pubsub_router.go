@@ -0,0 +1,174 @@
+package multiplexer
+
+import (
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// PubSubRoute routes the unwrapped Pub/Sub pushes to a grpc method. A push is routed
+// if it passes all Selectors of the route, a route without Selectors matches all pushes
+type PubSubRoute struct {
+	// Method is the full name of the grpc method, e.g. /api.EchoService/Call
+	Method string
+	// Selectors filter the pushes routed to the Method, see PubSubSubscription,
+	// PubSubAttribute and PubSubOrderingKey
+	Selectors []Selector
+}
+
+// PubSubSubscription selects pushes of the given subscription. Both the full name
+// (projects/myproject/subscriptions/mysubscription) and the short name are accepted
+func PubSubSubscription(name string) Selector {
+	return func(r *http.Request) bool {
+		sub := r.Header.Get(gatewayMetadataPrefix + PubSubMetaAttributeHeader(PubSubMetaSubscription))
+		return subscriptionMatches(sub, name)
+	}
+}
+
+// PubSubAttribute selects pushes with the message attribute of the given value
+func PubSubAttribute(key, value string) Selector {
+	return func(r *http.Request) bool {
+		vv, ok := r.Header[http.CanonicalHeaderKey(gatewayMetadataPrefix+PubSubAttributeHeader(key))]
+		return ok && len(vv) > 0 && vv[0] == value
+	}
+}
+
+// PubSubOrderingKey selects pushes with the ordering key matching the given
+// pattern. The pattern syntax is the same as for path.Match
+func PubSubOrderingKey(pattern string) Selector {
+	return func(r *http.Request) bool {
+		key := r.Header.Get(gatewayMetadataPrefix + PubSubMetaAttributeHeader(PubSubMetaOrderingKey))
+		matched, err := path.Match(pattern, key)
+		return err == nil && matched
+	}
+}
+
+// PubSubRouter is a http.Handler that invokes grpc methods directly on the grpc server,
+// without the grpc-gateway in between. It is meant to be wrapped by the PubSubHandler,
+// so a single push endpoint can fan the messages into many grpc methods based on the routes.
+//
+// The message data is decoded as the JSON representation of the method input, or as
+// the binary protobuf if the request has the ContentTypeProtobuf content type, and the
+// Grpc-Metadata- headers are passed as the incoming grpc metadata, the same way as the
+// grpc-gateway would do
+type PubSubRouter struct {
+	conn   grpc.ClientConnInterface
+	routes []PubSubRoute
+}
+
+// NewPubSubRouter creates a router calling methods of the given grpc server
+func NewPubSubRouter(server http.Handler, routes ...PubSubRoute) *PubSubRouter {
+	return &PubSubRouter{
+		conn:   NewLocalConn(server),
+		routes: routes,
+	}
+}
+
+// ServeHTTP invokes the grpc method of the first route matching the request
+func (p *PubSubRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, ok := p.match(r)
+	if !ok {
+		writeGatewayStatus(w, status.New(codes.NotFound, "pubsub: no route matches the message"))
+		return
+	}
+
+	desc, err := findMethodDescriptor(route.Method)
+	if err != nil {
+		writeGatewayStatus(w, status.New(codes.Unimplemented, err.Error()))
+		return
+	}
+	if desc.IsStreamingClient() || desc.IsStreamingServer() {
+		writeGatewayStatus(w, status.Newf(codes.Unimplemented, "pubsub: %s is a streaming method", route.Method))
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeGatewayStatus(w, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+
+	in := newMessage(desc.Input())
+	if len(body) > 0 {
+		if isProtobufContentType(r.Header.Get("Content-Type")) {
+			err = proto.Unmarshal(body, in)
+		} else {
+			err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, in)
+		}
+		if err != nil {
+			writeGatewayStatus(w, status.New(codes.InvalidArgument, err.Error()))
+			return
+		}
+	}
+
+	out := newMessage(desc.Output())
+	ctx := metadata.NewOutgoingContext(r.Context(), gatewayMetadata(r.Header))
+	if err := p.conn.Invoke(ctx, route.Method, in, out); err != nil {
+		writeGatewayStatus(w, status.Convert(err))
+		return
+	}
+
+	data, err := protojson.Marshal(out)
+	if err != nil {
+		writeGatewayStatus(w, status.New(codes.Internal, err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+func (p *PubSubRouter) match(r *http.Request) (PubSubRoute, bool) {
+	for _, route := range p.routes {
+		matched := true
+		for _, s := range route.Selectors {
+			if !s(r) {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return route, true
+		}
+	}
+
+	return PubSubRoute{}, false
+}
+
+// gatewayMetadata collects the headers prefixed by Grpc-Metadata- into the grpc metadata
+func gatewayMetadata(h http.Header) metadata.MD {
+	md := metadata.MD{}
+	for k, vv := range h {
+		if !strings.HasPrefix(k, gatewayMetadataPrefix) {
+			continue
+		}
+
+		md.Append(strings.TrimPrefix(k, gatewayMetadataPrefix), vv...)
+	}
+
+	return md
+}
+
+// writeGatewayStatus writes the grpc status in the same format the grpc-gateway uses
+// for errors, so clients see the same responses from the gateway and from handlers
+// calling grpc methods directly
+func writeGatewayStatus(w http.ResponseWriter, st *status.Status) {
+	data, err := protojson.Marshal(st.Proto())
+	if err != nil {
+		data = []byte(`{"code":13,"message":"failed to marshal the status"}`)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(runtime.HTTPStatusFromCode(st.Code()))
+	_, _ = w.Write(data)
+}
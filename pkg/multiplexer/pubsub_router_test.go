@@ -0,0 +1,130 @@
+package multiplexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/grpc-ecosystem/go-grpc-middleware/util/metautils"
+	"github.com/petomalina/xrpc/v2/examples/api"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type PubSubRouterSuite struct {
+	suite.Suite
+
+	echoService *EchoService
+	handler     Handler
+}
+
+func (s *PubSubRouterSuite) SetupTest() {
+	s.echoService = &EchoService{Logger: createLogger()}
+	grpcServer := createGrpcServer(s.echoService)
+
+	s.handler = PubSubHandler(NewPubSubRouter(grpcServer,
+		PubSubRoute{
+			Method:    "/api.EchoService/Call",
+			Selectors: []Selector{PubSubSubscription("echo")},
+		},
+		PubSubRoute{
+			Method:    "/api.EchoService/Call",
+			Selectors: []Selector{PubSubAttribute("type", "echo")},
+		},
+		PubSubRoute{
+			Method:    "/api.EchoService/Call",
+			Selectors: []Selector{PubSubOrderingKey("echo-*")},
+		},
+		PubSubRoute{
+			Method:    "/api.EchoService/Unknown",
+			Selectors: []Selector{PubSubSubscription("unknown")},
+		},
+	))
+}
+
+func (s *PubSubRouterSuite) push(msg PushMessage) *httptest.ResponseRecorder {
+	body, err := json.Marshal(msg)
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/_pubsub", bytes.NewReader(body))
+	req.Header.Set("User-Agent", "APIs-Google; (+https://developers.google.com/webmasters/APIs-Google.html)")
+
+	rec := httptest.NewRecorder()
+	s.True(s.handler(rec, req))
+
+	return rec
+}
+
+func (s *PubSubRouterSuite) TestRoutes() {
+	message := func(subscription string, attrs map[string]string, orderingKey string) PushMessage {
+		return PushMessage{
+			Subscription: subscription,
+			Message: &PubSubMessage{
+				Data:        []byte(`{"message":"Hello World"}`),
+				Attributes:  attrs,
+				MessageID:   "abc12345",
+				OrderingKey: orderingKey,
+			},
+		}
+	}
+
+	candidates := map[string]struct {
+		msg    PushMessage
+		status int
+	}{
+		"subscription": {
+			msg:    message("projects/myproject/subscriptions/echo", nil, ""),
+			status: http.StatusOK,
+		},
+		"attribute": {
+			msg:    message("projects/myproject/subscriptions/other", map[string]string{"type": "echo"}, ""),
+			status: http.StatusOK,
+		},
+		"ordering key": {
+			msg:    message("projects/myproject/subscriptions/other", nil, "echo-1"),
+			status: http.StatusOK,
+		},
+		"no route": {
+			msg:    message("projects/myproject/subscriptions/other", map[string]string{"type": "other"}, "other-1"),
+			status: http.StatusNotFound,
+		},
+		"unknown method": {
+			msg:    message("projects/myproject/subscriptions/unknown", nil, ""),
+			status: http.StatusNotImplemented,
+		},
+	}
+
+	for name, c := range candidates {
+		called := false
+		s.echoService.onCall = func(ctx context.Context, m *api.EchoMessage) {
+			called = true
+
+			s.Equal("Hello World", m.Message)
+			s.Equal(c.msg.Subscription, metautils.ExtractIncoming(ctx).Get(PubSubMetaAttributeHeader(PubSubMetaSubscription)))
+		}
+
+		rec := s.push(c.msg)
+		s.Equal(c.status, rec.Code, name)
+		s.Equal(c.status == http.StatusOK, called, name)
+
+		if c.status == http.StatusOK {
+			s.JSONEq(`{"message":"Hello World"}`, rec.Body.String())
+		}
+	}
+}
+
+func (s *PubSubRouterSuite) TestInvalidData() {
+	rec := s.push(PushMessage{
+		Subscription: "projects/myproject/subscriptions/echo",
+		Message: &PubSubMessage{
+			Data: []byte(`{"unknown":`),
+		},
+	})
+
+	s.Equal(http.StatusBadRequest, rec.Code)
+}
+
+func TestPubSubRouterSuite(t *testing.T) {
+	suite.Run(t, &PubSubRouterSuite{})
+}
@@ -0,0 +1,179 @@
+package multiplexer
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+var (
+	// ErrPubSubBodyTooLarge is returned when the push body exceeds the limit set by
+	// WithPubSubMaxBodySize
+	ErrPubSubBodyTooLarge = errors.New("pubsub: push body exceeds the size limit")
+	// ErrPubSubMissingMessage is returned when the push envelope has no message
+	ErrPubSubMissingMessage = errors.New("pubsub: envelope without a message")
+	// ErrPubSubMissingField is returned when the push envelope misses a field required
+	// by WithPubSubRequiredFields
+	ErrPubSubMissingField = errors.New("pubsub: envelope without a required field")
+	// ErrPubSubSubscriptionNotAllowed is returned when the push envelope comes from a
+	// subscription that is not allowed by WithPubSubAllowedSubscriptions
+	ErrPubSubSubscriptionNotAllowed = errors.New("pubsub: subscription is not allowed")
+)
+
+// PubSubError is the structured error the PubSubHandler responds with when it rejects
+// the push before it reaches the handler. It is sent in the 'error' field of the
+// response document:
+//
+//	{
+//	  "error": {
+//	    "status": 400,
+//	    "field": "message-id",
+//	    "message": "pubsub: envelope without a required field"
+//	  }
+//	}
+type PubSubError struct {
+	// Status is the http status the push was rejected with
+	Status int `json:"status"`
+	// Field is the envelope field that failed the validation, if any
+	Field PubSubMetaAttribute `json:"field,omitempty"`
+	// Message describes the error
+	Message string `json:"message"`
+
+	err error
+}
+
+// Error returns the message of the error
+func (e *PubSubError) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the underlying error, so it can be matched by errors.Is
+func (e *PubSubError) Unwrap() error {
+	return e.err
+}
+
+func newPubSubError(status int, field PubSubMetaAttribute, err error) *PubSubError {
+	return &PubSubError{
+		Status:  status,
+		Field:   field,
+		Message: err.Error(),
+		err:     err,
+	}
+}
+
+// writePubSubError responds with the structured error. Errors other than PubSubError
+// are responded with the given status
+func writePubSubError(w http.ResponseWriter, status int, err error) {
+	perr := &PubSubError{}
+	if !errors.As(err, &perr) {
+		perr = newPubSubError(status, "", err)
+	}
+
+	data, _ := json.Marshal(map[string]*PubSubError{"error": perr})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(perr.Status)
+	_, _ = w.Write(data)
+}
+
+// WithPubSubMaxBodySize rejects pushes with bodies larger than the given number of
+// bytes with 413 Request Entity Too Large. In batch mode, the limit applies to the
+// whole batch
+func WithPubSubMaxBodySize(size int64) PubSubOption {
+	return func(o *pubSubOptions) {
+		o.maxBodySize = size
+	}
+}
+
+// WithPubSubRequiredFields rejects push envelopes that miss any of the given fields
+// with 400 Bad Request. The message itself is always required
+func WithPubSubRequiredFields(fields ...PubSubMetaAttribute) PubSubOption {
+	return func(o *pubSubOptions) {
+		o.requiredFields = append(o.requiredFields, fields...)
+	}
+}
+
+// WithPubSubAllowedSubscriptions rejects pushes of subscriptions other than the given
+// ones with 403 Forbidden. Subscriptions can be given by their short or full names
+func WithPubSubAllowedSubscriptions(subscriptions ...string) PubSubOption {
+	return func(o *pubSubOptions) {
+		o.allowedSubscriptions = append(o.allowedSubscriptions, subscriptions...)
+	}
+}
+
+// limitBody replaces the request body with a reader failing with ErrPubSubBodyTooLarge
+// once the size limit is exceeded
+func (o *pubSubOptions) limitBody(r *http.Request) {
+	if o.maxBodySize <= 0 {
+		return
+	}
+
+	r.Body = &limitedBody{ReadCloser: r.Body, remaining: o.maxBodySize}
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, newPubSubError(http.StatusRequestEntityTooLarge, "", ErrPubSubBodyTooLarge)
+	}
+
+	// read one byte over the limit to find out whether the body exceeds it
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n, newPubSubError(http.StatusRequestEntityTooLarge, "", ErrPubSubBodyTooLarge)
+	}
+
+	return n, err
+}
+
+// validate checks the decoded push envelope against the configured constraints
+func (o *pubSubOptions) validate(psmsg *PushMessage) error {
+	if psmsg == nil || psmsg.Message == nil {
+		return newPubSubError(http.StatusBadRequest, "", ErrPubSubMissingMessage)
+	}
+
+	for _, field := range o.requiredFields {
+		if pubSubFieldEmpty(psmsg, field) {
+			return newPubSubError(http.StatusBadRequest, field, ErrPubSubMissingField)
+		}
+	}
+
+	if len(o.allowedSubscriptions) == 0 {
+		return nil
+	}
+	for _, name := range o.allowedSubscriptions {
+		if subscriptionMatches(psmsg.Subscription, name) {
+			return nil
+		}
+	}
+
+	return newPubSubError(http.StatusForbidden, PubSubMetaSubscription, ErrPubSubSubscriptionNotAllowed)
+}
+
+// pubSubFieldEmpty returns true if the envelope field of the meta attribute is not set
+func pubSubFieldEmpty(psmsg *PushMessage, field PubSubMetaAttribute) bool {
+	switch field {
+	case PubSubMetaSubscription:
+		return psmsg.Subscription == ""
+	case PubSubMetaMessageID:
+		return psmsg.Message.MessageID == ""
+	case PubSubMetaPublishTime:
+		return psmsg.Message.PublishTime == ""
+	case PubSubMetaOrderingKey:
+		return psmsg.Message.OrderingKey == ""
+	case PubSubMetaDeliveryAttempt:
+		return psmsg.DeliveryAttempt == 0
+	}
+
+	return false
+}
@@ -0,0 +1,141 @@
+package multiplexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type PubSubValidationSuite struct {
+	suite.Suite
+
+	calls int
+}
+
+func (s *PubSubValidationSuite) SetupTest() {
+	s.calls = 0
+}
+
+func (s *PubSubValidationSuite) handler(opts ...PubSubOption) Handler {
+	return NewPubSubHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.calls++
+	}), opts...)
+}
+
+func (s *PubSubValidationSuite) push(handler Handler, body string) (int, *PubSubError) {
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/echo", strings.NewReader(body))
+	req.Header.Set("User-Agent", "APIs-Google; (+https://developers.google.com/webmasters/APIs-Google.html)")
+
+	rec := httptest.NewRecorder()
+	s.True(handler(rec, req))
+
+	if rec.Code == http.StatusOK {
+		return rec.Code, nil
+	}
+
+	s.Equal("application/json", rec.Header().Get("Content-Type"))
+	res := map[string]*PubSubError{}
+	s.NoError(json.Unmarshal(rec.Body.Bytes(), &res))
+
+	return rec.Code, res["error"]
+}
+
+func (s *PubSubValidationSuite) TestMalformedEnvelopes() {
+	handler := s.handler()
+
+	status, perr := s.push(handler, `{"subscription":"projects/myproject/subscriptions/echo"}`)
+	s.Equal(http.StatusBadRequest, status)
+	s.Equal(&PubSubError{Status: http.StatusBadRequest, Message: ErrPubSubMissingMessage.Error()}, perr)
+
+	status, perr = s.push(handler, `{"message":`)
+	s.Equal(http.StatusBadRequest, status)
+	s.Equal(http.StatusBadRequest, perr.Status)
+
+	s.Equal(0, s.calls)
+}
+
+func (s *PubSubValidationSuite) TestMaxBodySize() {
+	handler := s.handler(WithPubSubMaxBodySize(64))
+
+	status, _ := s.push(handler, `{"message":{"messageId":"1"}}`)
+	s.Equal(http.StatusOK, status)
+
+	status, perr := s.push(handler, `{"message":{"messageId":"1","data":"`+strings.Repeat("a", 64)+`"}}`)
+	s.Equal(http.StatusRequestEntityTooLarge, status)
+	s.Equal(ErrPubSubBodyTooLarge.Error(), perr.Message)
+
+	s.Equal(1, s.calls)
+}
+
+func (s *PubSubValidationSuite) TestRequiredFields() {
+	handler := s.handler(WithPubSubRequiredFields(PubSubMetaSubscription, PubSubMetaMessageID))
+
+	status, perr := s.push(handler, `{"message":{"messageId":"1"}}`)
+	s.Equal(http.StatusBadRequest, status)
+	s.Equal(PubSubMetaAttribute(PubSubMetaSubscription), perr.Field)
+
+	status, perr = s.push(handler, `{"message":{},"subscription":"projects/myproject/subscriptions/echo"}`)
+	s.Equal(http.StatusBadRequest, status)
+	s.Equal(PubSubMetaAttribute(PubSubMetaMessageID), perr.Field)
+	s.Equal(ErrPubSubMissingField.Error(), perr.Message)
+
+	status, _ = s.push(handler, `{"message":{"messageId":"1"},"subscription":"projects/myproject/subscriptions/echo"}`)
+	s.Equal(http.StatusOK, status)
+
+	s.Equal(1, s.calls)
+}
+
+func (s *PubSubValidationSuite) TestAllowedSubscriptions() {
+	handler := s.handler(WithPubSubAllowedSubscriptions("echo", "projects/other/subscriptions/audit"))
+
+	candidates := map[string]int{
+		"projects/myproject/subscriptions/echo":  http.StatusOK,
+		"projects/other/subscriptions/audit":     http.StatusOK,
+		"projects/myproject/subscriptions/audit": http.StatusForbidden,
+		"":                                       http.StatusForbidden,
+	}
+
+	for subscription, expected := range candidates {
+		status, _ := s.push(handler, `{"message":{"messageId":"1"},"subscription":"`+subscription+`"}`)
+		s.Equal(expected, status, subscription)
+	}
+
+	s.Equal(2, s.calls)
+}
+
+func (s *PubSubValidationSuite) TestBatchValidation() {
+	handler := s.handler(WithPubSubBatch(1), WithPubSubAllowedSubscriptions("echo"))
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/echo", bytes.NewReader([]byte(`[
+		{"message":{"messageId":"1"},"subscription":"projects/myproject/subscriptions/echo"},
+		{"message":{"messageId":"2"},"subscription":"projects/myproject/subscriptions/other"},
+		{"subscription":"projects/myproject/subscriptions/echo"}
+	]`)))
+	req.Header.Set("User-Agent", "APIs-Google; (+https://developers.google.com/webmasters/APIs-Google.html)")
+
+	rec := httptest.NewRecorder()
+	s.True(handler(rec, req))
+	s.Equal(http.StatusMultiStatus, rec.Code)
+
+	res := PubSubBatchResponse{}
+	s.NoError(json.Unmarshal(rec.Body.Bytes(), &res))
+	s.Equal(http.StatusOK, res.Results[0].Status)
+	s.Equal(http.StatusForbidden, res.Results[1].Status)
+	s.Equal(http.StatusBadRequest, res.Results[2].Status)
+	s.Equal(1, s.calls)
+}
+
+func (s *PubSubValidationSuite) TestInterceptWithoutMessage() {
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/echo", strings.NewReader(`{"subscription":"echo"}`))
+
+	_, err := InterceptPubSubRequest(req)
+	s.Equal(ErrPubSubMissingMessage, err)
+}
+
+func TestPubSubValidationSuite(t *testing.T) {
+	suite.Run(t, &PubSubValidationSuite{})
+}
@@ -0,0 +1,53 @@
+package multiplexer
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// responseRecorder buffers the response of a handler, so it can be inspected
+// and rewritten before it is sent to the client
+type responseRecorder struct {
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{
+		header: http.Header{},
+		status: http.StatusOK,
+	}
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(data)
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+
+	r.status = status
+	r.wroteHeader = true
+}
+
+// Flush is a no-op, the response is flushed by writeTo
+func (r *responseRecorder) Flush() {}
+
+// writeTo sends the recorded response to the given writer
+func (r *responseRecorder) writeTo(w http.ResponseWriter) {
+	for k, vv := range r.header {
+		w.Header()[k] = vv
+	}
+
+	w.WriteHeader(r.status)
+	_, _ = w.Write(r.body.Bytes())
+}
@@ -0,0 +1,73 @@
+package multiplexer
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	// SchedulerUserAgent is the user agent of requests sent by Cloud Scheduler to HTTP targets
+	SchedulerUserAgent = "Google-Cloud-Scheduler"
+	// SchedulerHeader is the header set to 'true' on requests sent by Cloud Scheduler
+	SchedulerHeader = "X-CloudScheduler"
+	// SchedulerJobNameHeader is the header with the name of the job that triggered the request
+	SchedulerJobNameHeader = "X-CloudScheduler-JobName"
+	// SchedulerScheduleTimeHeader is the header with the time the job was scheduled for
+	// in the RFC 3339 format
+	SchedulerScheduleTimeHeader = "X-CloudScheduler-ScheduleTime"
+)
+
+// SchedulerAttribute are job attributes known to be sent by Cloud Scheduler
+type SchedulerAttribute string
+
+const (
+	// SchedulerJobName is the name of the job (X-CloudScheduler-JobName)
+	SchedulerJobName SchedulerAttribute = "job-name"
+	// SchedulerScheduleTime is the time the job was scheduled for (X-CloudScheduler-ScheduleTime)
+	SchedulerScheduleTime SchedulerAttribute = "schedule-time"
+)
+
+// SchedulerAttributeHeader returns a key for header access to the job
+// attributes (see SchedulerAttribute)
+func SchedulerAttributeHeader(attr SchedulerAttribute) string {
+	return "x-cloudscheduler-" + string(attr)
+}
+
+// IsSchedulerRequest returns true if the given request is sent by Cloud Scheduler
+// (or the local cron of the server package)
+func IsSchedulerRequest(r *http.Request) bool {
+	return r.Header.Get(SchedulerHeader) == "true" ||
+		strings.Contains(r.Header.Get("user-agent"), SchedulerUserAgent)
+}
+
+// SchedulerHandler fulfills requests that are considered to be Cloud Scheduler requests,
+// appending the job metadata as headers. The body of the job is passed through untouched
+func SchedulerHandler(handler http.Handler, selectors ...Selector) Handler {
+	filter := append([]Selector{IsSchedulerRequest}, selectors...)
+
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for _, f := range filter {
+			if !f(r) {
+				return false
+			}
+		}
+
+		handler.ServeHTTP(w, InterceptSchedulerRequest(r))
+		return true
+	}
+}
+
+// InterceptSchedulerRequest mutates the given http.Request, adding the job metadata
+// into headers
+func InterceptSchedulerRequest(r *http.Request) *http.Request {
+	// the Grpc-Metadata- prefix is stripped by the grpc-gateway, so these headers
+	// are accessible by their original names
+	if v := r.Header.Get(SchedulerJobNameHeader); v != "" {
+		r.Header.Set(gatewayMetadataPrefix+SchedulerAttributeHeader(SchedulerJobName), v)
+	}
+	if v := r.Header.Get(SchedulerScheduleTimeHeader); v != "" {
+		r.Header.Set(gatewayMetadataPrefix+SchedulerAttributeHeader(SchedulerScheduleTime), v)
+	}
+
+	return r
+}
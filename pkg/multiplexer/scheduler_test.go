@@ -0,0 +1,68 @@
+package multiplexer
+
+import (
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type SchedulerSuite struct {
+	suite.Suite
+}
+
+func (s *SchedulerSuite) TestIsSchedulerRequest() {
+	candidates := map[*http.Request]bool{
+		{
+			Method: http.MethodPost,
+			Header: map[string][]string{
+				"User-Agent": {"Google-Cloud-Scheduler"},
+			},
+		}: true,
+		{
+			Method: http.MethodGet,
+			Header: map[string][]string{
+				"X-Cloudscheduler": {"true"},
+			},
+		}: true,
+		{
+			Method: http.MethodPost,
+			Header: map[string][]string{
+				"User-Agent": {"curl/7.68.0"},
+			},
+		}: false,
+	}
+
+	for req, result := range candidates {
+		s.Equal(result, IsSchedulerRequest(req), "Request is badly considered a scheduler request", req.Header)
+	}
+}
+
+func (s *SchedulerSuite) TestSchedulerHandler() {
+	var body string
+	var header http.Header
+
+	handler := SchedulerHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body, header = string(data), r.Header
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/echo", strings.NewReader(`{"message":"tick"}`))
+	req.Header.Set("User-Agent", SchedulerUserAgent)
+	req.Header.Set(SchedulerHeader, "true")
+	req.Header.Set(SchedulerJobNameHeader, "projects/myproject/locations/europe-west1/jobs/tick")
+	req.Header.Set(SchedulerScheduleTimeHeader, "2020-09-13T12:26:40Z")
+
+	s.True(handler(httptest.NewRecorder(), req))
+	s.Equal(`{"message":"tick"}`, body)
+	s.Equal("projects/myproject/locations/europe-west1/jobs/tick", header.Get(gatewayMetadataPrefix+SchedulerAttributeHeader(SchedulerJobName)))
+	s.Equal("2020-09-13T12:26:40Z", header.Get(gatewayMetadataPrefix+SchedulerAttributeHeader(SchedulerScheduleTime)))
+
+	s.False(handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "http://localhost/echo", nil)))
+}
+
+func TestSchedulerSuite(t *testing.T) {
+	suite.Run(t, &SchedulerSuite{})
+}
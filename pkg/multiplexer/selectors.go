@@ -0,0 +1,193 @@
+package multiplexer
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// PathPrefix selects requests with the URL path starting with the given prefix
+func PathPrefix(prefix string) Selector {
+	return func(r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, prefix)
+	}
+}
+
+// PathRegexp selects requests with the URL path matching the given regular expression.
+// The expression is compiled eagerly and PathRegexp panics if it is not valid
+func PathRegexp(pattern string) Selector {
+	re := regexp.MustCompile(pattern)
+
+	return func(r *http.Request) bool {
+		return re.MatchString(r.URL.Path)
+	}
+}
+
+// Host selects requests sent to one of the given hosts. The hosts are matched case
+// insensitively and without the port unless the pattern contains one. A * label
+// matches exactly one label of the host, e.g. *.example.com matches api.example.com
+// but not example.com or v1.api.example.com
+func Host(patterns ...string) Selector {
+	return func(r *http.Request) bool {
+		for _, pattern := range patterns {
+			host := r.Host
+			if _, _, err := net.SplitHostPort(pattern); err != nil {
+				host = stripPort(host)
+			}
+
+			if matchHost(strings.ToLower(pattern), strings.ToLower(host)) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Method selects requests with one of the given http methods
+func Method(methods ...string) Selector {
+	return func(r *http.Request) bool {
+		for _, m := range methods {
+			if strings.EqualFold(r.Method, m) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// HeaderEquals selects requests with the first value of the header equal to the given value
+func HeaderEquals(key, value string) Selector {
+	return func(r *http.Request) bool {
+		vv, ok := r.Header[http.CanonicalHeaderKey(key)]
+		return ok && len(vv) > 0 && vv[0] == value
+	}
+}
+
+// HeaderRegexp selects requests with a value of the header matching the given regular
+// expression. The expression is compiled eagerly and HeaderRegexp panics if it is not valid
+func HeaderRegexp(key, pattern string) Selector {
+	re := regexp.MustCompile(pattern)
+
+	return func(r *http.Request) bool {
+		for _, v := range r.Header.Values(key) {
+			if re.MatchString(v) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// QueryParam selects requests with the query parameter. If values are given, one of
+// the values of the parameter must be equal to one of them
+func QueryParam(key string, values ...string) Selector {
+	return func(r *http.Request) bool {
+		params, ok := r.URL.Query()[key]
+		if !ok {
+			return false
+		}
+
+		if len(values) == 0 {
+			return true
+		}
+
+		for _, p := range params {
+			for _, v := range values {
+				if p == v {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+}
+
+// ContentType selects requests with one of the given content types. The parameters
+// of the Content-Type header (e.g. charset) are ignored
+func ContentType(contentTypes ...string) Selector {
+	return func(r *http.Request) bool {
+		contentType := requestContentType(r)
+		for _, ct := range contentTypes {
+			if strings.EqualFold(contentType, ct) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// GRPCService selects requests calling a method of one of the given grpc services.
+// The services are identified by their full names (e.g. package.Service) and the
+// request path must be the full method name (/package.Service/Method)
+func GRPCService(services ...string) Selector {
+	return func(r *http.Request) bool {
+		if !isFullMethodPath(r.URL.Path) {
+			return false
+		}
+
+		service, _, _ := splitFullMethod(r.URL.Path)
+		for _, s := range services {
+			if service == s {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// GRPCMethod selects requests calling one of the given grpc methods. The methods are
+// identified by their full names, with or without the leading slash
+// (e.g. /package.Service/Method or package.Service/Method)
+func GRPCMethod(methods ...string) Selector {
+	return func(r *http.Request) bool {
+		if !isFullMethodPath(r.URL.Path) {
+			return false
+		}
+
+		for _, m := range methods {
+			if r.URL.Path == "/"+strings.TrimPrefix(m, "/") {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// stripPort returns the host without the port if there is one
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+
+	return host
+}
+
+// matchHost matches the host label by label, where the * label of the pattern
+// matches any single label of the host
+func matchHost(pattern, host string) bool {
+	patternLabels := strings.Split(pattern, ".")
+	hostLabels := strings.Split(host, ".")
+	if len(patternLabels) != len(hostLabels) {
+		return false
+	}
+
+	for i, label := range patternLabels {
+		if label == "*" && hostLabels[i] != "" {
+			continue
+		}
+
+		if label != hostLabels[i] {
+			return false
+		}
+	}
+
+	return true
+}
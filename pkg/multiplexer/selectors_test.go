@@ -0,0 +1,111 @@
+package multiplexer
+
+import (
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type SelectorsSuite struct {
+	suite.Suite
+}
+
+func (s *SelectorsSuite) TestPath() {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/v1/users/42", nil)
+
+	s.True(PathPrefix("/v1/")(req))
+	s.False(PathPrefix("/v2/")(req))
+	s.True(PathRegexp(`^/v1/users/\d+$`)(req))
+	s.False(PathRegexp(`^/v1/users$`)(req))
+
+	s.Panics(func() { PathRegexp(`(`) })
+}
+
+func (s *SelectorsSuite) TestHost() {
+	candidates := map[string]bool{
+		"example.com":           true,
+		"EXAMPLE.com:8080":      true,
+		"api.example.com":       true,
+		"api.example.com:8080":  true,
+		"v1.api.example.com":    false,
+		"example.org":           false,
+		"api.example.org:8080":  false,
+		"internal.example.test": false,
+	}
+
+	selector := Host("example.com", "*.example.com")
+	for host, result := range candidates {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+		req.Host = host
+		s.Equal(result, selector(req), host)
+	}
+
+	// patterns with ports match the ports too
+	req := httptest.NewRequest(http.MethodGet, "http://api.example.com:8080/", nil)
+	s.True(Host("*.example.com:8080")(req))
+	s.False(Host("*.example.com:9090")(req))
+}
+
+func (s *SelectorsSuite) TestMethod() {
+	req := httptest.NewRequest(http.MethodPut, "http://localhost/", nil)
+
+	s.True(Method(http.MethodPost, http.MethodPut)(req))
+	s.True(Method("put")(req))
+	s.False(Method(http.MethodGet)(req))
+}
+
+func (s *SelectorsSuite) TestHeader() {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+	req.Header.Set("X-Tenant", "acme")
+	req.Header.Add("Accept", "text/html")
+	req.Header.Add("Accept", "application/json")
+
+	s.True(HeaderEquals("x-tenant", "acme")(req))
+	s.False(HeaderEquals("X-Tenant", "other")(req))
+	s.False(HeaderEquals("X-Missing", "")(req))
+
+	s.True(HeaderRegexp("Accept", `^application/`)(req), "all header values are matched")
+	s.False(HeaderRegexp("Accept", `^image/`)(req))
+	s.False(HeaderRegexp("X-Missing", `.*`)(req))
+}
+
+func (s *SelectorsSuite) TestQueryParam() {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/?debug&format=json&format=xml", nil)
+
+	s.True(QueryParam("debug")(req))
+	s.True(QueryParam("format", "xml")(req))
+	s.True(QueryParam("format", "yaml", "json")(req))
+	s.False(QueryParam("format", "yaml")(req))
+	s.False(QueryParam("missing")(req))
+}
+
+func (s *SelectorsSuite) TestContentType() {
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/", nil)
+	req.Header.Set("Content-Type", "Application/JSON; charset=utf-8")
+
+	s.True(ContentType("application/json")(req))
+	s.True(ContentType("application/xml", "application/json")(req))
+	s.False(ContentType("application/grpc")(req))
+}
+
+func (s *SelectorsSuite) TestGRPC() {
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/xrpc.v1.EchoService/Call", nil)
+
+	s.True(GRPCService("xrpc.v1.EchoService")(req))
+	s.False(GRPCService("xrpc.v1.OtherService", "EchoService")(req))
+	s.True(GRPCMethod("/xrpc.v1.EchoService/Call")(req))
+	s.True(GRPCMethod("xrpc.v1.EchoService/Call")(req))
+	s.False(GRPCMethod("/xrpc.v1.EchoService/Stream")(req))
+
+	// paths that are not full method names never match
+	for _, path := range []string{"/", "/xrpc.v1.EchoService", "/api/xrpc.v1.EchoService/Call", "/xrpc.v1.EchoService/"} {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost"+path, nil)
+		s.False(GRPCService("xrpc.v1.EchoService", "api/xrpc.v1.EchoService")(req), path)
+		s.False(GRPCMethod(path)(req), path)
+	}
+}
+
+func TestSelectorsSuite(t *testing.T) {
+	suite.Run(t, &SelectorsSuite{})
+}
@@ -0,0 +1,257 @@
+package multiplexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	// SNSMessageTypeHeader is the header with the type of the message sent by Amazon SNS
+	SNSMessageTypeHeader = "X-Amz-Sns-Message-Type"
+
+	// SNSTypeNotification is the type of messages published to the topic
+	SNSTypeNotification = "Notification"
+	// SNSTypeSubscriptionConfirmation is the type of messages sent to confirm a new subscription
+	SNSTypeSubscriptionConfirmation = "SubscriptionConfirmation"
+	// SNSTypeUnsubscribeConfirmation is the type of messages sent after the endpoint was unsubscribed
+	SNSTypeUnsubscribeConfirmation = "UnsubscribeConfirmation"
+)
+
+// SNSMessage is a definition of the Amazon SNS message received by HTTP(S) endpoints
+type SNSMessage struct {
+	Type              string                         `json:"Type"`
+	MessageID         string                         `json:"MessageId"`
+	Token             string                         `json:"Token,omitempty"`
+	TopicArn          string                         `json:"TopicArn"`
+	Subject           string                         `json:"Subject,omitempty"`
+	Message           string                         `json:"Message"`
+	Timestamp         string                         `json:"Timestamp"`
+	SignatureVersion  string                         `json:"SignatureVersion"`
+	Signature         string                         `json:"Signature"`
+	SigningCertURL    string                         `json:"SigningCertURL"`
+	SubscribeURL      string                         `json:"SubscribeURL,omitempty"`
+	UnsubscribeURL    string                         `json:"UnsubscribeURL,omitempty"`
+	MessageAttributes map[string]SNSMessageAttribute `json:"MessageAttributes,omitempty"`
+}
+
+// SNSMessageAttribute is a typed attribute of the SNS message. Values of Binary
+// attributes are base64 encoded
+type SNSMessageAttribute struct {
+	Type  string `json:"Type"`
+	Value string `json:"Value"`
+}
+
+// SNSMetaAttribute are attributes known to be sent in the SNS message envelope
+type SNSMetaAttribute string
+
+const (
+	// SNSMetaTopicArn is the meta attribute 'TopicArn'
+	SNSMetaTopicArn SNSMetaAttribute = "topic-arn"
+	// SNSMetaMessageID is the meta attribute 'MessageId'
+	SNSMetaMessageID SNSMetaAttribute = "message-id"
+	// SNSMetaSubject is the meta attribute 'Subject', only sent if the publisher set it
+	SNSMetaSubject SNSMetaAttribute = "subject"
+	// SNSMetaTimestamp is the meta attribute 'Timestamp'
+	SNSMetaTimestamp SNSMetaAttribute = "timestamp"
+)
+
+// SNSMetaAttributeHeader creates a key for header access to the SNS meta
+// information (see SNSMetaAttribute)
+func SNSMetaAttributeHeader(attr SNSMetaAttribute) string {
+	return "x-sns-" + string(attr)
+}
+
+// SNSAttributeHeader returns a key for header access to the SNS message
+// attributes (SNSMessage.MessageAttributes)
+func SNSAttributeHeader(attr string) string {
+	return "x-sns-attr-" + attr
+}
+
+// IsSNSRequest returns true if the given request is sent by Amazon SNS
+func IsSNSRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && r.Header.Get(SNSMessageTypeHeader) != ""
+}
+
+// SNSHandler fulfills requests that are considered to be SNS requests, verifying their
+// signatures, confirming subscriptions and unwrapping the notifications
+func SNSHandler(handler http.Handler, selectors ...Selector) Handler {
+	return NewSNSHandler(handler, WithSNSSelectors(selectors...))
+}
+
+// SNSOption is an extendable builder for the SNSHandler options
+type SNSOption func(o *snsOptions)
+
+type snsOptions struct {
+	selectors    []Selector
+	certificates CertificateFetcher
+	client       *http.Client
+	skipVerify   bool
+}
+
+// WithSNSSelectors adds selectors that must be fulfilled on top of
+// the IsSNSRequest selector
+func WithSNSSelectors(selectors ...Selector) SNSOption {
+	return func(o *snsOptions) {
+		o.selectors = append(o.selectors, selectors...)
+	}
+}
+
+// WithSNSCertificateFetcher sets the fetcher of the signing certificates. By default,
+// the certificates are downloaded from Amazon SNS by the HTTPCertificateFetcher
+func WithSNSCertificateFetcher(fetcher CertificateFetcher) SNSOption {
+	return func(o *snsOptions) {
+		o.certificates = fetcher
+	}
+}
+
+// WithSNSHTTPClient sets the client used to confirm subscriptions, http.DefaultClient
+// by default
+func WithSNSHTTPClient(client *http.Client) SNSOption {
+	return func(o *snsOptions) {
+		o.client = client
+	}
+}
+
+// WithSNSInsecureSkipVerify disables the verification of message signatures, e.g. for
+// local development with emulators that don't sign the messages
+func WithSNSInsecureSkipVerify() SNSOption {
+	return func(o *snsOptions) {
+		o.skipVerify = true
+	}
+}
+
+// NewSNSHandler creates a SNSHandler configured by the given options. Notifications are
+// passed to the handler with their message in the body, subscription confirmations are
+// confirmed by visiting their SubscribeURL and unsubscribe confirmations are acknowledged.
+// Messages with invalid signatures are rejected with 401 Unauthorized
+func NewSNSHandler(handler http.Handler, opts ...SNSOption) Handler {
+	options := &snsOptions{
+		client: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.certificates == nil {
+		options.certificates = NewHTTPCertificateFetcher(options.client)
+	}
+
+	filter := append([]Selector{IsSNSRequest}, options.selectors...)
+
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for _, f := range filter {
+			if !f(r) {
+				return false
+			}
+		}
+
+		msg, err := decodeSNSMessage(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return true
+		}
+
+		if !options.skipVerify {
+			if err := VerifySNSSignature(r.Context(), options.certificates, msg); err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(err.Error()))
+				return true
+			}
+		}
+
+		switch msg.Type {
+		case SNSTypeNotification:
+			handler.ServeHTTP(w, applySNSMessage(r, msg))
+		case SNSTypeSubscriptionConfirmation:
+			if err := options.confirm(r, msg); err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				_, _ = w.Write([]byte(err.Error()))
+				return true
+			}
+			w.WriteHeader(http.StatusOK)
+		case SNSTypeUnsubscribeConfirmation:
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("sns: unknown message type " + msg.Type))
+		}
+
+		return true
+	}
+}
+
+// confirm visits the SubscribeURL of the confirmation message
+func (o *snsOptions) confirm(r *http.Request, msg *SNSMessage) error {
+	if !o.skipVerify && !isSNSURL(msg.SubscribeURL) {
+		return ErrUntrustedSNSURL
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, msg.SubscribeURL, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sns: failed to confirm the subscription: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("sns: failed to confirm the subscription: status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// InterceptSNSRequest mutates the given http.Request, reading its body and converting it
+// into the SNS message. It also adds all SNS metadata and message attributes into headers.
+// The signature of the message is not verified
+func InterceptSNSRequest(r *http.Request) (*http.Request, error) {
+	msg, err := decodeSNSMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return applySNSMessage(r, msg), nil
+}
+
+// decodeSNSMessage reads the body of the http request and unmarshals the SNS message
+func decodeSNSMessage(r *http.Request) (*SNSMessage, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &SNSMessage{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// applySNSMessage replaces the body of the http request with the message and adds
+// all SNS metadata into headers
+func applySNSMessage(r *http.Request, msg *SNSMessage) *http.Request {
+	body := []byte(msg.Message)
+	r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	r.ContentLength = int64(len(body))
+
+	// the Grpc-Metadata- prefix is stripped by the grpc-gateway, so these headers
+	// are accessible by their original names
+	r.Header.Add(gatewayMetadataPrefix+SNSMetaAttributeHeader(SNSMetaTopicArn), msg.TopicArn)
+	r.Header.Add(gatewayMetadataPrefix+SNSMetaAttributeHeader(SNSMetaMessageID), msg.MessageID)
+	r.Header.Add(gatewayMetadataPrefix+SNSMetaAttributeHeader(SNSMetaTimestamp), msg.Timestamp)
+	if msg.Subject != "" {
+		r.Header.Add(gatewayMetadataPrefix+SNSMetaAttributeHeader(SNSMetaSubject), msg.Subject)
+	}
+	for k, attr := range msg.MessageAttributes {
+		r.Header.Add(gatewayMetadataPrefix+SNSAttributeHeader(k), attr.Value)
+	}
+
+	return r
+}
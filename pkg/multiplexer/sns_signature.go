@@ -0,0 +1,222 @@
+package multiplexer
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrInvalidSNSSignature is returned if the signature of the SNS message does not
+	// match the signing certificate
+	ErrInvalidSNSSignature = errors.New("sns: invalid message signature")
+
+	// ErrUntrustedSNSURL is returned if the signing certificate or the subscription
+	// confirmation URL is not an https URL of Amazon SNS
+	ErrUntrustedSNSURL = errors.New("sns: url is not an Amazon SNS endpoint")
+
+	// ErrUnknownCertificate is returned by a CertificateFetcher if it does not know
+	// the certificate of the given URL
+	ErrUnknownCertificate = errors.New("sns: unknown signing certificate")
+)
+
+// snsHostPattern matches the hosts of the Amazon SNS endpoints, which serve the
+// signing certificates and the subscription confirmation URLs
+var snsHostPattern = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// CertificateFetcher provides the certificates used to verify SNS message signatures
+type CertificateFetcher interface {
+	Certificate(ctx context.Context, certURL string) (*x509.Certificate, error)
+}
+
+// StaticCertificateFetcher is an in-memory CertificateFetcher mapping certificate
+// URLs to certificates
+type StaticCertificateFetcher map[string]*x509.Certificate
+
+// Certificate returns the certificate of the given URL
+func (f StaticCertificateFetcher) Certificate(_ context.Context, certURL string) (*x509.Certificate, error) {
+	cert, ok := f[certURL]
+	if !ok {
+		return nil, ErrUnknownCertificate
+	}
+
+	return cert, nil
+}
+
+// HTTPCertificateFetcher downloads the PEM encoded certificates from Amazon SNS and
+// caches them by their URLs. Only https URLs of the SNS endpoints are fetched
+type HTTPCertificateFetcher struct {
+	mu     sync.Mutex
+	client *http.Client
+	certs  map[string]*x509.Certificate
+}
+
+// NewHTTPCertificateFetcher creates a new HTTPCertificateFetcher. The client defaults
+// to http.DefaultClient
+func NewHTTPCertificateFetcher(client *http.Client) *HTTPCertificateFetcher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &HTTPCertificateFetcher{
+		client: client,
+		certs:  map[string]*x509.Certificate{},
+	}
+}
+
+// Certificate returns the cached certificate or downloads it
+func (f *HTTPCertificateFetcher) Certificate(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	if !isSNSURL(certURL) {
+		return nil, ErrUntrustedSNSURL
+	}
+
+	f.mu.Lock()
+	cert, ok := f.certs[certURL]
+	f.mu.Unlock()
+	if ok {
+		return cert, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, certURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the signing certificate: %w", err)
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the signing certificate: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch the signing certificate: status %d", res.StatusCode)
+	}
+
+	cert, err = parsePEMCertificate(data)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.certs[certURL] = cert
+	f.mu.Unlock()
+
+	return cert, nil
+}
+
+// parsePEMCertificate parses the first certificate of the PEM document
+func parsePEMCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("sns: signing certificate is not a PEM encoded certificate")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// isSNSURL returns true if the URL is an https URL of an Amazon SNS endpoint
+func isSNSURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+
+	return u.Scheme == "https" && snsHostPattern.MatchString(u.Hostname())
+}
+
+// snsStringToSign builds the canonical string signed by SNS. Notifications are signed
+// without the subscribe URL and token, confirmations without the subject
+func snsStringToSign(msg *SNSMessage) string {
+	fields := [][2]string{
+		{"Message", msg.Message},
+		{"MessageId", msg.MessageID},
+	}
+
+	if msg.Type == SNSTypeNotification {
+		if msg.Subject != "" {
+			fields = append(fields, [2]string{"Subject", msg.Subject})
+		}
+		fields = append(fields,
+			[2]string{"Timestamp", msg.Timestamp},
+			[2]string{"TopicArn", msg.TopicArn},
+			[2]string{"Type", msg.Type},
+		)
+	} else {
+		fields = append(fields,
+			[2]string{"SubscribeURL", msg.SubscribeURL},
+			[2]string{"Timestamp", msg.Timestamp},
+			[2]string{"Token", msg.Token},
+			[2]string{"TopicArn", msg.TopicArn},
+			[2]string{"Type", msg.Type},
+		)
+	}
+
+	sb := strings.Builder{}
+	for _, f := range fields {
+		sb.WriteString(f[0] + "\n" + f[1] + "\n")
+	}
+
+	return sb.String()
+}
+
+// VerifySNSSignature verifies the signature of the message against its signing
+// certificate. Both SHA1 (SignatureVersion 1) and SHA256 (SignatureVersion 2)
+// signatures are supported
+func VerifySNSSignature(ctx context.Context, fetcher CertificateFetcher, msg *SNSMessage) error {
+	signature, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return ErrInvalidSNSSignature
+	}
+
+	var hash crypto.Hash
+	switch msg.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("%w: unsupported signature version %q", ErrInvalidSNSSignature, msg.SignatureVersion)
+	}
+
+	cert, err := fetcher.Certificate(ctx, msg.SigningCertURL)
+	if err != nil {
+		return err
+	}
+
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: unsupported key type %T", ErrInvalidSNSSignature, cert.PublicKey)
+	}
+
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(snsStringToSign(msg)))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(snsStringToSign(msg)))
+		digest = sum[:]
+	}
+
+	if err := rsa.VerifyPKCS1v15(key, hash, digest, signature); err != nil {
+		return ErrInvalidSNSSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,249 @@
+package multiplexer
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testingCertURL = "https://sns.eu-west-1.amazonaws.com/SimpleNotificationService-testing.pem"
+
+// roundTripFunc is a http.RoundTripper faking the remote servers
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+type SNSSuite struct {
+	suite.Suite
+
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+	pem  []byte
+
+	visited []string
+	client  *http.Client
+}
+
+func (s *SNSSuite) SetupSuite() {
+	var err error
+	s.key, err = rsa.GenerateKey(rand.Reader, 2048)
+	s.NoError(err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &s.key.PublicKey, s.key)
+	s.NoError(err)
+
+	s.cert, err = x509.ParseCertificate(der)
+	s.NoError(err)
+	s.pem = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func (s *SNSSuite) SetupTest() {
+	s.visited = nil
+	s.client = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		s.visited = append(s.visited, r.URL.String())
+
+		body := "<ConfirmSubscriptionResponse/>"
+		if r.URL.String() == testingCertURL {
+			body = string(s.pem)
+		}
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+			Header:     http.Header{},
+		}, nil
+	})}
+}
+
+func (s *SNSSuite) sign(msg *SNSMessage) *SNSMessage {
+	msg.SigningCertURL = testingCertURL
+
+	hash, digest := crypto.SHA256, []byte(nil)
+	if msg.SignatureVersion == "1" {
+		sum := sha1.Sum([]byte(snsStringToSign(msg)))
+		hash, digest = crypto.SHA1, sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(snsStringToSign(msg)))
+		digest = sum[:]
+	}
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, hash, digest)
+	s.NoError(err)
+	msg.Signature = base64.StdEncoding.EncodeToString(signature)
+
+	return msg
+}
+
+func (s *SNSSuite) notification(version string) *SNSMessage {
+	return &SNSMessage{
+		Type:             SNSTypeNotification,
+		MessageID:        "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+		TopicArn:         "arn:aws:sns:eu-west-1:123456789012:echo",
+		Subject:          "echo",
+		Message:          `{"message":"Hello World"}`,
+		Timestamp:        "2020-09-13T12:26:40.000Z",
+		SignatureVersion: version,
+		MessageAttributes: map[string]SNSMessageAttribute{
+			"my-label": {Type: "String", Value: "this-is-value"},
+		},
+	}
+}
+
+func (s *SNSSuite) push(handler Handler, msg *SNSMessage) *httptest.ResponseRecorder {
+	body, err := json.Marshal(msg)
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/echo", bytes.NewReader(body))
+	req.Header.Set(SNSMessageTypeHeader, msg.Type)
+
+	rec := httptest.NewRecorder()
+	s.True(handler(rec, req))
+
+	return rec
+}
+
+func (s *SNSSuite) TestIsSNSRequest() {
+	candidates := map[*http.Request]bool{
+		{
+			Method: http.MethodPost,
+			Header: map[string][]string{
+				"X-Amz-Sns-Message-Type": {"Notification"},
+			},
+		}: true,
+		{
+			Method: http.MethodGet,
+			Header: map[string][]string{
+				"X-Amz-Sns-Message-Type": {"Notification"},
+			},
+		}: false,
+		{
+			Method: http.MethodPost,
+			Header: map[string][]string{},
+		}: false,
+	}
+
+	for req, result := range candidates {
+		s.Equal(result, IsSNSRequest(req), "Request is badly considered a sns request", req.Header)
+	}
+}
+
+func (s *SNSSuite) TestNotification() {
+	var body string
+	var header http.Header
+
+	handler := NewSNSHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body, header = string(data), r.Header
+	}), WithSNSHTTPClient(s.client))
+
+	for _, version := range []string{"1", "2"} {
+		body, header = "", nil
+
+		rec := s.push(handler, s.sign(s.notification(version)))
+		s.Equal(http.StatusOK, rec.Code, version)
+		s.Equal(`{"message":"Hello World"}`, body)
+
+		expected := map[string]string{
+			SNSMetaAttributeHeader(SNSMetaTopicArn):  "arn:aws:sns:eu-west-1:123456789012:echo",
+			SNSMetaAttributeHeader(SNSMetaMessageID): "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+			SNSMetaAttributeHeader(SNSMetaSubject):   "echo",
+			SNSMetaAttributeHeader(SNSMetaTimestamp): "2020-09-13T12:26:40.000Z",
+			SNSAttributeHeader("my-label"):           "this-is-value",
+		}
+		for k, v := range expected {
+			s.Equal(v, header.Get(gatewayMetadataPrefix+k), k)
+		}
+	}
+
+	// the certificate is fetched only once
+	s.Equal([]string{testingCertURL}, s.visited)
+}
+
+func (s *SNSSuite) TestInvalidSignature() {
+	calls := 0
+	handler := NewSNSHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}), WithSNSCertificateFetcher(StaticCertificateFetcher{testingCertURL: s.cert}))
+
+	msg := s.sign(s.notification("2"))
+	msg.Message = `{"message":"tampered"}`
+	s.Equal(http.StatusUnauthorized, s.push(handler, msg).Code)
+
+	msg = s.sign(s.notification("3"))
+	s.Equal(http.StatusUnauthorized, s.push(handler, msg).Code)
+
+	msg = s.sign(s.notification("2"))
+	msg.SigningCertURL = "https://attacker.com/cert.pem"
+	s.Equal(http.StatusUnauthorized, s.push(handler, msg).Code)
+
+	s.Equal(0, calls)
+}
+
+func (s *SNSSuite) TestSubscriptionConfirmation() {
+	handler := NewSNSHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Fail("confirmations must not reach the handler")
+	}), WithSNSHTTPClient(s.client), WithSNSCertificateFetcher(StaticCertificateFetcher{testingCertURL: s.cert}))
+
+	subscribeURL := "https://sns.eu-west-1.amazonaws.com/?Action=ConfirmSubscription&Token=token"
+	msg := s.sign(&SNSMessage{
+		Type:             SNSTypeSubscriptionConfirmation,
+		MessageID:        "165545c9-2a5c-472c-8df2-7ff2be2b3b1b",
+		Token:            "token",
+		TopicArn:         "arn:aws:sns:eu-west-1:123456789012:echo",
+		Message:          "You have chosen to subscribe to the topic",
+		SubscribeURL:     subscribeURL,
+		Timestamp:        "2020-09-13T12:26:40.000Z",
+		SignatureVersion: "1",
+	})
+
+	s.Equal(http.StatusOK, s.push(handler, msg).Code)
+	s.Equal([]string{subscribeURL}, s.visited)
+
+	msg.Type = SNSTypeUnsubscribeConfirmation
+	s.Equal(http.StatusOK, s.push(handler, s.sign(msg)).Code)
+	s.Len(s.visited, 1)
+}
+
+func (s *SNSSuite) TestHTTPCertificateFetcher() {
+	fetcher := NewHTTPCertificateFetcher(s.client)
+
+	_, err := fetcher.Certificate(context.Background(), "http://sns.eu-west-1.amazonaws.com/cert.pem")
+	s.True(errors.Is(err, ErrUntrustedSNSURL))
+
+	_, err = fetcher.Certificate(context.Background(), "https://sns.eu-west-1.amazonaws.com.attacker.com/cert.pem")
+	s.True(errors.Is(err, ErrUntrustedSNSURL))
+
+	cert, err := fetcher.Certificate(context.Background(), testingCertURL)
+	s.NoError(err)
+	s.Equal(s.cert, cert)
+}
+
+func TestSNSSuite(t *testing.T) {
+	suite.Run(t, &SNSSuite{})
+}
@@ -0,0 +1,278 @@
+package multiplexer
+
+import (
+	"bytes"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/utilities"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ContentTypeEventStream is the content type of Server-Sent Events
+	ContentTypeEventStream = "text/event-stream"
+
+	// SSELastEventIDHeader is the header sent by EventSources reconnecting to the stream
+	// with the ID of the last received event
+	SSELastEventIDHeader = "Last-Event-ID"
+
+	// SSEErrorEvent is the type of the event carrying the status of a failed call
+	SSEErrorEvent = "error"
+	// SSEEndEvent is the type of the event sent once the call succeeds, so the EventSource
+	// can be closed instead of reconnecting
+	SSEEndEvent = "end"
+)
+
+// IsSSERequest returns true if the request is a GET accepting Server-Sent Events
+func IsSSERequest(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), ContentTypeEventStream)
+}
+
+// SSEHandler streams the responses of the server-streaming methods of the given server, which is
+// usually a *grpc.Server, as Server-Sent Events (see NewSSEHandler)
+func SSEHandler(server http.Handler, selectors ...Selector) Handler {
+	return NewSSEHandler(server, WithSSESelectors(selectors...))
+}
+
+// SSEResumeFunc is called with the Last-Event-ID sent by a reconnecting EventSource, so the
+// request message can be changed to resume the stream, e.g. by setting a cursor
+type SSEResumeFunc func(r *http.Request, lastEventID string, in proto.Message) error
+
+// SSEEventIDFunc returns the ID of the event carrying the given response
+type SSEEventIDFunc func(out proto.Message, seq int) string
+
+// SSEOption is an extendable builder for the SSEHandler options
+type SSEOption func(o *sseOptions)
+
+type sseOptions struct {
+	selectors []Selector
+	heartbeat time.Duration
+	resume    SSEResumeFunc
+	eventID   SSEEventIDFunc
+}
+
+// WithSSESelectors adds selectors that must be fulfilled on top of the IsSSERequest selector
+func WithSSESelectors(selectors ...Selector) SSEOption {
+	return func(o *sseOptions) {
+		o.selectors = append(o.selectors, selectors...)
+	}
+}
+
+// WithSSEHeartbeat sets the interval of the heartbeat comments keeping idle connections alive,
+// 15s by default. Heartbeats are disabled by a zero interval
+func WithSSEHeartbeat(interval time.Duration) SSEOption {
+	return func(o *sseOptions) {
+		o.heartbeat = interval
+	}
+}
+
+// WithSSEResume sets the hook resuming the streams of reconnecting EventSources
+func WithSSEResume(resume SSEResumeFunc) SSEOption {
+	return func(o *sseOptions) {
+		o.resume = resume
+	}
+}
+
+// WithSSEEventID sets the function generating the IDs of the events. The events are
+// numbered by their sequence by default, continuing from a numeric Last-Event-ID
+func WithSSEEventID(eventID SSEEventIDFunc) SSEOption {
+	return func(o *sseOptions) {
+		o.eventID = eventID
+	}
+}
+
+// NewSSEHandler creates an SSEHandler configured by the given options. The server-streaming method
+// is named by the path (/<package>.<Service>/<Method>) and its request is populated from the query
+// parameters, as EventSources can only send GET requests. Every response is sent as a data event in
+// protojson, the status of a failed call as the SSEErrorEvent and the SSEEndEvent finishes the stream.
+// Request headers are passed as the grpc metadata. The messages are resolved from the global protobuf
+// registry, so the generated packages of the services must be imported
+func NewSSEHandler(server http.Handler, opts ...SSEOption) Handler {
+	options := &sseOptions{
+		heartbeat: time.Second * 15,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	filter := append([]Selector{IsSSERequest, func(r *http.Request) bool {
+		return isFullMethodPath(r.URL.Path)
+	}}, options.selectors...)
+	conn := NewLocalConn(server)
+
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for _, f := range filter {
+			if !f(r) {
+				return false
+			}
+		}
+
+		options.serve(conn, w, r)
+		return true
+	}
+}
+
+func (o *sseOptions) serve(conn *LocalConn, w http.ResponseWriter, r *http.Request) {
+	desc, err := findMethodDescriptor(r.URL.Path)
+	if err != nil {
+		writeGatewayStatus(w, status.New(codes.NotFound, err.Error()))
+		return
+	}
+	if desc.IsStreamingClient() || !desc.IsStreamingServer() {
+		writeGatewayStatus(w, status.New(codes.InvalidArgument, "sse: "+r.URL.Path+" is not a server-streaming method"))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeGatewayStatus(w, status.New(codes.Internal, "sse: the response writer does not support flushing"))
+		return
+	}
+
+	in := newMessage(desc.Input())
+	if err := runtime.PopulateQueryParameters(in, r.URL.Query(), utilities.NewDoubleArray(nil)); err != nil {
+		writeGatewayStatus(w, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+
+	seq := 0
+	if lastEventID := r.Header.Get(SSELastEventIDHeader); lastEventID != "" {
+		if n, err := strconv.Atoi(lastEventID); err == nil {
+			seq = n
+		}
+		if o.resume != nil {
+			if err := o.resume(r, lastEventID, in); err != nil {
+				writeGatewayStatus(w, status.Convert(err))
+				return
+			}
+		}
+	}
+
+	md, err := requestMetadata(r.Header, "Accept")
+	if err != nil {
+		writeGatewayStatus(w, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+	ctx := metadata.NewOutgoingContext(r.Context(), md)
+
+	cs, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, fullMethodName(desc))
+	if err != nil {
+		writeGatewayStatus(w, status.Convert(err))
+		return
+	}
+	if err := cs.SendMsg(in); err != nil && err != io.EOF {
+		writeGatewayStatus(w, status.Convert(err))
+		return
+	}
+	if err := cs.CloseSend(); err != nil {
+		writeGatewayStatus(w, status.Convert(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentTypeEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	// disables the buffering of proxies, e.g. nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	type result struct {
+		out proto.Message
+		err error
+	}
+	results := make(chan result)
+	go func() {
+		for {
+			out := newMessage(desc.Output())
+			err := cs.RecvMsg(out)
+
+			select {
+			case results <- result{out: out, err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var heartbeat <-chan time.Time
+	if o.heartbeat > 0 {
+		ticker := time.NewTicker(o.heartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-heartbeat:
+			_, _ = io.WriteString(w, ": heartbeat\n\n")
+			flusher.Flush()
+
+		case res := <-results:
+			if res.err == io.EOF {
+				writeSSEEvent(w, SSEEndEvent, "", []byte("{}"))
+				flusher.Flush()
+				return
+			}
+			if res.err != nil {
+				data, err := protojson.Marshal(status.Convert(res.err).Proto())
+				if err != nil {
+					data = []byte(`{"code":13,"message":"failed to marshal the status"}`)
+				}
+				writeSSEEvent(w, SSEErrorEvent, "", data)
+				flusher.Flush()
+				return
+			}
+
+			seq++
+			id := strconv.Itoa(seq)
+			if o.eventID != nil {
+				id = o.eventID(res.out, seq)
+			}
+
+			data, err := protojson.Marshal(res.out)
+			if err != nil {
+				data, _ = protojson.Marshal(status.New(codes.Internal, err.Error()).Proto())
+				writeSSEEvent(w, SSEErrorEvent, "", data)
+				flusher.Flush()
+				return
+			}
+			writeSSEEvent(w, "", id, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSEEvent writes a single event of the given type and ID, both of which are optional
+func writeSSEEvent(w io.Writer, event, id string, data []byte) {
+	buf := &bytes.Buffer{}
+	if event != "" {
+		buf.WriteString("event: " + event + "\n")
+	}
+	if id != "" {
+		buf.WriteString("id: " + id + "\n")
+	}
+	// protojson may emit multiline output, which must be split into multiple data lines
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+
+	_, _ = w.Write(buf.Bytes())
+}
@@ -0,0 +1,175 @@
+package multiplexer
+
+import (
+	"context"
+	"github.com/grpc-ecosystem/go-grpc-middleware/util/metautils"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	hpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type SSESuite struct {
+	suite.Suite
+
+	health     *health.Server
+	grpcServer *grpc.Server
+
+	mu       sync.Mutex
+	incoming metautils.NiceMD
+}
+
+func (s *SSESuite) SetupTest() {
+	s.health = health.NewServer()
+	s.health.SetServingStatus("echo", hpb.HealthCheckResponse_SERVING)
+
+	// the outcome of the calls is driven by the x-outcome metadata, as the health watch never finishes
+	s.grpcServer = grpc.NewServer(grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md := metautils.ExtractIncoming(ss.Context())
+		s.mu.Lock()
+		s.incoming = md
+		s.mu.Unlock()
+
+		switch md.Get("x-outcome") {
+		case "end":
+			return nil
+		case "fail":
+			return status.Error(codes.PermissionDenied, "watch denied")
+		}
+
+		return handler(srv, ss)
+	}))
+	hpb.RegisterHealthServer(s.grpcServer, s.health)
+}
+
+func (s *SSESuite) serve(handler Handler, target string, header http.Header) *httptest.ResponseRecorder {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost"+target, nil).WithContext(ctx)
+	for k, vv := range header {
+		for _, v := range vv {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("Accept", ContentTypeEventStream)
+
+	rec := httptest.NewRecorder()
+	Make(nil, GRPCHandler(s.grpcServer), handler).ServeHTTP(rec, req)
+
+	return rec
+}
+
+// assertEvent asserts that the body consists of a single event, comparing its data as JSON
+func (s *SSESuite) assertEvent(body, event, id, data string) {
+	s.True(strings.HasSuffix(body, "\n\n"), body)
+
+	fields := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n\n"), "\n") {
+		kv := strings.SplitN(line, ": ", 2)
+		s.Len(kv, 2, line)
+		if len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+
+	s.Equal(event, fields["event"], body)
+	s.Equal(id, fields["id"], body)
+	s.JSONEq(data, fields["data"], body)
+}
+
+func (s *SSESuite) TestIsSSERequest() {
+	request := func(method, accept string) *http.Request {
+		req := httptest.NewRequest(method, "http://localhost/grpc.health.v1.Health/Watch", nil)
+		req.Header.Set("Accept", accept)
+		return req
+	}
+
+	candidates := map[*http.Request]bool{
+		request(http.MethodGet, "text/event-stream"):                   true,
+		request(http.MethodGet, "text/event-stream, application/json"): true,
+		request(http.MethodGet, "application/json"):                    false,
+		request(http.MethodPost, "text/event-stream"):                  false,
+	}
+
+	for req, result := range candidates {
+		s.Equal(result, IsSSERequest(req), "Request is badly considered an SSE request", req.Method, req.Header)
+	}
+}
+
+func (s *SSESuite) TestStream() {
+	rec := s.serve(SSEHandler(s.grpcServer), "/grpc.health.v1.Health/Watch?service=echo", http.Header{"X-Caller": {"sse"}})
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal(ContentTypeEventStream, rec.Header().Get("Content-Type"))
+	s.Equal("no-cache", rec.Header().Get("Cache-Control"))
+	s.assertEvent(rec.Body.String(), "", "1", `{"status":"SERVING"}`)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Equal("sse", s.incoming.Get("x-caller"))
+}
+
+func (s *SSESuite) TestOutcome() {
+	rec := s.serve(SSEHandler(s.grpcServer), "/grpc.health.v1.Health/Watch", http.Header{"X-Outcome": {"end"}})
+	s.assertEvent(rec.Body.String(), SSEEndEvent, "", `{}`)
+
+	rec = s.serve(SSEHandler(s.grpcServer), "/grpc.health.v1.Health/Watch", http.Header{"X-Outcome": {"fail"}})
+	s.assertEvent(rec.Body.String(), SSEErrorEvent, "", `{"code":7,"message":"watch denied"}`)
+
+	// unknown and unary methods are rejected before the stream starts
+	rec = s.serve(SSEHandler(s.grpcServer), "/grpc.health.v1.Health/Unknown", nil)
+	s.Equal(http.StatusNotFound, rec.Code)
+
+	rec = s.serve(SSEHandler(s.grpcServer), "/grpc.health.v1.Health/Check", nil)
+	s.Equal(http.StatusBadRequest, rec.Code)
+}
+
+func (s *SSESuite) TestResume() {
+	var lastEventID string
+	handler := NewSSEHandler(s.grpcServer,
+		WithSSEResume(func(r *http.Request, id string, in proto.Message) error {
+			lastEventID = id
+			in.(*hpb.HealthCheckRequest).Service = "echo"
+			return nil
+		}),
+	)
+
+	rec := s.serve(handler, "/grpc.health.v1.Health/Watch?service=other", http.Header{SSELastEventIDHeader: {"7"}})
+	s.Equal("7", lastEventID)
+	s.assertEvent(rec.Body.String(), "", "8", `{"status":"SERVING"}`)
+
+	handler = NewSSEHandler(s.grpcServer,
+		WithSSEEventID(func(out proto.Message, seq int) string {
+			return "health-" + out.(*hpb.HealthCheckResponse).Status.String()
+		}),
+		WithSSEResume(func(r *http.Request, id string, in proto.Message) error {
+			return status.Error(codes.InvalidArgument, "unknown event")
+		}),
+	)
+
+	rec = s.serve(handler, "/grpc.health.v1.Health/Watch?service=echo", nil)
+	s.assertEvent(rec.Body.String(), "", "health-SERVING", `{"status":"SERVING"}`)
+
+	rec = s.serve(handler, "/grpc.health.v1.Health/Watch?service=echo", http.Header{SSELastEventIDHeader: {"health-SERVING"}})
+	s.Equal(http.StatusBadRequest, rec.Code)
+}
+
+func (s *SSESuite) TestHeartbeat() {
+	rec := s.serve(NewSSEHandler(s.grpcServer, WithSSEHeartbeat(time.Millisecond*20)), "/grpc.health.v1.Health/Watch?service=echo", nil)
+	events := strings.SplitAfter(rec.Body.String(), "\n\n")
+	s.assertEvent(events[0], "", "1", `{"status":"SERVING"}`)
+	s.Contains(events[1:], ": heartbeat\n\n")
+}
+
+func TestSSESuite(t *testing.T) {
+	suite.Run(t, &SSESuite{})
+}
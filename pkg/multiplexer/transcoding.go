@@ -0,0 +1,360 @@
+package multiplexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/utilities"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// transcodingMarshaler is the marshaler of the grpc-gateway defaults, so the
+// transcoded responses are the same as the responses of the gateway
+var transcodingMarshaler = &runtime.JSONPb{
+	MarshalOptions: protojson.MarshalOptions{
+		EmitUnpopulated: true,
+	},
+	UnmarshalOptions: protojson.UnmarshalOptions{
+		DiscardUnknown: true,
+	},
+}
+
+// transcodingBinding is a single http rule of a grpc method
+type transcodingBinding struct {
+	method       protoreflect.MethodDescriptor
+	httpMethod   string
+	template     *pathTemplate
+	body         string
+	responseBody string
+}
+
+// Transcoder is a http.Handler translating JSON requests into grpc calls by the
+// google.api.http annotations of the methods registered on the grpc server. The methods
+// are called in-process through the LocalConn, so no loopback connection to the server
+// is needed, unlike for the grpc-gateway handlers registered from an endpoint.
+//
+// Path variables, query parameters, request bodies and metadata headers are mapped
+// the same way the grpc-gateway maps them, including its error responses
+type Transcoder struct {
+	conn     *LocalConn
+	bindings []*transcodingBinding
+}
+
+// NewTranscoder creates a Transcoder for the methods registered on the server. All
+// services must be registered on the server before the Transcoder is created, and
+// their generated packages must be imported so their descriptors are available
+func NewTranscoder(server ServiceRegistry) (*Transcoder, error) {
+	t := &Transcoder{
+		conn: NewLocalConn(server),
+	}
+
+	for _, md := range registeredMethods(server) {
+		rule, ok := proto.GetExtension(md.Options(), annotations.E_Http).(*annotations.HttpRule)
+		if !ok || rule == nil {
+			continue
+		}
+		if md.IsStreamingClient() {
+			// client streams cannot be mapped onto a single http request
+			continue
+		}
+
+		for _, r := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+			b, err := newTranscodingBinding(md, r)
+			if err != nil {
+				return nil, err
+			}
+			t.bindings = append(t.bindings, b)
+		}
+	}
+
+	return t, nil
+}
+
+func newTranscodingBinding(md protoreflect.MethodDescriptor, rule *annotations.HttpRule) (*transcodingBinding, error) {
+	var httpMethod, template string
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		httpMethod, template = http.MethodGet, p.Get
+	case *annotations.HttpRule_Put:
+		httpMethod, template = http.MethodPut, p.Put
+	case *annotations.HttpRule_Post:
+		httpMethod, template = http.MethodPost, p.Post
+	case *annotations.HttpRule_Delete:
+		httpMethod, template = http.MethodDelete, p.Delete
+	case *annotations.HttpRule_Patch:
+		httpMethod, template = http.MethodPatch, p.Patch
+	case *annotations.HttpRule_Custom:
+		httpMethod, template = p.Custom.GetKind(), p.Custom.GetPath()
+	default:
+		return nil, fmt.Errorf("transcoding: %s has no http pattern", md.FullName())
+	}
+
+	tmpl, err := parsePathTemplate(template)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", md.FullName(), err)
+	}
+
+	return &transcodingBinding{
+		method:       md,
+		httpMethod:   httpMethod,
+		template:     tmpl,
+		body:         rule.GetBody(),
+		responseBody: rule.GetResponseBody(),
+	}, nil
+}
+
+// TranscodingHandler fulfills requests matching a http rule of the transcoder
+func TranscodingHandler(transcoder *Transcoder, selectors ...Selector) Handler {
+	filter := append([]Selector{transcoder.Matches}, selectors...)
+
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for _, f := range filter {
+			if !f(r) {
+				return false
+			}
+		}
+
+		transcoder.ServeHTTP(w, r)
+		return true
+	}
+}
+
+// Matches returns true if the request matches a http rule of the transcoder
+func (t *Transcoder) Matches(r *http.Request) bool {
+	_, _, ok := t.match(r)
+	return ok
+}
+
+func (t *Transcoder) match(r *http.Request) (*transcodingBinding, map[string]string, bool) {
+	for _, b := range t.bindings {
+		if b.httpMethod != r.Method {
+			continue
+		}
+
+		if params, ok := b.template.match(r.URL.EscapedPath()); ok {
+			return b, params, true
+		}
+	}
+
+	return nil, nil, false
+}
+
+// ServeHTTP calls the grpc method of the http rule matching the request
+func (t *Transcoder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, params, ok := t.match(r)
+	if !ok {
+		writeGatewayStatus(w, status.New(codes.NotFound, "transcoding: no http rule matches the request"))
+		return
+	}
+
+	in := newMessage(b.method.Input())
+	if err := b.decode(r, in, params); err != nil {
+		writeGatewayStatus(w, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+
+	ctx := metadata.NewOutgoingContext(r.Context(), transcodingMetadata(r))
+	if b.method.IsStreamingServer() {
+		t.serveStream(w, r.WithContext(ctx), b, in)
+		return
+	}
+
+	var header, trailer metadata.MD
+	out := newMessage(b.method.Output())
+	err := t.conn.Invoke(ctx, fullMethodName(b.method), in, out, grpc.Header(&header), grpc.Trailer(&trailer))
+
+	setGatewayMetadata(w.Header(), runtime.MetadataHeaderPrefix, header)
+	setGatewayMetadata(w.Header(), runtime.MetadataTrailerPrefix, trailer)
+	if err != nil {
+		writeGatewayStatus(w, status.Convert(err))
+		return
+	}
+
+	data, err := b.encode(out)
+	if err != nil {
+		writeGatewayStatus(w, status.New(codes.Internal, err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// serveStream calls the server streaming method, writing the responses as newline
+// delimited JSON objects with the result or the error, as the grpc-gateway does
+func (t *Transcoder) serveStream(w http.ResponseWriter, r *http.Request, b *transcodingBinding, in proto.Message) {
+	cs, err := t.conn.NewStream(r.Context(), &grpc.StreamDesc{ServerStreams: true}, fullMethodName(b.method))
+	if err != nil {
+		writeGatewayStatus(w, status.Convert(err))
+		return
+	}
+	if err := cs.SendMsg(in); err != nil && err != io.EOF {
+		writeGatewayStatus(w, status.Convert(err))
+		return
+	}
+	if err := cs.CloseSend(); err != nil {
+		writeGatewayStatus(w, status.Convert(err))
+		return
+	}
+
+	header, err := cs.Header()
+	if err != nil {
+		writeGatewayStatus(w, status.FromContextError(err))
+		return
+	}
+	setGatewayMetadata(w.Header(), runtime.MetadataHeaderPrefix, header)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		out := newMessage(b.method.Output())
+		err := cs.RecvMsg(out)
+		if err == io.EOF {
+			return
+		}
+
+		if err != nil {
+			writeStreamChunk(w, "error", status.Convert(err).Proto())
+			return
+		}
+
+		data, err := b.encode(out)
+		if err != nil {
+			writeStreamChunk(w, "error", status.New(codes.Internal, err.Error()).Proto())
+			return
+		}
+		writeStreamChunk(w, "result", json.RawMessage(data))
+	}
+}
+
+// writeStreamChunk writes the value under the given key as a single line of the stream
+func writeStreamChunk(w http.ResponseWriter, key string, value interface{}) {
+	if m, ok := value.(proto.Message); ok {
+		data, err := transcodingMarshaler.Marshal(m)
+		if err != nil {
+			data = []byte(`{"code":13,"message":"failed to marshal the status"}`)
+		}
+		value = json.RawMessage(data)
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{key: value})
+	_, _ = w.Write(append(data, '\n'))
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// decode populates the input message from the path variables, the body and the
+// query parameters of the request
+func (b *transcodingBinding) decode(r *http.Request, in proto.Message, params map[string]string) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	if len(bytes.TrimSpace(body)) > 0 {
+		switch b.body {
+		case "":
+		case "*":
+			if err := transcodingMarshaler.Unmarshal(body, in); err != nil {
+				return err
+			}
+		default:
+			fd := in.ProtoReflect().Descriptor().Fields().ByName(protoreflect.Name(b.body))
+			if fd == nil {
+				return fmt.Errorf("transcoding: unknown body field %q", b.body)
+			}
+
+			// the body is decoded as the JSON of the field, so all field types are supported
+			wrapped := append(append([]byte(`{"`+b.body+`":`), body...), '}')
+			if err := transcodingMarshaler.Unmarshal(wrapped, in); err != nil {
+				return err
+			}
+		}
+	}
+
+	filter := [][]string{}
+	for variable, value := range params {
+		if err := runtime.PopulateFieldFromPath(in, variable, value); err != nil {
+			return err
+		}
+		filter = append(filter, strings.Split(variable, "."))
+	}
+
+	if b.body == "*" {
+		return nil
+	}
+	if b.body != "" {
+		filter = append(filter, []string{b.body})
+	}
+
+	return runtime.PopulateQueryParameters(in, r.URL.Query(), utilities.NewDoubleArray(filter))
+}
+
+// encode marshals the response message, or its field selected by the response_body
+func (b *transcodingBinding) encode(out proto.Message) ([]byte, error) {
+	data, err := transcodingMarshaler.Marshal(out)
+	if err != nil || b.responseBody == "" {
+		return data, err
+	}
+
+	fd := out.ProtoReflect().Descriptor().Fields().ByName(protoreflect.Name(b.responseBody))
+	if fd == nil {
+		return nil, fmt.Errorf("transcoding: unknown response body field %q", b.responseBody)
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields[fd.JSONName()], nil
+}
+
+// transcodingMetadata collects the incoming metadata of the request the same way the
+// grpc-gateway does: the Grpc-Metadata- headers are stripped of the prefix and the
+// permanent http headers are prefixed by grpcgateway-
+func transcodingMetadata(r *http.Request) metadata.MD {
+	md := metadata.MD{}
+	for k, vv := range r.Header {
+		if k == "Authorization" {
+			md.Append("authorization", vv...)
+		}
+
+		if key, ok := runtime.DefaultHeaderMatcher(k); ok {
+			if strings.HasSuffix(strings.ToLower(key), "-bin") {
+				for _, v := range vv {
+					if data, err := decodeBinaryHeader(v); err == nil {
+						md.Append(key, string(data))
+					}
+				}
+				continue
+			}
+			md.Append(key, vv...)
+		}
+	}
+
+	return md
+}
+
+// setGatewayMetadata adds the grpc metadata into the response headers under the prefix
+func setGatewayMetadata(h http.Header, prefix string, md metadata.MD) {
+	for k, vv := range md {
+		for _, v := range vv {
+			h.Add(prefix+k, v)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package multiplexer
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrInvalidPathTemplate is returned if the path template of the google.api.http
+	// annotation is malformed
+	ErrInvalidPathTemplate = errors.New("transcoding: invalid path template")
+)
+
+// pathTemplate is a compiled path template of the google.api.http annotation,
+// e.g. /v1/{name=shelves/*/books/*}:publish
+type pathTemplate struct {
+	template  string
+	pattern   *regexp.Regexp
+	variables []string
+}
+
+// parsePathTemplate compiles the path template into a regular expression capturing
+// the variables of the template
+func parsePathTemplate(template string) (*pathTemplate, error) {
+	if !strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("%w %q: must start with /", ErrInvalidPathTemplate, template)
+	}
+
+	path, verb := template[1:], ""
+	if pos := strings.LastIndex(path, ":"); pos >= 0 && !strings.ContainsAny(path[pos:], "}/") {
+		path, verb = path[:pos], path[pos+1:]
+		if verb == "" {
+			return nil, fmt.Errorf("%w %q: empty verb", ErrInvalidPathTemplate, template)
+		}
+	}
+
+	t := &pathTemplate{template: template}
+	sb := strings.Builder{}
+	sb.WriteString("^")
+	if path == "" {
+		sb.WriteString("/")
+	}
+
+	for len(path) > 0 {
+		sb.WriteString("/")
+
+		if path[0] == '{' {
+			end := strings.Index(path, "}")
+			if end < 0 {
+				return nil, fmt.Errorf("%w %q: unterminated variable", ErrInvalidPathTemplate, template)
+			}
+
+			variable, segments := path[1:end], "*"
+			if pos := strings.Index(variable, "="); pos >= 0 {
+				variable, segments = variable[:pos], variable[pos+1:]
+			}
+			if variable == "" || strings.ContainsAny(variable, "{}/") {
+				return nil, fmt.Errorf("%w %q: malformed variable", ErrInvalidPathTemplate, template)
+			}
+
+			pattern, err := segmentsPattern(segments)
+			if err != nil {
+				return nil, fmt.Errorf("%w %q: %v", ErrInvalidPathTemplate, template, err)
+			}
+
+			t.variables = append(t.variables, variable)
+			sb.WriteString("(" + pattern + ")")
+			path = path[end+1:]
+		} else {
+			end := strings.Index(path, "/")
+			if end < 0 {
+				end = len(path)
+			}
+
+			pattern, err := segmentsPattern(path[:end])
+			if err != nil {
+				return nil, fmt.Errorf("%w %q: %v", ErrInvalidPathTemplate, template, err)
+			}
+
+			sb.WriteString(pattern)
+			path = path[end:]
+		}
+
+		if strings.HasPrefix(path, "/") {
+			path = path[1:]
+			if path == "" {
+				return nil, fmt.Errorf("%w %q: trailing slash", ErrInvalidPathTemplate, template)
+			}
+		} else if path != "" {
+			return nil, fmt.Errorf("%w %q: unexpected %q", ErrInvalidPathTemplate, template, path)
+		}
+	}
+
+	if verb != "" {
+		sb.WriteString(":" + regexp.QuoteMeta(verb))
+	}
+	sb.WriteString("$")
+
+	pattern, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %v", ErrInvalidPathTemplate, template, err)
+	}
+	t.pattern = pattern
+
+	return t, nil
+}
+
+// segmentsPattern converts the segments of the template (literals, * and **)
+// into a regular expression
+func segmentsPattern(segments string) (string, error) {
+	patterns := []string{}
+	for _, segment := range strings.Split(segments, "/") {
+		switch segment {
+		case "":
+			return "", errors.New("empty segment")
+		case "*":
+			patterns = append(patterns, "[^/]+")
+		case "**":
+			patterns = append(patterns, ".+")
+		default:
+			if strings.ContainsAny(segment, "{}*=") {
+				return "", fmt.Errorf("malformed segment %q", segment)
+			}
+			patterns = append(patterns, regexp.QuoteMeta(segment))
+		}
+	}
+
+	return strings.Join(patterns, "/"), nil
+}
+
+// match matches the escaped path against the template, returning the unescaped
+// values of the variables
+func (t *pathTemplate) match(path string) (map[string]string, bool) {
+	matches := t.pattern.FindStringSubmatch(path)
+	if matches == nil {
+		return nil, false
+	}
+
+	values := map[string]string{}
+	for i, variable := range t.variables {
+		value, err := url.PathUnescape(matches[i+1])
+		if err != nil {
+			return nil, false
+		}
+		values[variable] = value
+	}
+
+	return values, true
+}
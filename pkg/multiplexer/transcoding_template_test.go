@@ -0,0 +1,66 @@
+package multiplexer
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type PathTemplateSuite struct {
+	suite.Suite
+}
+
+func (s *PathTemplateSuite) TestMatch() {
+	candidates := []struct {
+		template string
+		path     string
+		params   map[string]string
+	}{
+		{template: "/", path: "/", params: map[string]string{}},
+		{template: "/echo", path: "/echo", params: map[string]string{}},
+		{template: "/echo", path: "/echo/more", params: nil},
+		{template: "/v1/{name}", path: "/v1/hello%20world", params: map[string]string{"name": "hello world"}},
+		{template: "/v1/{name}", path: "/v1/a/b", params: nil},
+		{template: "/v1/{name=operations/**}", path: "/v1/operations/a/b", params: map[string]string{"name": "operations/a/b"}},
+		{template: "/v1/{name=operations/**}", path: "/v1/operations", params: nil},
+		{template: "/v1/{name=operations/**}:cancel", path: "/v1/operations/a:cancel", params: map[string]string{"name": "operations/a"}},
+		{template: "/v1/{name=operations/**}:cancel", path: "/v1/operations/a", params: nil},
+		{
+			template: "/v1/{parent=shelves/*}/books/{book.id}",
+			path:     "/v1/shelves/1/books/2",
+			params:   map[string]string{"parent": "shelves/1", "book.id": "2"},
+		},
+		{template: "/v1/*/books", path: "/v1/shelves/books", params: map[string]string{}},
+	}
+
+	for _, c := range candidates {
+		tmpl, err := parsePathTemplate(c.template)
+		s.NoError(err, c.template)
+
+		params, ok := tmpl.match(c.path)
+		s.Equal(c.params != nil, ok, c.template, c.path)
+		if ok {
+			s.Equal(c.params, params, c.template, c.path)
+		}
+	}
+}
+
+func (s *PathTemplateSuite) TestInvalid() {
+	for _, template := range []string{
+		"echo",
+		"/v1/",
+		"/v1/{name",
+		"/v1/{=a}",
+		"/v1//a",
+		"/v1/{name}x",
+		"/v1/a:",
+		"/v1/a*",
+	} {
+		_, err := parsePathTemplate(template)
+		s.True(errors.Is(err, ErrInvalidPathTemplate), template)
+	}
+}
+
+func TestPathTemplateSuite(t *testing.T) {
+	suite.Run(t, &PathTemplateSuite{})
+}
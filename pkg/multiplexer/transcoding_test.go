@@ -0,0 +1,191 @@
+package multiplexer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/grpc-ecosystem/go-grpc-middleware/util/metautils"
+	"github.com/petomalina/xrpc/v2/examples/api"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	lpb "google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	hpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// OperationsService is a fake google.longrunning.Operations service, which is annotated
+// by various http rules
+type OperationsService struct {
+	lpb.UnimplementedOperationsServer
+
+	list   *lpb.ListOperationsRequest
+	cancel *lpb.CancelOperationRequest
+}
+
+func (o *OperationsService) ListOperations(ctx context.Context, r *lpb.ListOperationsRequest) (*lpb.ListOperationsResponse, error) {
+	o.list = r
+	_ = grpc.SetHeader(ctx, metadata.Pairs("x-page", "1"))
+
+	return &lpb.ListOperationsResponse{
+		Operations: []*lpb.Operation{{Name: r.Name + "/first"}},
+	}, nil
+}
+
+func (o *OperationsService) GetOperation(ctx context.Context, r *lpb.GetOperationRequest) (*lpb.Operation, error) {
+	if r.Name == "operations/missing" {
+		return nil, status.Error(codes.NotFound, "operation not found")
+	}
+
+	return &lpb.Operation{Name: r.Name, Done: true}, nil
+}
+
+func (o *OperationsService) DeleteOperation(ctx context.Context, r *lpb.DeleteOperationRequest) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+func (o *OperationsService) CancelOperation(ctx context.Context, r *lpb.CancelOperationRequest) (*emptypb.Empty, error) {
+	o.cancel = r
+	return &emptypb.Empty{}, nil
+}
+
+type TranscodingSuite struct {
+	suite.Suite
+
+	echoService *EchoService
+	operations  *OperationsService
+	health      *health.Server
+	transcoder  *Transcoder
+	handler     http.Handler
+}
+
+func (s *TranscodingSuite) SetupTest() {
+	s.echoService = &EchoService{Logger: createLogger()}
+	s.operations = &OperationsService{}
+	s.health = health.NewServer()
+
+	grpcServer := createGrpcServer(s.echoService)
+	lpb.RegisterOperationsServer(grpcServer, s.operations)
+	hpb.RegisterHealthServer(grpcServer, s.health)
+
+	var err error
+	s.transcoder, err = NewTranscoder(grpcServer)
+	s.NoError(err)
+
+	s.handler = Make(nil,
+		GRPCHandler(grpcServer),
+		TranscodingHandler(s.transcoder),
+	)
+}
+
+func (s *TranscodingSuite) serve(method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "http://localhost"+target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(gatewayMetadataPrefix+"x-caller", "transcoding")
+
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func (s *TranscodingSuite) TestBody() {
+	var incoming metautils.NiceMD
+	s.echoService.onCall = func(ctx context.Context, m *api.EchoMessage) {
+		incoming = metautils.ExtractIncoming(ctx)
+	}
+
+	rec := s.serve(http.MethodPost, "/echo", `{"message":"Hello World","unknown":true}`)
+	s.Equal(http.StatusOK, rec.Code)
+	s.JSONEq(`{"message":"Hello World"}`, rec.Body.String())
+	s.Equal("transcoding", incoming.Get("x-caller"))
+
+	rec = s.serve(http.MethodPost, "/v1/operations/a/b:cancel", `{"name":"ignored"}`)
+	s.Equal(http.StatusOK, rec.Code)
+	s.JSONEq(`{}`, rec.Body.String())
+	s.Equal("operations/a/b", s.operations.cancel.Name)
+
+	rec = s.serve(http.MethodPost, "/echo", `{"message":`)
+	s.Equal(http.StatusBadRequest, rec.Code)
+}
+
+func (s *TranscodingSuite) TestPathAndQuery() {
+	rec := s.serve(http.MethodGet, "/v1/operations?filter=done%3Dtrue&page_size=10&pageToken=next", "")
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal("1", rec.Header().Get("Grpc-Metadata-X-Page"))
+	s.Equal("operations", s.operations.list.Name)
+	s.Equal("done=true", s.operations.list.Filter)
+	s.Equal(int32(10), s.operations.list.PageSize)
+	s.Equal("next", s.operations.list.PageToken)
+
+	res := map[string]interface{}{}
+	s.NoError(json.Unmarshal(rec.Body.Bytes(), &res))
+	s.Equal("operations/first", res["operations"].([]interface{})[0].(map[string]interface{})["name"])
+	s.Contains(res, "nextPageToken", "unpopulated fields are emitted as by the gateway")
+
+	rec = s.serve(http.MethodGet, "/v1/operations/a/b", "")
+	s.Equal(http.StatusOK, rec.Code)
+	s.Contains(rec.Body.String(), `"name":"operations/a/b"`)
+
+	rec = s.serve(http.MethodDelete, "/v1/operations/a", "")
+	s.Equal(http.StatusOK, rec.Code)
+}
+
+func (s *TranscodingSuite) TestStatus() {
+	rec := s.serve(http.MethodGet, "/v1/operations/missing", "")
+	s.Equal(http.StatusNotFound, rec.Code)
+	s.JSONEq(`{"code":5,"message":"operation not found"}`, rec.Body.String())
+
+	// requests without a matching rule are not fulfilled by the handler
+	for _, c := range [][2]string{
+		{http.MethodGet, "/echo"},
+		{http.MethodPut, "/v1/operations/a"},
+		{http.MethodPost, "/v2/operations"},
+	} {
+		s.False(s.transcoder.Matches(httptest.NewRequest(c[0], "http://localhost"+c[1], nil)), c)
+	}
+
+	rec = httptest.NewRecorder()
+	s.transcoder.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/echo", nil))
+	s.Equal(http.StatusNotFound, rec.Code)
+}
+
+func (s *TranscodingSuite) TestServerStream() {
+	// the health service is not annotated, so its binding is added by hand
+	b, err := newTranscodingBinding(hpb.File_grpc_health_v1_health_proto.Services().Get(0).Methods().ByName("Watch"),
+		&annotations.HttpRule{Pattern: &annotations.HttpRule_Get{Get: "/v1/health/{service}:watch"}})
+	s.NoError(err)
+	s.transcoder.bindings = append(s.transcoder.bindings, b)
+	s.health.SetServingStatus("echo", hpb.HealthCheckResponse_SERVING)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/v1/health/echo:watch", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	s.Equal(http.StatusOK, rec.Code)
+
+	lines := []string{}
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	s.Len(lines, 2)
+	s.JSONEq(`{"result":{"status":"SERVING"}}`, lines[0])
+	s.Contains(lines[1], `"error":{"code":`)
+}
+
+func TestTranscodingSuite(t *testing.T) {
+	suite.Run(t, &TranscodingSuite{})
+}
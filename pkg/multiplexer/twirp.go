@@ -0,0 +1,205 @@
+package multiplexer
+
+import (
+	"encoding/json"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	// TwirpPathPrefix is the default path prefix of the Twirp routes
+	TwirpPathPrefix = "/twirp"
+
+	// ContentTypeTwirpProto is the content type of Twirp binary requests
+	ContentTypeTwirpProto = "application/protobuf"
+	// ContentTypeTwirpJSON is the content type of Twirp JSON requests
+	ContentTypeTwirpJSON = "application/json"
+)
+
+// twirpCodes are the names of the grpc codes in the Twirp protocol
+var twirpCodes = map[codes.Code]string{
+	codes.Canceled:           "canceled",
+	codes.Unknown:            "unknown",
+	codes.InvalidArgument:    "invalid_argument",
+	codes.DeadlineExceeded:   "deadline_exceeded",
+	codes.NotFound:           "not_found",
+	codes.AlreadyExists:      "already_exists",
+	codes.PermissionDenied:   "permission_denied",
+	codes.ResourceExhausted:  "resource_exhausted",
+	codes.FailedPrecondition: "failed_precondition",
+	codes.Aborted:            "aborted",
+	codes.OutOfRange:         "out_of_range",
+	codes.Unimplemented:      "unimplemented",
+	codes.Internal:           "internal",
+	codes.Unavailable:        "unavailable",
+	codes.DataLoss:           "dataloss",
+	codes.Unauthenticated:    "unauthenticated",
+}
+
+// twirpHTTPStatus are the http statuses of the Twirp error codes
+var twirpHTTPStatus = map[string]int{
+	"canceled":            http.StatusRequestTimeout,
+	"unknown":             http.StatusInternalServerError,
+	"invalid_argument":    http.StatusBadRequest,
+	"malformed":           http.StatusBadRequest,
+	"deadline_exceeded":   http.StatusRequestTimeout,
+	"not_found":           http.StatusNotFound,
+	"bad_route":           http.StatusNotFound,
+	"already_exists":      http.StatusConflict,
+	"permission_denied":   http.StatusForbidden,
+	"unauthenticated":     http.StatusUnauthorized,
+	"resource_exhausted":  http.StatusTooManyRequests,
+	"failed_precondition": http.StatusPreconditionFailed,
+	"aborted":             http.StatusConflict,
+	"out_of_range":        http.StatusBadRequest,
+	"unimplemented":       http.StatusNotImplemented,
+	"internal":            http.StatusInternalServerError,
+	"unavailable":         http.StatusServiceUnavailable,
+	"dataloss":            http.StatusInternalServerError,
+}
+
+// twirpMarshaler marshals the JSON messages with the defaults of Twirp, which
+// uses the original proto field names and emits the unpopulated fields
+var twirpMarshaler = protojson.MarshalOptions{
+	UseProtoNames:   true,
+	EmitUnpopulated: true,
+}
+
+// IsTwirpRequest returns true if the request is a Twirp request, which is a POST of
+// a binary or JSON message to /twirp/<package>.<Service>/<Method>
+func IsTwirpRequest(r *http.Request) bool {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, TwirpPathPrefix+"/") {
+		return false
+	}
+
+	method := strings.TrimPrefix(r.URL.Path, TwirpPathPrefix)
+	if !isFullMethodPath(method) || !strings.Contains(method, ".") {
+		return false
+	}
+
+	switch requestContentType(r) {
+	case ContentTypeTwirpProto, ContentTypeTwirpJSON:
+		return true
+	}
+
+	return false
+}
+
+// TwirpHandler fulfills Twirp requests by calling the unary methods of the given server,
+// which is usually a *grpc.Server, in-process. Request headers are passed as the grpc
+// metadata and grpc statuses are translated into Twirp errors. The messages are resolved
+// from the global protobuf registry, so the generated packages of the services must be imported
+func TwirpHandler(server http.Handler, selectors ...Selector) Handler {
+	filter := append([]Selector{IsTwirpRequest}, selectors...)
+	conn := NewLocalConn(server)
+
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for _, f := range filter {
+			if !f(r) {
+				return false
+			}
+		}
+
+		serveTwirp(conn, w, r)
+		return true
+	}
+}
+
+func serveTwirp(conn *LocalConn, w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, TwirpPathPrefix)
+	isJSON := requestContentType(r) == ContentTypeTwirpJSON
+
+	desc, err := findMethodDescriptor(method)
+	if err != nil {
+		writeTwirpError(w, "bad_route", err.Error())
+		return
+	}
+	if desc.IsStreamingClient() || desc.IsStreamingServer() {
+		writeTwirpError(w, "bad_route", "twirp: "+method+" is a streaming method")
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeTwirpError(w, "malformed", err.Error())
+		return
+	}
+
+	in := newMessage(desc.Input())
+	if isJSON {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, in)
+	} else {
+		err = proto.Unmarshal(body, in)
+	}
+	if err != nil {
+		writeTwirpError(w, "malformed", "twirp: the request could not be decoded: "+err.Error())
+		return
+	}
+
+	md, err := requestMetadata(r.Header, "Twirp-")
+	if err != nil {
+		writeTwirpError(w, "malformed", err.Error())
+		return
+	}
+
+	var header metadata.MD
+	out := newMessage(desc.Output())
+	err = conn.Invoke(metadata.NewOutgoingContext(r.Context(), md), method, in, out, grpc.Header(&header))
+
+	setHeaderMetadata(w.Header(), "", header)
+	if err != nil {
+		st := status.Convert(err)
+		code, ok := twirpCodes[st.Code()]
+		if !ok {
+			code = twirpCodes[codes.Unknown]
+		}
+		writeTwirpError(w, code, st.Message())
+		return
+	}
+
+	var data []byte
+	if isJSON {
+		data, err = twirpMarshaler.Marshal(out)
+	} else {
+		data, err = proto.Marshal(out)
+	}
+	if err != nil {
+		writeTwirpError(w, "internal", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", requestContentType(r))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// twirpError is the JSON representation of an error in the Twirp protocol
+type twirpError struct {
+	Code string            `json:"code"`
+	Msg  string            `json:"msg"`
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// writeTwirpError writes the Twirp error with the http status of its code
+func writeTwirpError(w http.ResponseWriter, code, msg string) {
+	data, err := json.Marshal(&twirpError{Code: code, Msg: msg})
+	if err != nil {
+		data = []byte(`{"code":"internal","msg":"failed to marshal the error"}`)
+	}
+
+	httpStatus, ok := twirpHTTPStatus[code]
+	if !ok {
+		httpStatus = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	_, _ = w.Write(data)
+}
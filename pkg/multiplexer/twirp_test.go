@@ -0,0 +1,138 @@
+package multiplexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/grpc-ecosystem/go-grpc-middleware/util/metautils"
+	"github.com/petomalina/xrpc/v2/examples/api"
+	"github.com/stretchr/testify/suite"
+	lpb "google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type TwirpSuite struct {
+	suite.Suite
+
+	echoService *EchoService
+	handler     http.Handler
+}
+
+func (s *TwirpSuite) SetupTest() {
+	s.echoService = &EchoService{Logger: createLogger()}
+	grpcServer := createGrpcServer(s.echoService)
+	lpb.RegisterOperationsServer(grpcServer, &OperationsService{})
+
+	s.handler = Make(nil,
+		GRPCHandler(grpcServer),
+		TwirpHandler(grpcServer),
+	)
+}
+
+func (s *TwirpSuite) serve(path, contentType string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "http://localhost"+path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Caller", "twirp")
+
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func (s *TwirpSuite) TestIsTwirpRequest() {
+	request := func(method, path, contentType string) *http.Request {
+		req := httptest.NewRequest(method, "http://localhost"+path, nil)
+		req.Header.Set("Content-Type", contentType)
+		return req
+	}
+
+	candidates := map[*http.Request]bool{
+		request(http.MethodPost, "/twirp/api.EchoService/Call", "application/protobuf"):            true,
+		request(http.MethodPost, "/twirp/api.EchoService/Call", "application/json; charset=utf-8"): true,
+		request(http.MethodPost, "/twirp/api.EchoService/Call", "application/proto"):               false,
+		request(http.MethodGet, "/twirp/api.EchoService/Call", "application/json"):                 false,
+		request(http.MethodPost, "/api.EchoService/Call", "application/json"):                      false,
+		request(http.MethodPost, "/twirp/EchoService/Call", "application/json"):                    false,
+		request(http.MethodPost, "/twirp/v1/api.EchoService/Call", "application/json"):             false,
+		request(http.MethodPost, "/twirp/api.EchoService/", "application/json"):                    false,
+	}
+
+	for req, result := range candidates {
+		s.Equal(result, IsTwirpRequest(req), "Request is badly considered a twirp request", req.URL.Path, req.Header)
+	}
+}
+
+func (s *TwirpSuite) TestCall() {
+	var incoming metautils.NiceMD
+	s.echoService.onCall = func(ctx context.Context, m *api.EchoMessage) {
+		incoming = metautils.ExtractIncoming(ctx)
+	}
+
+	data, err := proto.Marshal(&api.EchoMessage{Message: "Hello World"})
+	s.NoError(err)
+
+	rec := s.serve("/twirp/api.EchoService/Call", ContentTypeTwirpProto, data)
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal(ContentTypeTwirpProto, rec.Header().Get("Content-Type"))
+	s.Equal("twirp", incoming.Get("x-caller"))
+
+	res := &api.EchoMessage{}
+	s.NoError(proto.Unmarshal(rec.Body.Bytes(), res))
+	s.Equal("Hello World", res.Message)
+
+	// json uses the original field names and emits unpopulated fields
+	rec = s.serve("/twirp/google.longrunning.Operations/ListOperations", ContentTypeTwirpJSON, []byte(`{"name":"operations"}`))
+	s.Equal(http.StatusOK, rec.Code)
+	s.Equal(ContentTypeTwirpJSON, rec.Header().Get("Content-Type"))
+	s.JSONEq(`{"operations":[{"name":"operations/first","metadata":null,"done":false}],"next_page_token":""}`, rec.Body.String())
+}
+
+func (s *TwirpSuite) TestErrors() {
+	candidates := map[string]struct {
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		"status": {
+			path: "/twirp/google.longrunning.Operations/GetOperation", body: `{"name":"operations/missing"}`,
+			status: http.StatusNotFound, code: "not_found",
+		},
+		"unimplemented": {
+			path: "/twirp/google.longrunning.Operations/WaitOperation", body: `{}`,
+			status: http.StatusNotImplemented, code: "unimplemented",
+		},
+		"unknown method": {
+			path: "/twirp/api.EchoService/Unknown", body: `{}`,
+			status: http.StatusNotFound, code: "bad_route",
+		},
+		"streaming method": {
+			path: "/twirp/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", body: `{}`,
+			status: http.StatusNotFound, code: "bad_route",
+		},
+		"malformed": {
+			path: "/twirp/api.EchoService/Call", body: `{"message":`,
+			status: http.StatusBadRequest, code: "malformed",
+		},
+	}
+
+	for name, c := range candidates {
+		rec := s.serve(c.path, ContentTypeTwirpJSON, []byte(c.body))
+		s.Equal(c.status, rec.Code, name)
+		s.Equal("application/json", rec.Header().Get("Content-Type"), name)
+
+		terr := &twirpError{}
+		s.NoError(json.Unmarshal(rec.Body.Bytes(), terr), name)
+		s.Equal(c.code, terr.Code, name)
+		s.NotEmpty(strings.TrimSpace(terr.Msg), name)
+	}
+}
+
+func TestTwirpSuite(t *testing.T) {
+	suite.Run(t, &TwirpSuite{})
+}
@@ -0,0 +1,337 @@
+package multiplexer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrMissingWebhookSignature is returned if the webhook request does not carry
+	// the signature header
+	ErrMissingWebhookSignature = errors.New("webhook: missing signature")
+
+	// ErrInvalidWebhookSignature is returned if the signature does not match the body
+	ErrInvalidWebhookSignature = errors.New("webhook: invalid signature")
+
+	// ErrStaleWebhookTimestamp is returned if the signed timestamp is missing or outside
+	// of the tolerated window
+	ErrStaleWebhookTimestamp = errors.New("webhook: timestamp outside of the tolerance")
+)
+
+// SignatureScheme verifies the signatures of webhook requests
+type SignatureScheme interface {
+	// Signature returns the signature carried by the request, or an empty string if
+	// the request is not signed by the scheme
+	Signature(r *http.Request) string
+	// Verify returns an error if the signature does not match the request body
+	Verify(r *http.Request, body []byte) error
+}
+
+// TimestampedScheme is implemented by the SignatureSchemes signing a timestamp along
+// with the body. The timestamp is a part of the key identifying the delivery for the
+// replay protection, so retries signed at a different time are handled again
+type TimestampedScheme interface {
+	// Timestamp returns the signed timestamp carried by the request
+	Timestamp(r *http.Request) string
+}
+
+// HMACScheme is a SignatureScheme verifying HMAC-SHA256 signatures of the request body
+// (e.g. GitHub webhooks). If the TimestampHeader is set, the signed payload is the unix
+// timestamp and the body joined by a dot, and requests with timestamps outside of the
+// tolerance are rejected (e.g. Stripe or Slack-style webhooks)
+type HMACScheme struct {
+	// Secret is the shared secret of the webhook
+	Secret []byte
+	// Header is the header carrying the signature, e.g. X-Hub-Signature-256
+	Header string
+	// Prefix is stripped from the signature header value, e.g. sha256=
+	Prefix string
+	// Base64 signatures are decoded from base64 instead of hex
+	Base64 bool
+
+	// TimestampHeader is the header carrying the unix timestamp of the signature
+	TimestampHeader string
+	// Tolerance is the maximum age of the timestamp, 5 minutes by default
+	Tolerance time.Duration
+	// Now returns the current time, time.Now by default
+	Now func() time.Time
+}
+
+// Signature returns the value of the signature header
+func (s *HMACScheme) Signature(r *http.Request) string {
+	return r.Header.Get(s.Header)
+}
+
+// Timestamp returns the value of the timestamp header, or an empty string if the
+// timestamp is not signed
+func (s *HMACScheme) Timestamp(r *http.Request) string {
+	if s.TimestampHeader == "" {
+		return ""
+	}
+
+	return r.Header.Get(s.TimestampHeader)
+}
+
+// Verify verifies the signature and the timestamp of the request
+func (s *HMACScheme) Verify(r *http.Request, body []byte) error {
+	value := s.Signature(r)
+	if value == "" {
+		return ErrMissingWebhookSignature
+	}
+
+	var signature []byte
+	var err error
+	if s.Base64 {
+		signature, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(value, s.Prefix))
+	} else {
+		signature, err = hex.DecodeString(strings.TrimPrefix(value, s.Prefix))
+	}
+	if err != nil {
+		return ErrInvalidWebhookSignature
+	}
+
+	mac := hmac.New(sha256.New, s.Secret)
+	if s.TimestampHeader != "" {
+		timestamp := s.Timestamp(r)
+		if err := s.verifyTimestamp(timestamp); err != nil {
+			return err
+		}
+
+		mac.Write([]byte(timestamp + "."))
+	}
+	mac.Write(body)
+
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return ErrInvalidWebhookSignature
+	}
+
+	return nil
+}
+
+func (s *HMACScheme) verifyTimestamp(timestamp string) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrStaleWebhookTimestamp
+	}
+
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+
+	tolerance := s.Tolerance
+	if tolerance == 0 {
+		tolerance = time.Minute * 5
+	}
+
+	age := now().Sub(time.Unix(seconds, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrStaleWebhookTimestamp
+	}
+
+	return nil
+}
+
+// WebhookMetaAttribute are attributes of the webhook request exposed as headers
+type WebhookMetaAttribute string
+
+const (
+	// WebhookMetaEvent is the event type of the webhook (see WithWebhookEventHeader)
+	WebhookMetaEvent WebhookMetaAttribute = "event"
+	// WebhookMetaDelivery is the unique ID of the webhook delivery (see WithWebhookDeliveryHeader)
+	WebhookMetaDelivery WebhookMetaAttribute = "delivery"
+)
+
+// WebhookMetaAttributeHeader creates a key for header access to the webhook meta
+// information (see WebhookMetaAttribute)
+func WebhookMetaAttributeHeader(attr WebhookMetaAttribute) string {
+	return "x-webhook-" + string(attr)
+}
+
+// WebhookOption is an extendable builder for the WebhookHandler options
+type WebhookOption func(o *webhookOptions)
+
+type webhookOptions struct {
+	selectors []Selector
+
+	eventHeader string
+	routes      map[string]string
+
+	deliveryHeader string
+	replayStore    DedupStore
+	replayTTL      time.Duration
+}
+
+// WithWebhookSelectors adds selectors that must be fulfilled on top of the signature
+// presence
+func WithWebhookSelectors(selectors ...Selector) WebhookOption {
+	return func(o *webhookOptions) {
+		o.selectors = append(o.selectors, selectors...)
+	}
+}
+
+// WithWebhookEventHeader sets the header carrying the event type, e.g. X-GitHub-Event
+func WithWebhookEventHeader(header string) WebhookOption {
+	return func(o *webhookOptions) {
+		o.eventHeader = header
+	}
+}
+
+// WithWebhookRoute rewrites the path of the requests of the given event type to the
+// gateway path, e.g. the path of the GRPC method handling the event. Requests of
+// events without a route keep their path
+func WithWebhookRoute(event, path string) WebhookOption {
+	return func(o *webhookOptions) {
+		if o.routes == nil {
+			o.routes = map[string]string{}
+		}
+		o.routes[event] = path
+	}
+}
+
+// WithWebhookDeliveryHeader sets the header carrying the unique ID of the delivery,
+// e.g. X-GitHub-Delivery. The ID is passed to the handler, but it does not identify
+// the deliveries for the replay protection, as it is not covered by the signature
+func WithWebhookDeliveryHeader(header string) WebhookOption {
+	return func(o *webhookOptions) {
+		o.deliveryHeader = header
+	}
+}
+
+// WithWebhookReplayProtection acknowledges repeated deliveries within the ttl window
+// without passing them to the handler. Deliveries are identified by their signed
+// payload, i.e. the body and the signed timestamp of TimestampedSchemes, so they
+// cannot be replayed by changing unsigned headers. Failed deliveries are released,
+// so their retries are handled again
+func WithWebhookReplayProtection(store DedupStore, ttl time.Duration) WebhookOption {
+	return func(o *webhookOptions) {
+		o.replayStore = store
+		o.replayTTL = ttl
+	}
+}
+
+// WebhookHandler fulfills POST requests signed by the given scheme. Requests with invalid
+// signatures are rejected with 401 Unauthorized, others are routed by their event type
+// and passed to the handler with their body untouched. Replays of deliveries that are
+// still being handled are rejected with 409 Conflict, so they are retried if the
+// handling fails (see WithWebhookReplayProtection)
+func WebhookHandler(handler http.Handler, scheme SignatureScheme, opts ...WebhookOption) Handler {
+	options := &webhookOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	filter := append([]Selector{isPostRequest, func(r *http.Request) bool {
+		return scheme.Signature(r) != ""
+	}}, options.selectors...)
+
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for _, f := range filter {
+			if !f(r) {
+				return false
+			}
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return true
+		}
+
+		if err := scheme.Verify(r, body); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(err.Error()))
+			return true
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+		options.apply(r)
+
+		key := options.replayKey(r, scheme, body)
+		if key == "" {
+			handler.ServeHTTP(w, r)
+			return true
+		}
+
+		state, err := options.replayStore.Reserve(r.Context(), key, options.replayTTL)
+		if err == nil && state == DedupCommitted {
+			// replayed deliveries are acknowledged without reaching the handler
+			w.WriteHeader(http.StatusOK)
+			return true
+		}
+		if err == nil && state == DedupInFlight {
+			// the first delivery may still fail, so the replay must be retried
+			w.WriteHeader(http.StatusConflict)
+			return true
+		}
+
+		rec := newResponseRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.status < 200 || rec.status > 299 {
+			_ = options.replayStore.Release(r.Context(), key)
+		} else {
+			_ = options.replayStore.Commit(r.Context(), key, options.replayTTL)
+		}
+
+		rec.writeTo(w)
+		return true
+	}
+}
+
+func isPostRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost
+}
+
+// apply routes the request by its event type and adds the webhook metadata into headers
+func (o *webhookOptions) apply(r *http.Request) {
+	// the Grpc-Metadata- prefix is stripped by the grpc-gateway, so these headers
+	// are accessible by their original names
+	if o.deliveryHeader != "" {
+		if delivery := r.Header.Get(o.deliveryHeader); delivery != "" {
+			r.Header.Set(gatewayMetadataPrefix+WebhookMetaAttributeHeader(WebhookMetaDelivery), delivery)
+		}
+	}
+
+	if o.eventHeader == "" {
+		return
+	}
+
+	event := r.Header.Get(o.eventHeader)
+	if event == "" {
+		return
+	}
+	r.Header.Set(gatewayMetadataPrefix+WebhookMetaAttributeHeader(WebhookMetaEvent), event)
+
+	if path, ok := o.routes[event]; ok {
+		r.URL.Path = path
+		r.URL.RawPath = ""
+		r.RequestURI = r.URL.RequestURI()
+	}
+}
+
+// replayKey returns the key identifying the delivery in the replay store, or an empty
+// key if the replay protection is disabled. The key is the hash of the verified payload,
+// so it does not depend on the encoding of the signature either
+func (o *webhookOptions) replayKey(r *http.Request, scheme SignatureScheme, body []byte) string {
+	if o.replayStore == nil {
+		return ""
+	}
+
+	sum := sha256.New()
+	if ts, ok := scheme.(TimestampedScheme); ok {
+		sum.Write([]byte(ts.Timestamp(r) + "."))
+	}
+	sum.Write(body)
+
+	return "payload/" + hex.EncodeToString(sum.Sum(nil))
+}
@@ -0,0 +1,234 @@
+package multiplexer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testingWebhookSecret = "webhook-secret"
+
+type WebhookSuite struct {
+	suite.Suite
+
+	now    time.Time
+	paths  []string
+	bodies []string
+	events []string
+	status int
+}
+
+func (s *WebhookSuite) SetupTest() {
+	s.now = time.Unix(1600000000, 0)
+	s.paths, s.bodies, s.events = nil, nil, nil
+	s.status = http.StatusOK
+}
+
+func (s *WebhookSuite) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		s.paths = append(s.paths, r.URL.Path)
+		s.bodies = append(s.bodies, string(data))
+		s.events = append(s.events, r.Header.Get(gatewayMetadataPrefix+WebhookMetaAttributeHeader(WebhookMetaEvent)))
+		w.WriteHeader(s.status)
+	})
+}
+
+func signWebhook(payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(testingWebhookSecret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func (s *WebhookSuite) request(body string, header map[string]string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/webhooks/github", strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	return req
+}
+
+func (s *WebhookSuite) serve(handler Handler, req *http.Request) int {
+	rec := httptest.NewRecorder()
+	s.True(handler(rec, req))
+
+	return rec.Code
+}
+
+func (s *WebhookSuite) TestGitHubStyle() {
+	handler := WebhookHandler(s.handler(),
+		&HMACScheme{Secret: []byte(testingWebhookSecret), Header: "X-Hub-Signature-256", Prefix: "sha256="},
+		WithWebhookEventHeader("X-GitHub-Event"),
+		WithWebhookRoute("push", "/v1/echo"),
+	)
+
+	body := `{"message":"Hello World"}`
+	signature := "sha256=" + hex.EncodeToString(signWebhook(body))
+
+	s.Equal(http.StatusOK, s.serve(handler, s.request(body, map[string]string{
+		"X-Hub-Signature-256": signature,
+		"X-GitHub-Event":      "push",
+	})))
+	s.Equal(http.StatusOK, s.serve(handler, s.request(body, map[string]string{
+		"X-Hub-Signature-256": signature,
+		"X-GitHub-Event":      "ping",
+	})))
+
+	s.Equal([]string{"/v1/echo", "/webhooks/github"}, s.paths)
+	s.Equal([]string{body, body}, s.bodies)
+	s.Equal([]string{"push", "ping"}, s.events)
+
+	// tampered bodies are rejected
+	s.Equal(http.StatusUnauthorized, s.serve(handler, s.request(`{"message":"tampered"}`, map[string]string{
+		"X-Hub-Signature-256": signature,
+	})))
+	s.Equal(http.StatusUnauthorized, s.serve(handler, s.request(body, map[string]string{
+		"X-Hub-Signature-256": "sha256=not-hex",
+	})))
+	s.Len(s.bodies, 2)
+
+	// unsigned requests are not fulfilled
+	s.False(handler(httptest.NewRecorder(), s.request(body, nil)))
+}
+
+func (s *WebhookSuite) TestTimestampTolerance() {
+	handler := WebhookHandler(s.handler(), &HMACScheme{
+		Secret:          []byte(testingWebhookSecret),
+		Header:          "X-Signature",
+		Base64:          true,
+		TimestampHeader: "X-Timestamp",
+		Tolerance:       time.Minute,
+		Now: func() time.Time {
+			return s.now
+		},
+	})
+
+	body := `{"message":"Hello World"}`
+	signed := func(ts time.Time) map[string]string {
+		timestamp := strconv.FormatInt(ts.Unix(), 10)
+		return map[string]string{
+			"X-Signature": base64.StdEncoding.EncodeToString(signWebhook(timestamp + "." + body)),
+			"X-Timestamp": timestamp,
+		}
+	}
+
+	s.Equal(http.StatusOK, s.serve(handler, s.request(body, signed(s.now.Add(-time.Second*30)))))
+	s.Equal(http.StatusUnauthorized, s.serve(handler, s.request(body, signed(s.now.Add(-time.Minute*2)))))
+	s.Equal(http.StatusUnauthorized, s.serve(handler, s.request(body, signed(s.now.Add(time.Minute*2)))))
+
+	// the timestamp is part of the signed payload
+	header := signed(s.now)
+	header["X-Timestamp"] = strconv.FormatInt(s.now.Add(time.Second).Unix(), 10)
+	s.Equal(http.StatusUnauthorized, s.serve(handler, s.request(body, header)))
+
+	s.Len(s.bodies, 1)
+}
+
+func (s *WebhookSuite) TestReplayProtection() {
+	handler := WebhookHandler(s.handler(),
+		&HMACScheme{Secret: []byte(testingWebhookSecret), Header: "X-Hub-Signature-256", Prefix: "sha256="},
+		WithWebhookDeliveryHeader("X-GitHub-Delivery"),
+		WithWebhookReplayProtection(NewMemoryDedupStore(100), time.Hour),
+	)
+
+	body := `{"message":"Hello World"}`
+	header := map[string]string{
+		"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(signWebhook(body)),
+		"X-GitHub-Delivery":   "72d3162e-cc78-11e3-81ab-4c9367dc0958",
+	}
+
+	s.Equal(http.StatusOK, s.serve(handler, s.request(body, header)))
+	s.Equal(http.StatusOK, s.serve(handler, s.request(body, header)))
+	s.Len(s.bodies, 1)
+
+	// replays of the signed body do not reach the handler with changed unsigned headers
+	header["X-GitHub-Delivery"] = "8f6e1b1c-cc78-11e3-81ab-4c9367dc0958"
+	s.Equal(http.StatusOK, s.serve(handler, s.request(body, header)))
+	header["X-Hub-Signature-256"] = "sha256=" + strings.ToUpper(hex.EncodeToString(signWebhook(body)))
+	s.Equal(http.StatusOK, s.serve(handler, s.request(body, header)))
+	s.Len(s.bodies, 1)
+
+	// failed deliveries are handled again when retried
+	s.status = http.StatusServiceUnavailable
+	body = `{"message":"Hello Again"}`
+	header["X-Hub-Signature-256"] = "sha256=" + hex.EncodeToString(signWebhook(body))
+	s.Equal(http.StatusServiceUnavailable, s.serve(handler, s.request(body, header)))
+	s.Equal(http.StatusServiceUnavailable, s.serve(handler, s.request(body, header)))
+	s.Len(s.bodies, 3)
+}
+
+func (s *WebhookSuite) TestTimestampedReplayProtection() {
+	handler := WebhookHandler(s.handler(),
+		&HMACScheme{
+			Secret:          []byte(testingWebhookSecret),
+			Header:          "X-Signature",
+			Base64:          true,
+			TimestampHeader: "X-Timestamp",
+			Now: func() time.Time {
+				return s.now
+			},
+		},
+		WithWebhookReplayProtection(NewMemoryDedupStore(100), time.Hour),
+	)
+
+	body := `{"message":"Hello World"}`
+	signed := func(ts time.Time) map[string]string {
+		timestamp := strconv.FormatInt(ts.Unix(), 10)
+		return map[string]string{
+			"X-Signature": base64.StdEncoding.EncodeToString(signWebhook(timestamp + "." + body)),
+			"X-Timestamp": timestamp,
+		}
+	}
+
+	s.Equal(http.StatusOK, s.serve(handler, s.request(body, signed(s.now))))
+	s.Equal(http.StatusOK, s.serve(handler, s.request(body, signed(s.now))))
+	s.Len(s.bodies, 1)
+
+	// retries signed at a different time are new deliveries
+	s.Equal(http.StatusOK, s.serve(handler, s.request(body, signed(s.now.Add(time.Second)))))
+	s.Len(s.bodies, 2)
+}
+
+func (s *WebhookSuite) TestReplayInFlight() {
+	entered := make(chan struct{})
+	proceed := make(chan struct{})
+	handler := WebhookHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-proceed
+	}),
+		&HMACScheme{Secret: []byte(testingWebhookSecret), Header: "X-Hub-Signature-256", Prefix: "sha256="},
+		WithWebhookReplayProtection(NewMemoryDedupStore(100), time.Hour),
+	)
+
+	body := `{"message":"Hello World"}`
+	header := map[string]string{"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(signWebhook(body))}
+
+	first := make(chan int)
+	go func() {
+		first <- s.serve(handler, s.request(body, header))
+	}()
+	<-entered
+
+	// the replay is rejected while the first delivery is being handled, as it may still fail
+	s.Equal(http.StatusConflict, s.serve(handler, s.request(body, header)))
+
+	proceed <- struct{}{}
+	s.Equal(http.StatusOK, <-first)
+
+	// the handled delivery is committed, so its replays are acknowledged
+	s.Equal(http.StatusOK, s.serve(handler, s.request(body, header)))
+}
+
+func TestWebhookSuite(t *testing.T) {
+	suite.Run(t, &WebhookSuite{})
+}
@@ -0,0 +1,329 @@
+package multiplexer
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// WebSocketProtocolJSON is the subprotocol of WebSockets exchanging protojson text messages,
+	// which is also used if the client does not request any subprotocol
+	WebSocketProtocolJSON = "xrpc.json"
+	// WebSocketProtocolProto is the subprotocol of WebSockets exchanging binary proto messages
+	WebSocketProtocolProto = "xrpc.proto"
+
+	// WebSocketEndOfStream is the text message closing the client stream. It is never a valid
+	// message of either subprotocol, as protojson messages are objects and proto messages are
+	// sent as binary messages, so messages of any content can be sent, including empty ones
+	WebSocketEndOfStream = "xrpc.eos"
+
+	// WebSocketCloseCodeOffset is added to the grpc code of the call status when the
+	// WebSocket is closed with an error, e.g. NotFound closes the WebSocket with 4005.
+	// Successful calls are closed with the 1000 normal closure
+	WebSocketCloseCodeOffset = 4000
+
+	// webSocketCloseTimeout is the time given to the client to acknowledge the close message
+	webSocketCloseTimeout = time.Second
+	// webSocketMaxCloseReason is the maximum length of the close reason of a control frame
+	webSocketMaxCloseReason = 123
+)
+
+// IsWebSocketRequest returns true if the request is a WebSocket handshake
+func IsWebSocketRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet && websocket.IsWebSocketUpgrade(r)
+}
+
+// WebSocketHandler bridges WebSockets opened to /<package>.<Service>/<Method> onto the streaming
+// methods registered on the server (see NewWebSocketHandler)
+func WebSocketHandler(server ServiceRegistry, selectors ...Selector) Handler {
+	return NewWebSocketHandler(server, WithWebSocketSelectors(selectors...))
+}
+
+// WebSocketOption is an extendable builder for the WebSocketHandler options
+type WebSocketOption func(o *webSocketOptions)
+
+type webSocketOptions struct {
+	selectors   []Selector
+	checkOrigin func(r *http.Request) bool
+	readLimit   int64
+}
+
+// WithWebSocketSelectors adds selectors that must be fulfilled on top of the IsWebSocketRequest selector
+func WithWebSocketSelectors(selectors ...Selector) WebSocketOption {
+	return func(o *webSocketOptions) {
+		o.selectors = append(o.selectors, selectors...)
+	}
+}
+
+// WithWebSocketCheckOrigin sets the function deciding whether the origin of the handshake
+// is allowed. Only the same origin is allowed by default
+func WithWebSocketCheckOrigin(check func(r *http.Request) bool) WebSocketOption {
+	return func(o *webSocketOptions) {
+		o.checkOrigin = check
+	}
+}
+
+// WithWebSocketReadLimit sets the maximum size of the messages read from the client in
+// bytes, 4MB by default. WebSockets sending larger messages are closed with the 1009 close
+// code and their calls are canceled. Messages are not limited if the limit is not positive
+func WithWebSocketReadLimit(limit int64) WebSocketOption {
+	return func(o *webSocketOptions) {
+		o.readLimit = limit
+	}
+}
+
+// NewWebSocketHandler creates a WebSocketHandler configured by the given options. Every WebSocket
+// calls a single client-, server- or bidi-streaming method named by the path of the handshake.
+// Messages are exchanged as protojson text messages or binary proto messages, depending on
+// the negotiated subprotocol (WebSocketProtocolJSON or WebSocketProtocolProto). The WebSocketEndOfStream
+// message closes the sending side of the client and the status of the call is sent in the close
+// message (see WebSocketCloseCodeOffset). Headers and query parameters of the handshake are passed
+// as the grpc metadata, as browsers can't set custom headers on WebSockets. Handshakes of methods
+// not registered on the server are rejected with 404 Not Found. All services must be registered
+// on the server before the handler is created
+func NewWebSocketHandler(server ServiceRegistry, opts ...WebSocketOption) Handler {
+	options := &webSocketOptions{
+		readLimit: 4 << 20,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	filter := append([]Selector{IsWebSocketRequest, func(r *http.Request) bool {
+		return isFullMethodPath(r.URL.Path)
+	}}, options.selectors...)
+
+	b := &webSocketBridge{
+		conn:    NewLocalConn(server),
+		methods: map[string]protoreflect.MethodDescriptor{},
+		upgrader: websocket.Upgrader{
+			Subprotocols: []string{WebSocketProtocolJSON, WebSocketProtocolProto},
+			CheckOrigin:  options.checkOrigin,
+		},
+		readLimit: options.readLimit,
+	}
+	for _, md := range registeredMethods(server) {
+		b.methods[fullMethodName(md)] = md
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		for _, f := range filter {
+			if !f(r) {
+				return false
+			}
+		}
+
+		b.ServeHTTP(w, r)
+		return true
+	}
+}
+
+// webSocketBridge forwards the messages between a WebSocket and a grpc stream
+type webSocketBridge struct {
+	conn      *LocalConn
+	methods   map[string]protoreflect.MethodDescriptor
+	upgrader  websocket.Upgrader
+	readLimit int64
+}
+
+func (b *webSocketBridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	desc, ok := b.methods[r.URL.Path]
+	if !ok {
+		http.Error(w, "websocket: method "+r.URL.Path+" not found", http.StatusNotFound)
+		return
+	}
+	if !desc.IsStreamingClient() && !desc.IsStreamingServer() {
+		http.Error(w, "websocket: "+r.URL.Path+" is not a streaming method", http.StatusBadRequest)
+		return
+	}
+
+	md, err := webSocketMetadata(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ws, err := b.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already responded with the error
+		return
+	}
+	defer ws.Close()
+	if b.readLimit > 0 {
+		ws.SetReadLimit(b.readLimit)
+	}
+	// the close message of the client is answered by the status of the canceled call
+	ws.SetCloseHandler(func(int, string) error {
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(metadata.NewOutgoingContext(r.Context(), md))
+	defer cancel()
+
+	cs, err := b.conn.NewStream(ctx, &grpc.StreamDesc{
+		ClientStreams: desc.IsStreamingClient(),
+		ServerStreams: desc.IsStreamingServer(),
+	}, fullMethodName(desc))
+	if err != nil {
+		closeWebSocket(ws, status.Convert(err))
+		return
+	}
+
+	codec := webSocketCodec{binary: ws.Subprotocol() == WebSocketProtocolProto}
+	failed := make(chan *status.Status, 1)
+	received := make(chan struct{})
+
+	go func() {
+		defer close(received)
+		failed <- receiveWebSocketMessages(ws, cs, desc, codec)
+		cancel()
+	}()
+
+	for {
+		out := newMessage(desc.Output())
+		err := cs.RecvMsg(out)
+		if err == io.EOF {
+			closeWebSocket(ws, status.New(codes.OK, ""))
+			break
+		}
+		if err != nil {
+			select {
+			case st := <-failed:
+				closeWebSocket(ws, st)
+			default:
+				closeWebSocket(ws, status.Convert(err))
+			}
+			break
+		}
+
+		messageType, data, err := codec.marshal(out)
+		if err == nil {
+			err = ws.WriteMessage(messageType, data)
+		}
+		if err != nil {
+			cancel()
+			continue
+		}
+	}
+
+	// wait for the client to acknowledge the close message
+	_ = ws.SetReadDeadline(time.Now().Add(webSocketCloseTimeout))
+	<-received
+}
+
+// receiveWebSocketMessages sends the messages read from the WebSocket to the stream until the
+// WebSocket is closed, returning the status the call is canceled with. The WebSocketEndOfStream
+// message closes the client stream
+func receiveWebSocketMessages(ws *websocket.Conn, cs grpc.ClientStream, desc protoreflect.MethodDescriptor, codec webSocketCodec) *status.Status {
+	closed := false
+	for {
+		messageType, data, err := ws.ReadMessage()
+		if err != nil {
+			// the call is canceled if it is still running, e.g. the client closed the WebSocket early
+			if ce, ok := err.(*websocket.CloseError); ok {
+				return status.Newf(codes.Canceled, "websocket: closed by the client with %d", ce.Code)
+			}
+			if err == websocket.ErrReadLimit {
+				return status.New(codes.ResourceExhausted, err.Error())
+			}
+			return status.New(codes.Canceled, err.Error())
+		}
+
+		if closed {
+			return status.New(codes.InvalidArgument, "websocket: message received after the end of the client stream")
+		}
+		if messageType == websocket.TextMessage && string(data) == WebSocketEndOfStream {
+			closed = true
+			_ = cs.CloseSend()
+			continue
+		}
+
+		in := newMessage(desc.Input())
+		if err := codec.unmarshal(messageType, data, in); err != nil {
+			return status.New(codes.InvalidArgument, err.Error())
+		}
+		if err := cs.SendMsg(in); err != nil {
+			// the stream already finished, its status is returned by RecvMsg
+			closed = true
+			continue
+		}
+
+		if !desc.IsStreamingClient() {
+			closed = true
+			_ = cs.CloseSend()
+		}
+	}
+}
+
+// webSocketMetadata collects the headers and query parameters of the handshake as the grpc metadata
+func webSocketMetadata(r *http.Request) (metadata.MD, error) {
+	md, err := requestMetadata(r.Header, "Sec-Websocket-", "Upgrade")
+	if err != nil {
+		return nil, err
+	}
+
+	for k, vv := range r.URL.Query() {
+		k = strings.ToLower(k)
+		for _, v := range vv {
+			if strings.HasSuffix(k, "-bin") {
+				data, err := decodeBinaryHeader(v)
+				if err != nil {
+					return nil, err
+				}
+				v = string(data)
+			}
+			md.Append(k, v)
+		}
+	}
+
+	return md, nil
+}
+
+// closeWebSocket sends the close message carrying the status of the call
+func closeWebSocket(ws *websocket.Conn, st *status.Status) {
+	code := websocket.CloseNormalClosure
+	if st.Code() != codes.OK {
+		code = WebSocketCloseCodeOffset + int(st.Code())
+	}
+
+	reason := st.Message()
+	if len(reason) > webSocketMaxCloseReason {
+		reason = reason[:webSocketMaxCloseReason]
+	}
+
+	_ = ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(webSocketCloseTimeout))
+}
+
+// webSocketCodec encodes the messages of the negotiated subprotocol
+type webSocketCodec struct {
+	binary bool
+}
+
+func (c webSocketCodec) marshal(m proto.Message) (int, []byte, error) {
+	if c.binary {
+		data, err := proto.Marshal(m)
+		return websocket.BinaryMessage, data, err
+	}
+
+	data, err := protojson.Marshal(m)
+	return websocket.TextMessage, data, err
+}
+
+func (c webSocketCodec) unmarshal(messageType int, data []byte, m proto.Message) error {
+	if messageType == websocket.BinaryMessage {
+		return proto.Unmarshal(data, m)
+	}
+
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, m)
+}
@@ -0,0 +1,231 @@
+package multiplexer
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/grpc-ecosystem/go-grpc-middleware/util/metautils"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	hpb "google.golang.org/grpc/health/grpc_health_v1"
+	tpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/reflection"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type WebSocketSuite struct {
+	suite.Suite
+
+	health *health.Server
+	server *httptest.Server
+
+	mu       sync.Mutex
+	incoming metautils.NiceMD
+}
+
+func (s *WebSocketSuite) SetupTest() {
+	s.health = health.NewServer()
+	s.health.SetServingStatus("echo", hpb.HealthCheckResponse_SERVING)
+
+	grpcServer := grpc.NewServer(grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		s.mu.Lock()
+		s.incoming = metautils.ExtractIncoming(ss.Context())
+		s.mu.Unlock()
+
+		return handler(srv, ss)
+	}))
+	hpb.RegisterHealthServer(grpcServer, s.health)
+	tpb.RegisterTestServiceServer(grpcServer, &StreamingService{})
+	reflection.Register(grpcServer)
+
+	s.server = httptest.NewServer(Make(nil,
+		GRPCHandler(grpcServer),
+		WebSocketHandler(grpcServer),
+	))
+}
+
+func (s *WebSocketSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *WebSocketSuite) dial(path, protocol string) (*websocket.Conn, *http.Response, error) {
+	dialer := websocket.Dialer{}
+	if protocol != "" {
+		dialer.Subprotocols = []string{protocol}
+	}
+
+	header := http.Header{}
+	header.Set("X-Caller", "websocket")
+
+	return dialer.Dial("ws"+strings.TrimPrefix(s.server.URL, "http")+path, header)
+}
+
+// closeCode reads the messages until the WebSocket is closed and returns the close code
+func (s *WebSocketSuite) closeCode(ws *websocket.Conn) int {
+	for {
+		_, _, err := ws.ReadMessage()
+		if err != nil {
+			ce, ok := err.(*websocket.CloseError)
+			s.True(ok, err)
+			if !ok {
+				return 0
+			}
+			return ce.Code
+		}
+	}
+}
+
+func (s *WebSocketSuite) TestIsWebSocketRequest() {
+	request := func(method string, upgrade bool) *http.Request {
+		req := httptest.NewRequest(method, "http://localhost/grpc.health.v1.Health/Watch", nil)
+		if upgrade {
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+		}
+		return req
+	}
+
+	candidates := map[*http.Request]bool{
+		request(http.MethodGet, true):   true,
+		request(http.MethodGet, false):  false,
+		request(http.MethodPost, true):  false,
+		request(http.MethodPost, false): false,
+	}
+
+	for req, result := range candidates {
+		s.Equal(result, IsWebSocketRequest(req), "Request is badly considered a WebSocket request", req.Method, req.Header)
+	}
+}
+
+func (s *WebSocketSuite) TestBidiStreamJSON() {
+	ws, res, err := s.dial("/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo?authorization=token", "")
+	s.Require().NoError(err)
+	defer ws.Close()
+	s.Equal("", res.Header.Get("Sec-Websocket-Protocol"))
+
+	for i := 0; i < 2; i++ {
+		s.NoError(ws.WriteMessage(websocket.TextMessage, []byte(`{"listServices":"*"}`)))
+
+		messageType, data, err := ws.ReadMessage()
+		s.Require().NoError(err)
+		s.Equal(websocket.TextMessage, messageType)
+
+		out := &rpb.ServerReflectionResponse{}
+		s.NoError(protojson.Unmarshal(data, out))
+		s.NotEmpty(out.GetListServicesResponse().GetService())
+	}
+
+	// the end of stream message closes the client stream and the call finishes
+	s.NoError(ws.WriteMessage(websocket.TextMessage, []byte(WebSocketEndOfStream)))
+	s.Equal(websocket.CloseNormalClosure, s.closeCode(ws))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Equal("websocket", s.incoming.Get("x-caller"))
+	s.Equal("token", s.incoming.Get("authorization"))
+}
+
+func (s *WebSocketSuite) TestServerStreamProto() {
+	ws, res, err := s.dial("/grpc.health.v1.Health/Watch", WebSocketProtocolProto)
+	s.Require().NoError(err)
+	defer ws.Close()
+	s.Equal(WebSocketProtocolProto, res.Header.Get("Sec-Websocket-Protocol"))
+
+	data, err := proto.Marshal(&hpb.HealthCheckRequest{Service: "echo"})
+	s.NoError(err)
+	s.NoError(ws.WriteMessage(websocket.BinaryMessage, data))
+
+	messageType, data, err := ws.ReadMessage()
+	s.Require().NoError(err)
+	s.Equal(websocket.BinaryMessage, messageType)
+
+	out := &hpb.HealthCheckResponse{}
+	s.NoError(proto.Unmarshal(data, out))
+	s.Equal(hpb.HealthCheckResponse_SERVING, out.Status)
+
+	s.health.SetServingStatus("echo", hpb.HealthCheckResponse_NOT_SERVING)
+	_, data, err = ws.ReadMessage()
+	s.Require().NoError(err)
+	s.NoError(proto.Unmarshal(data, out))
+	s.Equal(hpb.HealthCheckResponse_NOT_SERVING, out.Status)
+
+	// closing the WebSocket by the client cancels the call
+	s.NoError(ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "")))
+	s.Equal(WebSocketCloseCodeOffset+int(codes.Canceled), s.closeCode(ws))
+}
+
+func (s *WebSocketSuite) TestClientStream() {
+	ws, _, err := s.dial("/grpc.testing.TestService/StreamingInputCall", WebSocketProtocolProto)
+	s.Require().NoError(err)
+	defer ws.Close()
+
+	for _, body := range []string{"Hello", "", "World!"} {
+		data, err := proto.Marshal(&tpb.StreamingInputCallRequest{Payload: &tpb.Payload{Body: []byte(body)}})
+		s.NoError(err)
+		s.NoError(ws.WriteMessage(websocket.BinaryMessage, data))
+	}
+
+	// messages of default values are empty, but they are still sent to the stream
+	s.NoError(ws.WriteMessage(websocket.BinaryMessage, nil))
+	s.NoError(ws.WriteMessage(websocket.TextMessage, []byte(WebSocketEndOfStream)))
+
+	_, data, err := ws.ReadMessage()
+	s.Require().NoError(err)
+
+	out := &tpb.StreamingInputCallResponse{}
+	s.NoError(proto.Unmarshal(data, out))
+	s.Equal(int32(11), out.AggregatedPayloadSize)
+
+	// the single response is followed by the normal closure
+	s.Equal(websocket.CloseNormalClosure, s.closeCode(ws))
+}
+
+func (s *WebSocketSuite) TestErrors() {
+	ws, _, err := s.dial("/grpc.health.v1.Health/Watch", WebSocketProtocolJSON)
+	s.Require().NoError(err)
+	defer ws.Close()
+
+	s.NoError(ws.WriteMessage(websocket.TextMessage, []byte(`{"service":1}`)))
+	s.Equal(WebSocketCloseCodeOffset+int(codes.InvalidArgument), s.closeCode(ws))
+
+	// unknown and unary methods are rejected by the handshake
+	_, res, err := s.dial("/grpc.health.v1.Health/Unknown", "")
+	s.Error(err)
+	s.Equal(http.StatusNotFound, res.StatusCode)
+
+	_, res, err = s.dial("/grpc.health.v1.Health/Check", "")
+	s.Error(err)
+	s.Equal(http.StatusBadRequest, res.StatusCode)
+
+	// methods known to the protobuf registry are rejected unless they are registered on the server
+	_, res, err = s.dial("/grpc.testing.UnimplementedService/UnimplementedCall", "")
+	s.Error(err)
+	s.Equal(http.StatusNotFound, res.StatusCode)
+}
+
+func (s *WebSocketSuite) TestReadLimit() {
+	grpcServer := grpc.NewServer()
+	tpb.RegisterTestServiceServer(grpcServer, &StreamingService{})
+
+	server := httptest.NewServer(Make(nil, NewWebSocketHandler(grpcServer, WithWebSocketReadLimit(64))))
+	defer server.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/grpc.testing.TestService/StreamingInputCall", nil)
+	s.Require().NoError(err)
+	defer ws.Close()
+
+	s.NoError(ws.WriteMessage(websocket.TextMessage, []byte(`{"payload":{"body":"AAAA"}}`)))
+	s.NoError(ws.WriteMessage(websocket.TextMessage, []byte(`{"payload":{"body":"`+strings.Repeat("A", 64)+`"}}`)))
+	s.Equal(websocket.CloseMessageTooBig, s.closeCode(ws))
+}
+
+func TestWebSocketSuite(t *testing.T) {
+	suite.Run(t, &WebSocketSuite{})
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/petomalina/xrpc/v2/pkg/multiplexer"
+	"golang.org/x/net/http/httpguts"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnknownJob is returned when triggering a job that is not registered
+	ErrUnknownJob = errors.New("unknown cron job")
+
+	// ErrInvalidMethod is returned when registering a job with a malformed http method
+	ErrInvalidMethod = errors.New("invalid http method")
+)
+
+// CronJob is a job invoking the handler on a schedule, the same way Cloud Scheduler
+// invokes HTTP targets
+type CronJob struct {
+	// Name of the job, sent in the X-CloudScheduler-JobName header
+	Name string
+	// Schedule is the cron expression of the job (see ParseSchedule)
+	Schedule string
+	// Path of the request, e.g. the grpc-gateway path of the invoked GRPC method
+	Path string
+	// Method of the request, POST by default
+	Method string
+	// Body of the request
+	Body []byte
+	// Header is added to the request headers
+	Header http.Header
+}
+
+type cronEntry struct {
+	job      CronJob
+	schedule *Schedule
+	next     time.Time
+}
+
+// CronObserver is notified about the response status of every job invocation
+type CronObserver func(job string, status int)
+
+// Cron is an in-process registry of jobs invoking the handler on a schedule. The
+// requests look like the ones sent by Cloud Scheduler, so the handler (e.g. the
+// multiplexer with a SchedulerHandler) serves them the same way locally as in
+// production
+type Cron struct {
+	mu      sync.Mutex
+	handler http.Handler
+	entries map[string]*cronEntry
+	// wake interrupts the wait of Run for the newly registered jobs
+	wake chan struct{}
+
+	observer CronObserver
+	now      func() time.Time
+}
+
+// CronOption is an extendable builder for the Cron options
+type CronOption func(c *Cron)
+
+// WithCronObserver sets the observer notified about the invocations of the jobs
+func WithCronObserver(observer CronObserver) CronOption {
+	return func(c *Cron) {
+		c.observer = observer
+	}
+}
+
+// NewCron creates a new Cron invoking the given handler
+func NewCron(handler http.Handler, opts ...CronOption) *Cron {
+	c := &Cron{
+		handler: handler,
+		entries: map[string]*cronEntry{},
+		wake:    make(chan struct{}, 1),
+		now:     time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Register adds the job to the registry, replacing the job of the same name
+func (c *Cron) Register(job CronJob) error {
+	schedule, err := ParseSchedule(job.Schedule)
+	if err != nil {
+		return fmt.Errorf("failed to register job %s: %w", job.Name, err)
+	}
+
+	// methods are tokens of the same syntax as the header field names
+	if job.Method != "" && !httpguts.ValidHeaderFieldName(job.Method) {
+		return fmt.Errorf("failed to register job %s: %w %q", job.Name, ErrInvalidMethod, job.Method)
+	}
+
+	if _, err := url.ParseRequestURI(job.Path); err != nil {
+		return fmt.Errorf("failed to register job %s: %w", job.Name, err)
+	}
+
+	c.mu.Lock()
+	c.entries[job.Name] = &cronEntry{
+		job:      job,
+		schedule: schedule,
+		next:     schedule.Next(c.now()),
+	}
+	c.mu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Trigger invokes the job immediately, regardless of its schedule, and returns the
+// response status of the handler
+func (c *Cron) Trigger(ctx context.Context, name string) (int, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[name]
+	c.mu.Unlock()
+
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownJob, name)
+	}
+
+	return c.invoke(ctx, entry.job, c.now())
+}
+
+// Run invokes the jobs on their schedules until the context is done. It sleeps until
+// the next scheduled job, and it waits for the running invocations before returning
+func (c *Cron) Run(ctx context.Context) error {
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
+	for {
+		due, wait := c.due()
+
+		for _, entry := range due {
+			wg.Add(1)
+			go func(entry cronEntry) {
+				defer wg.Done()
+				_, _ = c.invoke(ctx, entry.job, entry.next)
+			}(entry)
+		}
+
+		// without scheduled jobs, only the registration of a new job wakes the loop
+		var timer *time.Timer
+		var fired <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			fired = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-c.wake:
+		case <-fired:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// due returns the jobs scheduled until now with their schedule times and advances
+// their schedules. It also returns the duration until the next scheduled job, or zero
+// if no job is scheduled
+func (c *Cron) due() ([]cronEntry, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	due := []cronEntry{}
+	wait := time.Duration(0)
+
+	for _, entry := range c.entries {
+		if entry.next.IsZero() {
+			continue
+		}
+
+		if !entry.next.After(now) {
+			due = append(due, *entry)
+			entry.next = entry.schedule.Next(now)
+			if entry.next.IsZero() {
+				continue
+			}
+		}
+
+		if d := entry.next.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+
+	return due, wait
+}
+
+// invoke serves the scheduler-like request of the job by the handler
+func (c *Cron) invoke(ctx context.Context, job CronJob, scheduled time.Time) (int, error) {
+	method := job.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, job.Path, bytes.NewReader(job.Body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to invoke job %s: %w", job.Name, err)
+	}
+	req.RequestURI = req.URL.RequestURI()
+
+	for k, vv := range job.Header {
+		req.Header[k] = vv
+	}
+	req.Header.Set("User-Agent", multiplexer.SchedulerUserAgent)
+	req.Header.Set(multiplexer.SchedulerHeader, "true")
+	req.Header.Set(multiplexer.SchedulerJobNameHeader, job.Name)
+	req.Header.Set(multiplexer.SchedulerScheduleTimeHeader, scheduled.UTC().Format(time.RFC3339))
+
+	w := &cronResponseWriter{header: http.Header{}}
+	c.handler.ServeHTTP(w, req)
+
+	if c.observer != nil {
+		c.observer(job.Name, w.code())
+	}
+
+	return w.code(), nil
+}
+
+// cronResponseWriter records the response status of the job invocation and
+// discards the response body
+type cronResponseWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *cronResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *cronResponseWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return len(data), nil
+}
+
+func (w *cronResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// code returns the status of the response, 200 OK if the handler did not write one
+func (w *cronResponseWriter) code() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
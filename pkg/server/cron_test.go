@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/petomalina/xrpc/v2/pkg/multiplexer"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type CronSuite struct {
+	suite.Suite
+
+	now      time.Time
+	requests []*http.Request
+	bodies   []string
+	statuses map[string]int
+
+	cron *Cron
+}
+
+func (s *CronSuite) SetupTest() {
+	s.now = time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+	s.requests, s.bodies, s.statuses = nil, nil, map[string]int{}
+
+	s.cron = NewCron(multiplexer.Make(nil,
+		multiplexer.SchedulerHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := ioutil.ReadAll(r.Body)
+			s.requests = append(s.requests, r)
+			s.bodies = append(s.bodies, string(data))
+		})),
+	), WithCronObserver(func(job string, status int) {
+		s.statuses[job] = status
+	}))
+	s.cron.now = func() time.Time {
+		return s.now
+	}
+}
+
+func (s *CronSuite) TestRegister() {
+	err := s.cron.Register(CronJob{Name: "tick", Schedule: "* * *", Path: "/v1/echo"})
+	s.True(errors.Is(err, ErrInvalidSchedule))
+
+	err = s.cron.Register(CronJob{Name: "tick", Schedule: "* * * * *", Path: ":invalid"})
+	s.Error(err)
+
+	err = s.cron.Register(CronJob{Name: "tick", Schedule: "* * * * *", Path: "/v1/echo", Method: "GET /"})
+	s.True(errors.Is(err, ErrInvalidMethod))
+
+	s.NoError(s.cron.Register(CronJob{Name: "tick", Schedule: "* * * * *", Path: "/v1/echo", Method: http.MethodPut}))
+}
+
+func (s *CronSuite) TestTrigger() {
+	s.NoError(s.cron.Register(CronJob{
+		Name:     "tick",
+		Schedule: "@daily",
+		Path:     "/v1/echo",
+		Body:     []byte(`{"message":"tick"}`),
+		Header:   http.Header{"X-Custom": {"value"}},
+	}))
+
+	status, err := s.cron.Trigger(context.Background(), "tick")
+	s.NoError(err)
+	s.Equal(http.StatusOK, status)
+	s.Equal(http.StatusOK, s.statuses["tick"])
+
+	s.Len(s.requests, 1)
+	req := s.requests[0]
+	s.Equal(http.MethodPost, req.Method)
+	s.Equal("/v1/echo", req.URL.Path)
+	s.Equal(`{"message":"tick"}`, s.bodies[0])
+	s.Equal("value", req.Header.Get("X-Custom"))
+	s.Equal("tick", req.Header.Get("Grpc-Metadata-"+multiplexer.SchedulerAttributeHeader(multiplexer.SchedulerJobName)))
+	s.Equal("2020-09-13T12:26:40Z", req.Header.Get("Grpc-Metadata-"+multiplexer.SchedulerAttributeHeader(multiplexer.SchedulerScheduleTime)))
+
+	_, err = s.cron.Trigger(context.Background(), "unknown")
+	s.True(errors.Is(err, ErrUnknownJob))
+}
+
+func (s *CronSuite) TestDue() {
+	s.NoError(s.cron.Register(CronJob{Name: "quarter", Schedule: "*/15 * * * *", Path: "/v1/quarter"}))
+	s.NoError(s.cron.Register(CronJob{Name: "hourly", Schedule: "@hourly", Path: "/v1/hourly"}))
+
+	due, wait := s.cron.due()
+	s.Empty(due)
+	s.Equal(time.Minute*3+time.Second*20, wait)
+
+	s.now = time.Date(2020, 9, 13, 12, 30, 0, 0, time.UTC)
+	due, wait = s.cron.due()
+	s.Len(due, 1)
+	s.Equal("quarter", due[0].job.Name)
+	s.Equal(s.now, due[0].next)
+	s.Equal(time.Minute*15, wait)
+
+	s.now = time.Date(2020, 9, 13, 12, 59, 30, 0, time.UTC)
+	due, wait = s.cron.due()
+	s.Len(due, 1)
+	s.Equal(time.Second*30, wait)
+}
+
+func (s *CronSuite) TestRun() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	s.Equal(context.DeadlineExceeded, s.cron.Run(ctx))
+}
+
+func (s *CronSuite) TestRunSchedule() {
+	// the clock starts shortly before the next minute
+	start := time.Now()
+	s.cron.now = func() time.Time {
+		return time.Date(2020, 9, 13, 12, 26, 59, int(time.Millisecond*900), time.UTC).Add(time.Since(start))
+	}
+
+	finished := false
+	s.cron.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond * 100)
+		finished = true
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*150)
+	defer cancel()
+
+	errs := make(chan error)
+	go func() {
+		errs <- s.cron.Run(ctx)
+	}()
+
+	// jobs registered while running are scheduled without waiting for the loop
+	s.NoError(s.cron.Register(CronJob{Name: "tick", Schedule: "* * * * *", Path: "/v1/echo"}))
+
+	s.Equal(context.DeadlineExceeded, <-errs)
+	s.True(finished, "running invocations are waited for")
+}
+
+func TestCronSuite(t *testing.T) {
+	suite.Run(t, &CronSuite{})
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"errors"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type ScheduleSuite struct {
+	suite.Suite
+}
+
+func (s *ScheduleSuite) TestNext() {
+	// Sunday, 13 September 2020
+	from := time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+
+	candidates := map[string]time.Time{
+		"* * * * *":         time.Date(2020, 9, 13, 12, 27, 0, 0, time.UTC),
+		"*/15 * * * *":      time.Date(2020, 9, 13, 12, 30, 0, 0, time.UTC),
+		"0 * * * *":         time.Date(2020, 9, 13, 13, 0, 0, 0, time.UTC),
+		"@hourly":           time.Date(2020, 9, 13, 13, 0, 0, 0, time.UTC),
+		"@daily":            time.Date(2020, 9, 14, 0, 0, 0, 0, time.UTC),
+		"30 9 * * 1-5":      time.Date(2020, 9, 14, 9, 30, 0, 0, time.UTC),
+		"0 0 * * 7":         time.Date(2020, 9, 20, 0, 0, 0, 0, time.UTC),
+		"0 0 1 * *":         time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC),
+		"0 12 1,15 * *":     time.Date(2020, 9, 15, 12, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":        time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		"5-10/5 12 * * *":   time.Date(2020, 9, 14, 12, 5, 0, 0, time.UTC),
+		"0 0 13 * 5":        time.Date(2020, 9, 18, 0, 0, 0, 0, time.UTC),
+		"0 0 30 2 *":        {},
+		"0 0 1 1 *":         time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		"27 12 13 9 0":      time.Date(2020, 9, 13, 12, 27, 0, 0, time.UTC),
+		"40/10 12-13 * * *": time.Date(2020, 9, 13, 12, 40, 0, 0, time.UTC),
+	}
+
+	for spec, next := range candidates {
+		schedule, err := ParseSchedule(spec)
+		s.NoError(err, spec)
+		s.Equal(next, schedule.Next(from), spec)
+	}
+}
+
+func (s *ScheduleSuite) TestParseScheduleErrors() {
+	candidates := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"* * * SEP *",
+	}
+
+	for _, spec := range candidates {
+		_, err := ParseSchedule(spec)
+		s.True(errors.Is(err, ErrInvalidSchedule), spec)
+	}
+}
+
+func TestScheduleSuite(t *testing.T) {
+	suite.Run(t, &ScheduleSuite{})
+}